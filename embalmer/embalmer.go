@@ -27,6 +27,7 @@ import (
 
 type Embalmer struct {
 	Client                   *ethclient.Client
+	ChainID                  *big.Int
 	ArchAddress              common.Address
 	EmbalmerPrivateKey       *ecdsa.PrivateKey
 	EmbalmerAddress          common.Address
@@ -41,7 +42,10 @@ type Embalmer struct {
 }
 
 func (embalmer *Embalmer) initAuth() *bind.TransactOpts {
-	auth := bind.NewKeyedTransactor(embalmer.EmbalmerPrivateKey)
+	auth, err := bind.NewKeyedTransactorWithChainID(embalmer.EmbalmerPrivateKey, embalmer.ChainID)
+	if err != nil {
+		log.Fatalf("Could not create transaction signer: %v", err)
+	}
 	auth.Nonce = nil // uses nonce of pending state
 	auth.Value = big.NewInt(0)
 	auth.GasLimit = 0   // 0 estimates gas limit
@@ -99,7 +103,7 @@ func (embalmer *Embalmer) approveEmbalmerTransfer(session *contracts.TokenSessio
 	log.Printf("Approval Transaction for %v Sarcophagus Tokens successful. Transaction ID: %v", utility.ToDecimal(approvalAmount, 18), tx.Hash().Hex())
 	log.Printf("Gas Used for Approval: %v", tx.Gas())
 
-	err = ethereum.WaitMined(embalmer.Client, tx.Hash(), "Approval of Embalmer Transfer")
+	_, err = ethereum.WaitMined(context.Background(), embalmer.Client, tx.Hash(), "Approval of Embalmer Transfer", 1)
 
 	if err != nil {
 		log.Fatalf("There was an error mining the approval of embalmer transfer: %v", err)
@@ -144,7 +148,7 @@ func (embalmer *Embalmer) CreateSarcophagus(recipientPrivateKey string, assetDou
 	log.Printf("Create Sarcophagus Successful. Transaction ID: %s", tx.Hash().Hex())
 	log.Printf("Gas Used: %v", tx.Gas())
 
-	err = ethereum.WaitMined(embalmer.Client, tx.Hash(), "Approval of Embalmer Create Sarcophagus")
+	_, err = ethereum.WaitMined(context.Background(), embalmer.Client, tx.Hash(), "Approval of Embalmer Create Sarcophagus", 1)
 
 	if err != nil {
 		log.Fatalf("There was an error mining the approval of embalmer create sarcophagus: %v", err)
@@ -216,7 +220,7 @@ func (embalmer *Embalmer) UpdateSarcophagus(assetDoubleHash [32]byte, fileBytes
 	log.Printf("Update Sarcophagus Successful. Transaction ID: %s", tx.Hash().Hex())
 	log.Printf("Gas Used: %v", tx.Gas())
 
-	err = ethereum.WaitMined(embalmer.Client, tx.Hash(), "Embalmer Update Sarcophagus")
+	_, err = ethereum.WaitMined(context.Background(), embalmer.Client, tx.Hash(), "Embalmer Update Sarcophagus", 1)
 
 	if err != nil {
 		log.Fatalf("There was an error mining the approval of embalmer update sarcophagus: %v", err)
@@ -244,7 +248,7 @@ func (embalmer *Embalmer) RewrapSarcophagus(assetDoubleHash [32]byte, resurrecti
 	log.Printf("Rewrap Sarcophagus Successful. Transaction ID: %s", tx.Hash().Hex())
 	log.Printf("Gas Used: %v", tx.Gas())
 
-	err = ethereum.WaitMined(embalmer.Client, tx.Hash(), "Embalmer Rewrap Sarcophagus")
+	_, err = ethereum.WaitMined(context.Background(), embalmer.Client, tx.Hash(), "Embalmer Rewrap Sarcophagus", 1)
 
 	if err != nil {
 		log.Fatalf("There was an error mining the approval of embalmer rewrap sarcophagus: %v", err)
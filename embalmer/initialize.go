@@ -15,6 +15,7 @@ import (
 
 func InitEmbalmer(embalmer *Embalmer, config *EmbalmerConfig, resurrectionTime int64) {
 	embalmer.Client, _ = ethereum.InitEthClient(config.ETH_NODE)
+	embalmer.ChainID, _ = ethereum.ChainID(embalmer.Client, "")
 	embalmer.EmbalmerPrivateKey, _ = utility.PrivateKeyHexToECDSA(config.EMBALMER_PRIVATE_KEY)
 	archPrivateKey, _ := utility.PrivateKeyHexToECDSA(config.ARCH_PRIVATE_KEY)
 	embalmer.ArchAddress = utility.PrivateKeyToAddress(archPrivateKey)
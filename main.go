@@ -23,10 +23,20 @@ func main(){
 
 	// Load the config file values. Flag with config location is optional
 	var configFile = flag.String("config", "config", "Location of the config file.")
+	var listJobs = flag.Bool("jobs", false, "List the upcoming and failed unwrap jobs and exit.")
 	configDir := "./"
 	flag.Parse()
 
 	config := new(models.Config)
+
+	if *listJobs {
+		config.LoadConfig(*configFile, configDir, false)
+		if err := archaeologist.PrintUnwrapJobs(config); err != nil {
+			log.Fatalf("Could not list unwrap jobs: %v", err)
+		}
+		return
+	}
+
 	config.LoadConfig(*configFile, configDir, true)
 
 	// Initialize the Archaeologist with values from the config
@@ -47,9 +57,20 @@ func main(){
 
 	log.Printf("Eth Balance: %v", utility.ToDecimal(arch.EthBalance(), 18))
 	log.Printf("Sarco Token Balance: %v", utility.ToDecimal(arch.SarcoBalance(), 18))
-	log.Println("Arweave Balance:", utility.ToDecimal(ar.ArweaveBalance(arch.ArweaveTransactor.Client.(*api.Client), arch.ArweaveWallet), 12))
+	arweaveBalance, err := ar.ArweaveBalance(arch.ArweaveTransactor.Client.(*api.Client), arch.ArweaveWallet)
+	if err != nil {
+		log.Printf("Could not get Arweave Balance: %v", err)
+	} else {
+		log.Println("Arweave Balance:", utility.ToDecimal(arweaveBalance, 12))
+	}
 	log.Printf("Arweave Address: %v", arch.ArweaveWallet.Address())
 
+	// Clean up our own sarcophagi that were not unwrapped in time
+	archaeologist.StartCleaner(arch)
+
+	// Clean up expired sarcophagi of other archaeologists, if enabled
+	archaeologist.StartSweeper(arch)
+
 	// Listen for contract events
 	archaeologist.EventsSubscribe(arch)
 }
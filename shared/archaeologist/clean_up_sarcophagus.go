@@ -0,0 +1,225 @@
+// Cleanups of our own sarcophagi that were not unwrapped before their resurrection window closed.
+// The cleaner runs every CLEANUP_INTERVAL while the service is running. It moves expired sarcophagi out of state
+// and queues a cleanup for each of them.
+// Queued cleanups are saved to the store with the outcome of each attempt, and are retried until the sarcophagus is done.
+//
+// The cleaner runs alongside the event processor, so it leaves the account index to it: a sarcophagus whose update is on
+// the contract but has not been applied to state (e.g. it is waiting for confirmations) is skipped until the event
+// processor has applied it, as the key pair it used up could not be given back if the update was removed by a reorg.
+
+package archaeologist
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/contracts"
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/ethereum"
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/models"
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/utility"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"log"
+	"math/big"
+	"time"
+)
+
+const (
+	cleanUpsBucket       = "cleanups"
+	CLEANUP_INTERVAL     = 5 * time.Minute
+	MAX_CLEANUP_ATTEMPTS = 10
+)
+
+// cleanUpRecord is a queued cleanup and the outcome of its last attempt
+type cleanUpRecord struct {
+	Attempts    int
+	LastAttempt time.Time
+	LastError   string
+	Done        bool // the sarcophagus has been cleaned up (or is otherwise done)
+	Failed      bool // no more attempts will be made
+}
+
+// cleanUpBackend is the part of the contract used to clean up sarcophagi
+type cleanUpBackend interface {
+	Sarcophagus(identifier [32]byte) (contracts.TypesSarcophagus, error)
+	CleanUp(identifier [32]byte) error
+}
+
+type cleaner struct {
+	arch    *models.Archaeologist
+	backend cleanUpBackend
+}
+
+// StartCleaner runs the cleaner every CLEANUP_INTERVAL
+func StartCleaner(arch *models.Archaeologist) {
+	c := &cleaner{arch: arch, backend: &archCleanUpBackend{arch, "Cleanup Sarcophagus"}}
+
+	go func() {
+		for {
+			c.run(time.Now())
+			time.Sleep(CLEANUP_INTERVAL)
+		}
+	}()
+}
+
+// queueCleanUp saves a cleanup for the sarcophagus, unless one is already queued
+func queueCleanUp(arch *models.Archaeologist, doubleHash [32]byte) {
+	key := hexutil.Encode(doubleHash[:])
+	if ok, _ := arch.Store.Get(cleanUpsBucket, key, &cleanUpRecord{}); ok {
+		return
+	}
+
+	log.Printf("Sarcophagus did not get unwrapped in time, queueing cleanup: %v", key)
+	if err := arch.Store.Put(cleanUpsBucket, key, cleanUpRecord{}); err != nil {
+		log.Printf("Error saving cleanup to %v: %v", arch.Store.Path(), err)
+	}
+}
+
+// run queues cleanups for sarcophagi in state whose window has closed, then attempts every queued cleanup
+func (c *cleaner) run(now time.Time) {
+	c.expire(now)
+
+	for doubleHash, record := range c.queued() {
+		c.attempt(doubleHash, record, now)
+	}
+}
+
+// expire moves sarcophagi whose window has closed out of state
+func (c *cleaner) expire(now time.Time) {
+	sarcophaguses, _, _ := c.arch.Registry.Export()
+
+	for doubleHash, sarco := range sarcophaguses {
+		window := sarco.ResurrectionWindow
+		if window == nil {
+			window = big.NewInt(0)
+		}
+		if time.Unix(new(big.Int).Add(sarco.ResurrectionTime, window).Int64(), 0).After(now) {
+			continue
+		}
+
+		contractSarco, err := c.backend.Sarcophagus(doubleHash)
+		if err != nil {
+			log.Printf("Call to Sarcophagus in Contract failed for %v: %v", doubleHash, err)
+			continue
+		}
+
+		if contractSarco.State == 1 && windowEnd(contractSarco).After(now) {
+			// rewrapped, the rewrap event has not been processed yet
+			continue
+		}
+
+		// The update has not been applied by the event processor yet, it is expired on a later run once it has been
+		if contractSarco.AssetId != "" && !sarco.Updated {
+			continue
+		}
+
+		c.arch.RemoveArchSarcophagus(doubleHash)
+		if contractSarco.State == 1 {
+			queueCleanUp(c.arch, doubleHash)
+		}
+	}
+}
+
+// queued returns the cleanups that still need to be attempted
+func (c *cleaner) queued() map[[32]byte]cleanUpRecord {
+	queued := map[[32]byte]cleanUpRecord{}
+
+	err := c.arch.Store.ForEach(cleanUpsBucket, func(key string, raw []byte) error {
+		var record cleanUpRecord
+		if err := json.Unmarshal(raw, &record); err != nil {
+			return fmt.Errorf("could not decode cleanup %v: %v", key, err)
+		}
+		if record.Done || record.Failed {
+			return nil
+		}
+
+		identifier, err := hexutil.Decode(key)
+		if err != nil || len(identifier) != 32 {
+			return fmt.Errorf("invalid cleanup identifier: %v", key)
+		}
+
+		var doubleHash [32]byte
+		copy(doubleHash[:], identifier)
+		queued[doubleHash] = record
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error reading cleanups from %v: %v", c.arch.Store.Path(), err)
+	}
+
+	return queued
+}
+
+// attempt cleans up the sarcophagus and saves the outcome
+func (c *cleaner) attempt(doubleHash [32]byte, record cleanUpRecord, now time.Time) {
+	key := hexutil.Encode(doubleHash[:])
+
+	err := c.cleanUp(doubleHash)
+	record.Attempts += 1
+	record.LastAttempt = now
+
+	switch {
+	case err == nil:
+		record.Done = true
+		record.LastError = ""
+		log.Printf("Cleaned up the sarcophagus %v", key)
+	case record.Attempts >= MAX_CLEANUP_ATTEMPTS:
+		record.Failed = true
+		record.LastError = err.Error()
+		utility.Alert("Cleanup of the sarcophagus %v failed after %v attempts: %v. No more attempts will be made", key, record.Attempts, err)
+	default:
+		record.LastError = err.Error()
+		log.Printf("Cleanup attempt %v of the sarcophagus %v failed: %v. Retrying in %v", record.Attempts, key, err, CLEANUP_INTERVAL)
+	}
+
+	if err := c.arch.Store.Put(cleanUpsBucket, key, record); err != nil {
+		log.Printf("Error saving cleanup to %v: %v", c.arch.Store.Path(), err)
+	}
+}
+
+// cleanUp returns nil once the sarcophagus is done, whether or not our cleanup transaction is what finished it
+func (c *cleaner) cleanUp(doubleHash [32]byte) error {
+	sarco, err := c.backend.Sarcophagus(doubleHash)
+	if err != nil {
+		return err
+	}
+	if sarco.State != 1 {
+		return nil
+	}
+
+	err = c.backend.CleanUp(doubleHash)
+	if err != ethereum.ErrReverted {
+		return err
+	}
+
+	// Someone else may have cleaned it up first
+	sarco, stateErr := c.backend.Sarcophagus(doubleHash)
+	if stateErr == nil && sarco.State != 1 {
+		return nil
+	}
+	return err
+}
+
+// archCleanUpBackend cleans up with the archaeologist's contract session and transaction manager
+type archCleanUpBackend struct {
+	arch  *models.Archaeologist
+	label string
+}
+
+func (b *archCleanUpBackend) Sarcophagus(identifier [32]byte) (contracts.TypesSarcophagus, error) {
+	return b.arch.SarcoSession.Sarcophagus(identifier)
+}
+
+func (b *archCleanUpBackend) CleanUp(identifier [32]byte) error {
+	tx, err := b.arch.TxManager.Submit(b.label, func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return b.arch.SarcoSession.Contract.CleanUpSarcophagus(opts, identifier, b.arch.PaymentAddress)
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("%v Tx Submitted. Transaction ID: %s", b.label, tx.Hash().Hex())
+	_, err = b.arch.TxManager.Wait(context.Background(), tx.Hash())
+	return err
+}
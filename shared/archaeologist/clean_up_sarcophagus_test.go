@@ -0,0 +1,121 @@
+package archaeologist
+
+import (
+	"errors"
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/contracts"
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/ethereum"
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/models"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"testing"
+	"time"
+)
+
+type fakeCleanUpBackend struct {
+	sarcophagi map[[32]byte]contracts.TypesSarcophagus
+	errs       map[[32]byte]error
+	cleaned    [][32]byte
+}
+
+func (b *fakeCleanUpBackend) Sarcophagus(identifier [32]byte) (contracts.TypesSarcophagus, error) {
+	return b.sarcophagi[identifier], nil
+}
+
+func (b *fakeCleanUpBackend) CleanUp(identifier [32]byte) error {
+	if err, ok := b.errs[identifier]; ok {
+		return err
+	}
+
+	b.cleaned = append(b.cleaned, identifier)
+	sarco := b.sarcophagi[identifier]
+	sarco.State = 2
+	b.sarcophagi[identifier] = sarco
+	return nil
+}
+
+func cleanUpRecordOf(t *testing.T, c *cleaner, doubleHash [32]byte) cleanUpRecord {
+	var record cleanUpRecord
+	ok, err := c.arch.Store.Get(cleanUpsBucket, hexutil.Encode(doubleHash[:]), &record)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	return record
+}
+
+func TestCleanerCleansUpExpiredSarcophagi(t *testing.T) {
+	arch := testProcessor(t, 0).arch
+	now := time.Now()
+	window := big.NewInt(int64(time.Hour / time.Second))
+	expired := big.NewInt(now.Add(-2 * time.Hour).Unix())
+	future := big.NewInt(now.Add(time.Hour).Unix())
+
+	updated, unconfirmedUpdate, failing, pending := [32]byte{1}, [32]byte{2}, [32]byte{3}, [32]byte{4}
+	arch.Registry.Replace(map[[32]byte]*models.Sarco{
+		updated:      {ResurrectionTime: expired, ResurrectionWindow: window, AccountIndex: 0, Updated: true},
+		unconfirmedUpdate: {ResurrectionTime: expired, ResurrectionWindow: window, AccountIndex: 1, Updated: false},
+		failing:      {ResurrectionTime: expired, ResurrectionWindow: window, AccountIndex: 1, Updated: false},
+		pending:      {ResurrectionTime: future, ResurrectionWindow: window, AccountIndex: 1, Updated: false},
+	}, map[[32]byte]*big.Int{unconfirmedUpdate: big.NewInt(1), failing: big.NewInt(1), pending: big.NewInt(1)}, 1)
+
+	backend := &fakeCleanUpBackend{
+		sarcophagi: map[[32]byte]contracts.TypesSarcophagus{
+			updated:      {State: 1, AssetId: "asset", ResurrectionTime: expired, ResurrectionWindow: window},
+			unconfirmedUpdate: {State: 1, AssetId: "asset", ResurrectionTime: expired, ResurrectionWindow: window},
+			failing:      {State: 1, ResurrectionTime: expired, ResurrectionWindow: window},
+			pending:      {State: 1, ResurrectionTime: future, ResurrectionWindow: window},
+		},
+		errs: map[[32]byte]error{failing: errors.New("insufficient funds")},
+	}
+	c := &cleaner{arch: arch, backend: backend}
+
+	c.run(now)
+
+	// expired sarcophagi are moved out of state, except the one whose update is waiting for confirmations
+	assert.Equal(t, 2, arch.Registry.Len())
+	assert.True(t, arch.Registry.Exists(pending))
+	assert.True(t, arch.Registry.Exists(unconfirmedUpdate))
+	assert.Equal(t, 1, arch.Registry.AccountIndex())
+	assert.Equal(t, [][32]byte{updated}, backend.cleaned)
+	assert.True(t, cleanUpRecordOf(t, c, updated).Done)
+	record := cleanUpRecordOf(t, c, failing)
+	assert.False(t, record.Done)
+	assert.Equal(t, 1, record.Attempts)
+	assert.Equal(t, "insufficient funds", record.LastError)
+
+	// the event processor applies the update once it is confirmed, using up the key pair, and it is then cleaned up
+	arch.Registry.MarkUpdated(unconfirmedUpdate)
+	assert.Equal(t, 2, arch.Registry.AccountIndex())
+	c.run(now)
+	assert.Equal(t, 1, arch.Registry.Len())
+	assert.Equal(t, [][32]byte{updated, unconfirmedUpdate}, backend.cleaned)
+
+	// failed cleanups are retried, and given up on after MAX_CLEANUP_ATTEMPTS
+	for i := 2; i < MAX_CLEANUP_ATTEMPTS; i++ {
+		c.run(now)
+	}
+	record = cleanUpRecordOf(t, c, failing)
+	assert.True(t, record.Failed)
+	assert.Equal(t, MAX_CLEANUP_ATTEMPTS, record.Attempts)
+	assert.Len(t, c.queued(), 0)
+}
+
+func TestCleanUpRevertedBecauseAlreadyCleanedUp(t *testing.T) {
+	arch := testProcessor(t, 0).arch
+	doubleHash := [32]byte{1}
+
+	backend := &fakeCleanUpBackend{
+		sarcophagi: map[[32]byte]contracts.TypesSarcophagus{doubleHash: {State: 1}},
+		errs:       map[[32]byte]error{doubleHash: ethereum.ErrReverted},
+	}
+	c := &cleaner{arch: arch, backend: backend}
+
+	queueCleanUp(arch, doubleHash)
+	assert.Equal(t, ethereum.ErrReverted, c.cleanUp(doubleHash))
+
+	// another cleaner got there first
+	backend.sarcophagi[doubleHash] = contracts.TypesSarcophagus{State: 2}
+	c.run(time.Now())
+
+	assert.True(t, cleanUpRecordOf(t, c, doubleHash).Done)
+	assert.Len(t, backend.cleaned, 0)
+}
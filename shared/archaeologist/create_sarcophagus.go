@@ -1,10 +1,10 @@
 package archaeologist
 
 import (
-	"bytes"
 	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/contracts"
 	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/models"
 	"log"
+	"math/big"
 )
 
 // handleCreateSarcophagus gets called when the createSarcophagus event is emitted on the sarcophagus contract
@@ -23,32 +23,47 @@ func handleCreateSarcophagus(event *contracts.EventsCreateSarcophagus, arch *mod
 	log.Println("CursedBond:", event.CursedBond)
 	log.Println("CurrentPublicKey:", event.ArchaeologistPublicKey)
 
-	// The public key on the event must match the current public key on the archaeologist
-	if !bytes.Equal(event.ArchaeologistPublicKey, arch.CurrentPublicKeyBytes) {
-		log.Printf("Public Key on Sarcophagus does not match current Public Key : %v. Not listening for file.", arch.CurrentPublicKeyBytes)
+	countSarcophagus(arch, event.Identifier)
+
+	sarco, _ := arch.SarcoSession.Sarcophagus(event.Identifier)
+	if sarco.State != 1 {
+		log.Printf("Created Sarco state is not 1, not adding sarco to state")
 		return
 	}
 
-	// check if sarcophagus already exists in state, in case of a replayed duplicate event
-	if arch.IsArchSarcophagus(event.Identifier) {
+	// add sarcophagus to state and open the endpoint for the file to be received from the embalmer
+	// The public key on the event must match the current public key on the archaeologist,
+	// and the sarcophagus must not already exist in state, in case of a replayed duplicate event
+	err := arch.Registry.Create(event.Identifier, event.ArchaeologistPublicKey, event.ResurrectionTime, event.ResurrectionWindow, event.StorageFee)
+	switch err {
+	case models.ErrPublicKeyMismatch:
+		log.Printf("Public Key on Sarcophagus does not match current Public Key : %v. Not listening for file.", arch.Registry.CurrentPublicKeyBytes())
+		return
+	case models.ErrSarcoExists:
 		log.Printf("The sarcophagus for this double hash already exists: %v", event.Identifier)
 		return
 	}
 
-	sarco, _ := arch.SarcoSession.Sarcophagus(event.Identifier)
-	if sarco.State != 1 {
-		log.Printf("Created Sarco state is not 1, not adding sarco to state")
+	arch.PersistState()
+}
+
+// countSarcophagus moves the saved count of indexed sarcophagi past the created sarcophagus,
+// so it is not processed again from the contract on the next restart
+// Create events are handled in the order they were emitted, so the sarcophagus is the next one to be indexed,
+// unless it was already indexed when the service started (e.g. a replayed event).
+func countSarcophagus(arch *models.Archaeologist, identifier [32]byte) {
+	count, err := arch.SarcophagusCount()
+	if err != nil {
+		log.Printf("Error reading the sarcophagus count from state: %v", err)
 		return
 	}
 
-	// add sarcophagus to state
-	arch.Sarcophaguses[event.Identifier] = &models.Sarco{
-		ResurrectionTime: event.ResurrectionTime,
-		AccountIndex:     arch.AccountIndex,
-		Updated:          false,
-		UnwrapAttempts:   0,
+	next, err := arch.SarcoSession.ArchaeologistSarcophagusIdentifier(arch.ArchAddress, count)
+	if err != nil || next != identifier {
+		return
 	}
 
-	// open the endpoint for the file to be received from the embalmer
-	arch.FileHandlers[event.Identifier] = event.StorageFee
+	if err := arch.SetSarcophagusCount(new(big.Int).Add(count, big.NewInt(1))); err != nil {
+		log.Printf("Error saving the sarcophagus count to state: %v", err)
+	}
 }
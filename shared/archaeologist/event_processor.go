@@ -0,0 +1,357 @@
+// Event processor is responsible for:
+// 1. Handing contract events to the correct handler, whether they come from a replay or a live subscription
+// 2. Making sure each event is only handled once, keyed by transaction hash and log index
+// 3. Holding events until they have EVENT_CONFIRMATIONS confirmations, and undoing events removed by a chain reorg
+// 4. Saving the last fully processed block, so events emitted while the service is down can be replayed
+
+package archaeologist
+
+import (
+	"context"
+	"fmt"
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/contracts"
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/models"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"log"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+const (
+	REPLAY_BLOCK_RANGE = 5000 // maximum number of blocks requested from the node in one filter call during a replay
+	SEEN_BLOCK_WINDOW  = 128  // number of blocks behind the checkpoint that handled events are remembered for, to catch overlapping deliveries
+)
+
+// HANDLED_EVENTS are the contract events the archaeologist acts on
+var HANDLED_EVENTS = []string{
+	"CreateSarcophagus",
+	"UpdateSarcophagus",
+	"RewrapSarcophagus",
+	"CleanUpSarcophagus",
+	"BurySarcophagus",
+	"CancelSarcophagus",
+	"AccuseArchaeologist",
+}
+
+// logKey uniquely identifies a contract event
+type logKey struct {
+	txHash common.Hash
+	index  uint
+}
+
+// contractEvent is a decoded contract event together with the log it was decoded from
+type contractEvent struct {
+	raw   types.Log
+	value interface{}
+}
+
+// eventBackend is the part of the eth client used to find the current block and filter past events
+type eventBackend interface {
+	BlockNumber(ctx context.Context) (uint64, error)
+	FilterLogs(ctx context.Context, query ethereum.FilterQuery) ([]types.Log, error)
+}
+
+type eventProcessor struct {
+	arch          *models.Archaeologist
+	backend       eventBackend
+	sarcoEvents   *contracts.Events // bound to the current eth client, replaced when the client is redialed
+	archAddress   []common.Address
+	eventNames    map[common.Hash]string // handled event names, keyed by event signature topic
+	confirmations uint64
+	head          uint64
+	checkpoint    uint64
+	seen          map[logKey]uint64
+	pending       []contractEvent // events waiting for confirmations, in the order they were emitted
+	applied       []appliedEvent  // events applied to state that can still be undone
+}
+
+// newEventProcessor loads the last processed block from state.
+// If none has been saved, processing starts from the current block.
+func newEventProcessor(arch *models.Archaeologist, backend eventBackend) (*eventProcessor, error) {
+	p := &eventProcessor{
+		arch:          arch,
+		backend:       backend,
+		archAddress:   []common.Address{arch.ArchAddress},
+		confirmations: arch.EventConfirmations,
+		eventNames:    map[common.Hash]string{},
+		seen:          map[logKey]uint64{},
+	}
+
+	parsed, err := abi.JSON(strings.NewReader(contracts.EventsABI))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse Events ABI: %v", err)
+	}
+	for _, name := range HANDLED_EVENTS {
+		p.eventNames[parsed.Events[name].ID] = name
+	}
+
+	checkpoint, ok, err := arch.LastProcessedBlock()
+	if err != nil {
+		return nil, fmt.Errorf("could not load last processed block from state: %v", err)
+	}
+
+	if ok {
+		p.checkpoint = checkpoint
+		return p, nil
+	}
+
+	head, err := backend.BlockNumber(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("could not get current block number: %v", err)
+	}
+	p.setCheckpoint(head)
+
+	return p, nil
+}
+
+// replayToHead handles every event emitted between the last processed block and the current block
+func (p *eventProcessor) replayToHead() error {
+	head, err := p.backend.BlockNumber(context.Background())
+	if err != nil {
+		return fmt.Errorf("could not get current block number: %v", err)
+	}
+
+	if head > p.checkpoint {
+		log.Printf("Replaying events from block %v to block %v", p.checkpoint+1, head)
+	}
+
+	return p.processToBlock(head)
+}
+
+// pollEvents handles every event emitted since the last poll
+func (p *eventProcessor) pollEvents() error {
+	head, err := p.backend.BlockNumber(context.Background())
+	if err != nil {
+		return fmt.Errorf("could not get current block number: %v", err)
+	}
+
+	return p.processToBlock(head)
+}
+
+// processToBlock filters and handles the events emitted after the last processed block up to head
+func (p *eventProcessor) processToBlock(head uint64) error {
+	p.setHead(head)
+
+	for start := p.checkpoint + 1; start <= head; start += REPLAY_BLOCK_RANGE {
+		end := start + REPLAY_BLOCK_RANGE - 1
+		if end > head {
+			end = head
+		}
+
+		events, err := p.filterEvents(start, end)
+		if err != nil {
+			return fmt.Errorf("could not filter events from block %v to block %v: %v", start, end, err)
+		}
+
+		for _, event := range events {
+			p.process(event.raw, event.value)
+		}
+
+		p.advanceCheckpoint(end)
+	}
+
+	return nil
+}
+
+// filterEvents returns all events relevant to the archaeologist between start and end (inclusive),
+// in the order they were emitted
+// The logs for every handled event are requested in a single FilterLogs call and decoded by their event signature,
+// instead of one generated Filter* call per event, so a range costs one request and events of different types stay in order
+func (p *eventProcessor) filterEvents(start uint64, end uint64) ([]contractEvent, error) {
+	query := ethereum.FilterQuery{
+		FromBlock: new(big.Int).SetUint64(start),
+		ToBlock:   new(big.Int).SetUint64(end),
+		Addresses: []common.Address{p.arch.SarcoAddress},
+		Topics:    [][]common.Hash{p.eventTopics()},
+	}
+
+	logs, err := p.backend.FilterLogs(context.Background(), query)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []contractEvent
+	for _, raw := range logs {
+		value, err := p.parseLog(raw)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode log in transaction %s: %v", raw.TxHash.Hex(), err)
+		}
+
+		if value != nil {
+			events = append(events, contractEvent{raw, value})
+		}
+	}
+
+	return events, nil
+}
+
+// eventTopics returns the signature topic of each handled event
+func (p *eventProcessor) eventTopics() []common.Hash {
+	var topics []common.Hash
+	for topic := range p.eventNames {
+		topics = append(topics, topic)
+	}
+
+	return topics
+}
+
+// parseLog decodes a log into its contract event
+// Returns nil if the event is not relevant to the archaeologist
+func (p *eventProcessor) parseLog(raw types.Log) (interface{}, error) {
+	if len(raw.Topics) == 0 {
+		return nil, nil
+	}
+
+	switch p.eventNames[raw.Topics[0]] {
+	case "CreateSarcophagus":
+		event, err := p.sarcoEvents.ParseCreateSarcophagus(raw)
+		if err != nil || event.Archaeologist != p.arch.ArchAddress {
+			return nil, err
+		}
+		return event, nil
+	case "UpdateSarcophagus":
+		return p.sarcoEvents.ParseUpdateSarcophagus(raw)
+	case "RewrapSarcophagus":
+		return p.sarcoEvents.ParseRewrapSarcophagus(raw)
+	case "CleanUpSarcophagus":
+		return p.sarcoEvents.ParseCleanUpSarcophagus(raw)
+	case "BurySarcophagus":
+		return p.sarcoEvents.ParseBurySarcophagus(raw)
+	case "CancelSarcophagus":
+		return p.sarcoEvents.ParseCancelSarcophagus(raw)
+	case "AccuseArchaeologist":
+		return p.sarcoEvents.ParseAccuseArchaeologist(raw)
+	}
+
+	return nil, nil
+}
+
+// process queues an event until it is confirmed, unless the event has already been handled
+// A removed log means the event was undone by a chain reorg
+func (p *eventProcessor) process(raw types.Log, value interface{}) {
+	if raw.Removed {
+		p.rollback(raw)
+		return
+	}
+
+	key := logKey{raw.TxHash, raw.Index}
+	if _, ok := p.seen[key]; ok {
+		return
+	}
+	p.seen[key] = raw.BlockNumber
+
+	p.pending = append(p.pending, contractEvent{raw, value})
+	sort.SliceStable(p.pending, func(i, j int) bool {
+		if p.pending[i].raw.BlockNumber != p.pending[j].raw.BlockNumber {
+			return p.pending[i].raw.BlockNumber < p.pending[j].raw.BlockNumber
+		}
+		return p.pending[i].raw.Index < p.pending[j].raw.Index
+	})
+
+	// The block of a new event is at least the current head
+	p.setHead(raw.BlockNumber)
+}
+
+// setHead moves the known head forward and applies the events that are now confirmed
+func (p *eventProcessor) setHead(head uint64) {
+	if head > p.head {
+		p.head = head
+	}
+
+	confirmed := 0
+	for _, event := range p.pending {
+		if event.raw.BlockNumber+p.confirmations > p.head {
+			break
+		}
+		p.apply(event)
+		confirmed += 1
+	}
+	p.pending = p.pending[confirmed:]
+}
+
+// dispatch calls the handler for the event type
+func (p *eventProcessor) dispatch(value interface{}) {
+	arch := p.arch
+
+	switch event := value.(type) {
+	case *contracts.EventsCreateSarcophagus:
+		// create events are only filtered for sarcophagi related to this archaeologist
+		// update and rewrap events are not, so those have manual filtering before handling the event
+		handleCreateSarcophagus(event, arch)
+	case *contracts.EventsUpdateSarcophagus:
+		if arch.IsArchSarcophagus(event.Identifier) {
+			handleUpdateSarcophagus(event, arch)
+		}
+	case *contracts.EventsRewrapSarcophagus:
+		if arch.IsArchSarcophagus(event.Identifier) {
+			handleRewrapSarcophagus(event, arch)
+		}
+	// these events indicate a sarcophagus is 'done'
+	// and can be removed from state
+	case *contracts.EventsCleanUpSarcophagus:
+		arch.RemoveArchSarcophagus(event.Identifier)
+	case *contracts.EventsBurySarcophagus:
+		arch.RemoveArchSarcophagus(event.Identifier)
+	case *contracts.EventsCancelSarcophagus:
+		arch.RemoveArchSarcophagus(event.Identifier)
+	case *contracts.EventsAccuseArchaeologist:
+		arch.RemoveArchSarcophagus(event.Identifier)
+	}
+}
+
+// pollHead applies events that have been confirmed since the last poll, and marks blocks before the current block as processed.
+// Called periodically so events are applied and the checkpoint keeps moving while no new events are emitted.
+func (p *eventProcessor) pollHead() {
+	head, err := p.backend.BlockNumber(context.Background())
+	if err != nil {
+		log.Printf("Error getting current block number: %v", err)
+		return
+	}
+
+	p.setHead(head)
+	if head > 0 {
+		p.advanceCheckpoint(head - 1)
+	}
+}
+
+// advanceCheckpoint marks blocks up to block as processed,
+// stopping before the first event still waiting for confirmations so it is replayed if the service restarts
+func (p *eventProcessor) advanceCheckpoint(block uint64) {
+	if len(p.pending) > 0 && p.pending[0].raw.BlockNumber <= block {
+		block = p.pending[0].raw.BlockNumber - 1
+	}
+
+	p.setCheckpoint(block)
+}
+
+// setCheckpoint saves the last processed block if it moved forward
+// and forgets handled events that are too old to be replayed or removed by a reorg
+func (p *eventProcessor) setCheckpoint(block uint64) {
+	if block <= p.checkpoint {
+		return
+	}
+
+	p.checkpoint = block
+	if err := p.arch.SetLastProcessedBlock(block); err != nil {
+		log.Printf("Error saving last processed block to state: %v", err)
+	}
+
+	for key, blockNumber := range p.seen {
+		if blockNumber+SEEN_BLOCK_WINDOW <= block {
+			delete(p.seen, key)
+		}
+	}
+
+	expired := 0
+	for _, applied := range p.applied {
+		if applied.block+SEEN_BLOCK_WINDOW > block {
+			break
+		}
+		expired += 1
+	}
+	p.applied = p.applied[expired:]
+}
@@ -0,0 +1,134 @@
+package archaeologist
+
+import (
+	"context"
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/contracts"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+// fakeEventBackend returns the logs in the requested block range and records the ranges requested
+type fakeEventBackend struct {
+	head   uint64
+	logs   []types.Log
+	ranges [][2]uint64
+}
+
+func (b *fakeEventBackend) BlockNumber(ctx context.Context) (uint64, error) {
+	return b.head, nil
+}
+
+func (b *fakeEventBackend) FilterLogs(ctx context.Context, query ethereum.FilterQuery) ([]types.Log, error) {
+	from, to := query.FromBlock.Uint64(), query.ToBlock.Uint64()
+	b.ranges = append(b.ranges, [2]uint64{from, to})
+
+	var logs []types.Log
+	for _, raw := range b.logs {
+		if raw.BlockNumber >= from && raw.BlockNumber <= to {
+			logs = append(logs, raw)
+		}
+	}
+	return logs, nil
+}
+
+// cancelLog returns the log of a CancelSarcophagus event emitted in the block
+func cancelLog(t *testing.T, block uint64, txHash string, identifier [32]byte) types.Log {
+	parsed, err := abi.JSON(strings.NewReader(contracts.EventsABI))
+	assert.Nil(t, err)
+
+	return types.Log{
+		Topics:      []common.Hash{parsed.Events["CancelSarcophagus"].ID, identifier},
+		BlockNumber: block,
+		TxHash:      common.HexToHash(txHash),
+	}
+}
+
+// testReplayProcessor returns an event processor that resumes from the checkpoint saved in state
+func testReplayProcessor(t *testing.T, confirmations uint64, checkpoint uint64, backend *fakeEventBackend) *eventProcessor {
+	arch := testProcessor(t, confirmations).arch
+	arch.EventConfirmations = confirmations
+	assert.Nil(t, arch.SetLastProcessedBlock(checkpoint))
+
+	p, err := newEventProcessor(arch, backend)
+	assert.Nil(t, err)
+
+	p.sarcoEvents, err = contracts.NewEvents(arch.SarcoAddress, nil)
+	assert.Nil(t, err)
+	return p
+}
+
+func TestNewEventProcessorStartsFromHead(t *testing.T) {
+	arch := testProcessor(t, 0).arch
+
+	p, err := newEventProcessor(arch, &fakeEventBackend{head: 500})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(500), p.checkpoint)
+
+	saved, ok, err := arch.LastProcessedBlock()
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(500), saved)
+}
+
+func TestReplayFromCheckpointInChunks(t *testing.T) {
+	head := uint64(100 + 2*REPLAY_BLOCK_RANGE + 10)
+	backend := &fakeEventBackend{head: head}
+	p := testReplayProcessor(t, 0, 100, backend)
+
+	assert.Nil(t, p.replayToHead())
+
+	assert.Equal(t, [][2]uint64{
+		{101, 100 + REPLAY_BLOCK_RANGE},
+		{101 + REPLAY_BLOCK_RANGE, 100 + 2*REPLAY_BLOCK_RANGE},
+		{101 + 2*REPLAY_BLOCK_RANGE, head},
+	}, backend.ranges)
+
+	// the checkpoint is saved, so a restart resumes from the head
+	assert.Equal(t, head, p.checkpoint)
+	saved, _, err := p.arch.LastProcessedBlock()
+	assert.Nil(t, err)
+	assert.Equal(t, head, saved)
+
+	backend.ranges = nil
+	restarted, err := newEventProcessor(p.arch, backend)
+	assert.Nil(t, err)
+	assert.Nil(t, restarted.replayToHead())
+	assert.Empty(t, backend.ranges)
+}
+
+func TestReplayedEventIsHandledOnce(t *testing.T) {
+	raw := cancelLog(t, 105, "0x01", [32]byte{1})
+	backend := &fakeEventBackend{head: 110, logs: []types.Log{raw}}
+	p := testReplayProcessor(t, 10, 100, backend)
+
+	assert.Nil(t, p.replayToHead())
+	assert.Len(t, p.pending, 1)
+
+	// the checkpoint stops before the event waiting for confirmations
+	assert.Equal(t, uint64(104), p.checkpoint)
+	saved, _, err := p.arch.LastProcessedBlock()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(104), saved)
+
+	// the live subscription delivers the same log
+	value, err := p.parseLog(raw)
+	assert.Nil(t, err)
+	p.process(raw, value)
+	assert.Len(t, p.pending, 1)
+
+	// the next replay overlaps the event
+	backend.head = 114
+	assert.Nil(t, p.pollEvents())
+	assert.Len(t, p.pending, 1)
+
+	// the event is applied once confirmed, and the checkpoint moves past it
+	backend.head = 115
+	assert.Nil(t, p.pollEvents())
+	assert.Len(t, p.pending, 0)
+	assert.Equal(t, uint64(115), p.checkpoint)
+}
@@ -1,166 +1,337 @@
 // Responsible for subscribing to all relevant events that get emitted from the contract
 // and acting on those events
+// Events are either received from websocket subscriptions, or by polling the node for logs if it only supports http
+// If the subscriptions drop (or polling fails), the eth client is redialed and listening is restarted with exponential backoff
 
 package archaeologist
 
 import (
+	"fmt"
 	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/contracts"
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/ethereum"
 	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/models"
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/utility"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/event"
 	"log"
+	"time"
 )
 
+const (
+	EVENT_SOURCE_SUBSCRIBE = "subscribe" // events are received from websocket subscriptions
+	EVENT_SOURCE_POLL      = "poll"      // events are requested from the node with FilterLogs every EVENT_POLL_INTERVAL
+)
+
+const (
+	HEAD_POLL_INTERVAL      = 15 * time.Second // how often the current block is checked to apply confirmed events and save the last processed block
+	RESUBSCRIBE_MIN_BACKOFF = 1 * time.Second  // wait before the first attempt to reconnect after the subscriptions drop
+	RESUBSCRIBE_MAX_BACKOFF = 2 * time.Minute  // maximum wait between attempts to reconnect
+	OUTAGE_ALERT_ATTEMPTS   = 5                // failed reconnect attempts after which the outage is alerted again
+)
+
+// eventSubscriptions holds the live subscriptions to each contract event the archaeologist handles
+type eventSubscriptions struct {
+	createSink chan *contracts.EventsCreateSarcophagus
+	updateSink chan *contracts.EventsUpdateSarcophagus
+	rewrapSink chan *contracts.EventsRewrapSarcophagus
+	cleanSink  chan *contracts.EventsCleanUpSarcophagus
+	burySink   chan *contracts.EventsBurySarcophagus
+	cancelSink chan *contracts.EventsCancelSarcophagus
+	accuseSink chan *contracts.EventsAccuseArchaeologist
+	subs       []event.Subscription
+
+	// errs receives the first error of every subscription
+	errs chan error
+}
+
 // watchCreateSarcophagus .
-func watchCreateSarcophagus(sarcoEvents *contracts.Events, archAddress []common.Address) (chan *contracts.EventsCreateSarcophagus, event.Subscription) {
+func watchCreateSarcophagus(sarcoEvents *contracts.Events, archAddress []common.Address) (chan *contracts.EventsCreateSarcophagus, event.Subscription, error) {
 	sink := make(chan *contracts.EventsCreateSarcophagus)
 	sub, err := sarcoEvents.WatchCreateSarcophagus(&bind.WatchOpts{}, sink, nil, archAddress)
 	if err != nil {
-		log.Fatalf("Error subscribing to CreateSarcophagus event: %v", err)
+		return nil, nil, fmt.Errorf("error subscribing to CreateSarcophagus event: %v", err)
 	}
 
-	return sink, sub
+	return sink, sub, nil
 }
 
 // watchUpdateSarcophagus .
-func watchUpdateSarcophagus(sarcoEvents *contracts.Events) (chan *contracts.EventsUpdateSarcophagus, event.Subscription) {
+func watchUpdateSarcophagus(sarcoEvents *contracts.Events) (chan *contracts.EventsUpdateSarcophagus, event.Subscription, error) {
 	sink := make(chan *contracts.EventsUpdateSarcophagus)
 	sub, err := sarcoEvents.WatchUpdateSarcophagus(&bind.WatchOpts{}, sink, nil)
 	if err != nil {
-		log.Fatalf("Error subscribing to UpdateSarcophagus event: %v", err)
+		return nil, nil, fmt.Errorf("error subscribing to UpdateSarcophagus event: %v", err)
 	}
 
-	return sink, sub
+	return sink, sub, nil
 }
 
 // watchRewrapSarcophagus .
-func watchRewrapSarcophagus(sarcoEvents *contracts.Events) (chan *contracts.EventsRewrapSarcophagus, event.Subscription) {
+func watchRewrapSarcophagus(sarcoEvents *contracts.Events) (chan *contracts.EventsRewrapSarcophagus, event.Subscription, error) {
 	sink := make(chan *contracts.EventsRewrapSarcophagus)
 	sub, err := sarcoEvents.WatchRewrapSarcophagus(&bind.WatchOpts{}, sink, nil)
 	if err != nil {
-		log.Fatalf("Error subscribing to RewrapSarcophagus event: %v", err)
+		return nil, nil, fmt.Errorf("error subscribing to RewrapSarcophagus event: %v", err)
 	}
 
-	return sink, sub
+	return sink, sub, nil
 }
 
 // watchCleanUpSarcophagus .
-func watchCleanUpSarcophagus(sarcoEvents *contracts.Events) (chan *contracts.EventsCleanUpSarcophagus, event.Subscription) {
+func watchCleanUpSarcophagus(sarcoEvents *contracts.Events) (chan *contracts.EventsCleanUpSarcophagus, event.Subscription, error) {
 	sink := make(chan *contracts.EventsCleanUpSarcophagus)
 	sub, err := sarcoEvents.WatchCleanUpSarcophagus(&bind.WatchOpts{}, sink, nil, nil)
 	if err != nil {
-		log.Fatalf("Error subscribing to CleanUp Sarcophagus event: %v", err)
+		return nil, nil, fmt.Errorf("error subscribing to CleanUp Sarcophagus event: %v", err)
 	}
 
-	return sink, sub
+	return sink, sub, nil
 }
 
 // watchBurySarcophagus .
-func watchBurySarcophagus(sarcoEvents *contracts.Events) (chan *contracts.EventsBurySarcophagus, event.Subscription) {
+func watchBurySarcophagus(sarcoEvents *contracts.Events) (chan *contracts.EventsBurySarcophagus, event.Subscription, error) {
 	sink := make(chan *contracts.EventsBurySarcophagus)
 	sub, err := sarcoEvents.WatchBurySarcophagus(&bind.WatchOpts{}, sink, nil)
 	if err != nil {
-		log.Fatalf("Error subscribing to Bury Sarcophagus event: %v", err)
+		return nil, nil, fmt.Errorf("error subscribing to Bury Sarcophagus event: %v", err)
 	}
 
-	return sink, sub
+	return sink, sub, nil
 }
 
 // watchCancelSarcophagus .
-func watchCancelSarcophagus(sarcoEvents *contracts.Events) (chan *contracts.EventsCancelSarcophagus, event.Subscription) {
+func watchCancelSarcophagus(sarcoEvents *contracts.Events) (chan *contracts.EventsCancelSarcophagus, event.Subscription, error) {
 	sink := make(chan *contracts.EventsCancelSarcophagus)
 	sub, err := sarcoEvents.WatchCancelSarcophagus(&bind.WatchOpts{}, sink, nil)
 	if err != nil {
-		log.Fatalf("Error subscribing to Cancel Sarcophagus event: %v", err)
+		return nil, nil, fmt.Errorf("error subscribing to Cancel Sarcophagus event: %v", err)
 	}
 
-	return sink, sub
+	return sink, sub, nil
 }
 
 // watchAccuseArchaeologist .
-func watchAccuseArchaeologist(sarcoEvents *contracts.Events) (chan *contracts.EventsAccuseArchaeologist, event.Subscription) {
+func watchAccuseArchaeologist(sarcoEvents *contracts.Events) (chan *contracts.EventsAccuseArchaeologist, event.Subscription, error) {
 	sink := make(chan *contracts.EventsAccuseArchaeologist)
 	sub, err := sarcoEvents.WatchAccuseArchaeologist(&bind.WatchOpts{}, sink, nil, nil)
 	if err != nil {
-		log.Fatalf("Error subscribing to Accuse Archaeologist event: %v", err)
+		return nil, nil, fmt.Errorf("error subscribing to Accuse Archaeologist event: %v", err)
+	}
+
+	return sink, sub, nil
+}
+
+// subscribeEvents creates all seven event subscriptions
+// If any of them fails, the ones already created are unsubscribed
+func subscribeEvents(sarcoEvents *contracts.Events, archAddress []common.Address) (*eventSubscriptions, error) {
+	s := &eventSubscriptions{}
+	var sub event.Subscription
+	var err error
+
+	if s.createSink, sub, err = watchCreateSarcophagus(sarcoEvents, archAddress); err != nil {
+		return nil, s.fail(err)
+	}
+	s.subs = append(s.subs, sub)
+
+	if s.updateSink, sub, err = watchUpdateSarcophagus(sarcoEvents); err != nil {
+		return nil, s.fail(err)
+	}
+	s.subs = append(s.subs, sub)
+
+	if s.rewrapSink, sub, err = watchRewrapSarcophagus(sarcoEvents); err != nil {
+		return nil, s.fail(err)
+	}
+	s.subs = append(s.subs, sub)
+
+	if s.cleanSink, sub, err = watchCleanUpSarcophagus(sarcoEvents); err != nil {
+		return nil, s.fail(err)
+	}
+	s.subs = append(s.subs, sub)
+
+	if s.burySink, sub, err = watchBurySarcophagus(sarcoEvents); err != nil {
+		return nil, s.fail(err)
+	}
+	s.subs = append(s.subs, sub)
+
+	if s.cancelSink, sub, err = watchCancelSarcophagus(sarcoEvents); err != nil {
+		return nil, s.fail(err)
+	}
+	s.subs = append(s.subs, sub)
+
+	if s.accuseSink, sub, err = watchAccuseArchaeologist(sarcoEvents); err != nil {
+		return nil, s.fail(err)
+	}
+	s.subs = append(s.subs, sub)
+
+	// Merge the error channels so a failure of any subscription can be selected on
+	s.errs = make(chan error, len(s.subs))
+	for _, sub := range s.subs {
+		go func(sub event.Subscription) {
+			err, ok := <-sub.Err()
+			if !ok || err == nil {
+				err = fmt.Errorf("subscription closed")
+			}
+			s.errs <- err
+		}(sub)
 	}
 
-	return sink, sub
+	return s, nil
+}
+
+// fail unsubscribes the subscriptions created so far and returns err
+func (s *eventSubscriptions) fail(err error) error {
+	s.unsubscribe()
+	return err
+}
+
+// unsubscribe .
+func (s *eventSubscriptions) unsubscribe() {
+	for _, sub := range s.subs {
+		sub.Unsubscribe()
+	}
 }
 
 // EventsSubscribe subscribes to the events and handles them
+// Runs for the life of the service. When a subscription drops or polling fails, the outage is reported,
+// the eth client is redialed with exponential backoff and listening is restarted.
+// Events emitted while the service was not listening are replayed before listening resumes.
 func EventsSubscribe(arch *models.Archaeologist) {
+	processor, err := newEventProcessor(arch, arch.Client)
+	if err != nil {
+		log.Fatalf("Error loading event processor: %v", err)
+	}
+
+	listen := listenForEvents
+	if arch.EventSource == EVENT_SOURCE_POLL {
+		listen = pollForEvents
+	}
+
+	backoff := utility.NewBackoff(RESUBSCRIBE_MIN_BACKOFF, RESUBSCRIBE_MAX_BACKOFF)
+
+	for {
+		err := listen(arch, processor, backoff)
+		arch.Subscriptions.SetDown(err)
+		utility.Alert("Contract events are not being received, new jobs and rewraps will not be seen until the eth node reconnects: %v", err)
+
+		for {
+			wait := backoff.Next()
+			log.Printf("Reconnecting to the eth node in %v", wait)
+			time.Sleep(wait)
+
+			err := reconnectEthClient(arch)
+			if err == nil {
+				break
+			}
+
+			log.Printf("Could not reconnect to the eth node: %v", err)
+			if backoff.Attempts()%OUTAGE_ALERT_ATTEMPTS == 0 {
+				utility.Alert("Still unable to reconnect to the eth node after %v attempts: %v", backoff.Attempts(), err)
+			}
+		}
+	}
+}
+
+// listenForEvents subscribes to the contract events, replays any events missed since the last processed block,
+// and handles events until a subscription fails. Returns the subscription error.
+func listenForEvents(arch *models.Archaeologist, processor *eventProcessor, backoff *utility.Backoff) error {
 	sarcoEvents, err := contracts.NewEvents(arch.SarcoAddress, arch.Client)
 	if err != nil {
-		log.Printf("Error instantiating Events in EventsSubscribe: %v", err)
+		return fmt.Errorf("error instantiating Events: %v", err)
+	}
+	processor.sarcoEvents = sarcoEvents
+
+	subs, err := subscribeEvents(sarcoEvents, processor.archAddress)
+	if err != nil {
+		return err
+	}
+	defer subs.unsubscribe()
+
+	// Subscriptions are started before the replay so no event falls between the two.
+	// Events delivered by both are only handled once.
+	if err := processor.replayToHead(); err != nil {
+		return fmt.Errorf("error replaying missed events: %v", err)
 	}
 
-	/* Add arch address to slice for filtering purposes */
-	var archAddress = []common.Address{arch.ArchAddress}
+	arch.Subscriptions.SetAlive()
+	backoff.Reset()
 
-	createSink, createSub := watchCreateSarcophagus(sarcoEvents, archAddress)
-	updateSink, updateSub := watchUpdateSarcophagus(sarcoEvents)
-	rewrapSink, rewrapSub := watchRewrapSarcophagus(sarcoEvents)
-	cleanSink, cleanSub := watchCleanUpSarcophagus(sarcoEvents)
-	burySink, burySub := watchBurySarcophagus(sarcoEvents)
-	cancelSink, cancelSub := watchCancelSarcophagus(sarcoEvents)
-	accuseSink, accuseSub := watchAccuseArchaeologist(sarcoEvents)
+	headTicker := time.NewTicker(HEAD_POLL_INTERVAL)
+	defer headTicker.Stop()
 
 	log.Println("Listening For Events...")
 
 	for {
 		select {
-		case err := <-createSub.Err():
-			if err != nil {
-				log.Println("Error with Create Sarcophagus Subscription:", err)
-			}
-		case err := <-updateSub.Err():
-			if err != nil {
-				log.Println("Error with Update Sarcophagus Subscription:", err)
-			}
-		case err := <-rewrapSub.Err():
-			if err != nil {
-				log.Println("Error with Rewrap Sarcophagus Subscription:", err)
-			}
-		case err := <-cleanSub.Err():
-			if err != nil {
-				log.Println("Error with Clean Sarcophagus Subscription:", err)
-			}
-		case err := <-burySub.Err():
-			if err != nil {
-				log.Println("Error with Bury Sarcophagus Subscription:", err)
-			}
-		case err := <-cancelSub.Err():
-			if err != nil {
-				log.Println("Error with Cancel Sarcophagus Subscription:", err)
-			}
-		case err := <-accuseSub.Err():
-			if err != nil {
-				log.Println("Error with Accuse Archaeologist Subscription:", err)
-			}
-		case event := <-createSink:
-			// the createSink only returns sarcophagi related to this archaeologist
-			// updateSink and rewrapSink do not, so those have manual filtering before handling the event
-			go handleCreateSarcophagus(event, arch)
-		case event := <-updateSink:
-			if arch.IsArchSarcophagus(event.Identifier) {
-				go handleUpdateSarcophagus(event, arch)
-			}
-		case event := <-rewrapSink:
-			if arch.IsArchSarcophagus(event.Identifier) {
-				go handleRewrapSarcophagus(event, arch)
-			}
-		// these events indicate a sarcophagus is 'done'
-		// and can be removed from state
-		case event := <-cleanSink:
-			arch.RemoveArchSarcophagus(event.Identifier)
-		case event := <-burySink:
-			arch.RemoveArchSarcophagus(event.Identifier)
-		case event := <-cancelSink:
-			arch.RemoveArchSarcophagus(event.Identifier)
-		case event := <-accuseSink:
-			arch.RemoveArchSarcophagus(event.Identifier)
+		case err := <-subs.errs:
+			return err
+		case <-headTicker.C:
+			processor.pollHead()
+		case event := <-subs.createSink:
+			processor.process(event.Raw, event)
+		case event := <-subs.updateSink:
+			processor.process(event.Raw, event)
+		case event := <-subs.rewrapSink:
+			processor.process(event.Raw, event)
+		case event := <-subs.cleanSink:
+			processor.process(event.Raw, event)
+		case event := <-subs.burySink:
+			processor.process(event.Raw, event)
+		case event := <-subs.cancelSink:
+			processor.process(event.Raw, event)
+		case event := <-subs.accuseSink:
+			processor.process(event.Raw, event)
+		}
+	}
+}
+
+// pollForEvents replays any events missed since the last processed block,
+// then polls the node for new events every EVENT_POLL_INTERVAL until a poll fails. Returns the polling error.
+// Polling does not deliver logs removed by a chain reorg, so EVENT_CONFIRMATIONS should be set when using it.
+func pollForEvents(arch *models.Archaeologist, processor *eventProcessor, backoff *utility.Backoff) error {
+	sarcoEvents, err := contracts.NewEvents(arch.SarcoAddress, arch.Client)
+	if err != nil {
+		return fmt.Errorf("error instantiating Events: %v", err)
+	}
+	processor.sarcoEvents = sarcoEvents
+
+	if err := processor.replayToHead(); err != nil {
+		return fmt.Errorf("error replaying missed events: %v", err)
+	}
+
+	arch.Subscriptions.SetAlive()
+	backoff.Reset()
+
+	pollTicker := time.NewTicker(arch.EventPollInterval)
+	defer pollTicker.Stop()
+
+	log.Printf("Polling For Events every %v...", arch.EventPollInterval)
+
+	for range pollTicker.C {
+		if err := processor.pollEvents(); err != nil {
+			return fmt.Errorf("error polling for events: %v", err)
 		}
 	}
+
+	return nil
+}
+
+// reconnectEthClient redials the eth node and swaps the connection used by the eth client
+// The contract sessions and the transaction manager are bound to the eth client, so they use the new connection straight away.
+func reconnectEthClient(arch *models.Archaeologist) error {
+	client, err := ethereum.InitEthClient(arch.EthNode)
+	if err != nil {
+		return err
+	}
+
+	// Transactions are signed for the chain ID the service started on
+	if _, err := ethereum.ChainID(client, arch.ChainID.String()); err != nil {
+		client.Close()
+		return err
+	}
+
+	arch.Client.Replace(client)
+
+	log.Printf("Reconnected to the eth node")
+	return nil
 }
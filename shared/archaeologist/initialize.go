@@ -8,20 +8,49 @@ import (
 	"bytes"
 	"context"
 	"crypto/ecdsa"
+	"crypto/tls"
 	"fmt"
-	"github.com/Dev43/arweave-go/api"
 	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/contracts"
 	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/arweave"
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/certs"
 	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/ethereum"
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/gas"
 	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/hdw"
 	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/models"
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/price"
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/scheduler"
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/store"
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/txmanager"
 	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/utility"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/ethclient"
 	hdwallet "github.com/miguelmota/go-ethereum-hdwallet"
 	"github.com/shopspring/decimal"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/time/rate"
 	"log"
 	"math/big"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+
+const (
+	DEFAULT_STATE_FILE           = "archaeologist_state.json" // used when STATE_FILE is not set in the config file
+	DEFAULT_PAYLOAD_DIR          = "archaeologist_payloads"   // used when PAYLOAD_DIR is not set in the config file
+	DEFAULT_EVENT_POLL_INTERVAL  = 15 * time.Second           // used when EVENT_POLL_INTERVAL is not set in the config file
+	DEFAULT_TX_CONFIRMATIONS     = 1                          // used when TX_CONFIRMATIONS is not set in the config file
+	DEFAULT_TX_TIMEOUT           = 10 * time.Minute           // used when TX_TIMEOUT is not set in the config file
+	DEFAULT_GAS_PRICE_MULTIPLIER = "1.0"                      // used when GAS_PRICE_MULTIPLIER is not set in the config file
+	DEFAULT_MAX_FILE_SIZE        = 3 * models.MB              // used when MAX_FILE_SIZE is not set in the config file
+	DEFAULT_ACME_DIRECTORY_URL   = acme.LetsEncryptURL        // used when ACME_DIRECTORY_URL is not set in the config file
+	DEFAULT_ACME_CACHE_DIR       = "archaeologist_certs"      // used when ACME_CACHE_DIR is not set in the config file
+	DEFAULT_RATE_LIMIT           = "1"                        // used when RATE_LIMIT is not set in the config file
+	DEFAULT_RATE_LIMIT_BURST     = 5                          // used when RATE_LIMIT_BURST is not set in the config file
+	DEFAULT_ALLOWED_ORIGINS      = "*"                        // used when ALLOWED_ORIGINS is not set in the config file
 )
 
 // InitializeArchaeologist Sets archaeologist struct fields.
@@ -35,11 +64,57 @@ func InitializeArchaeologist(arch *models.Archaeologist, config *models.Config)
 		errStrings = append(errStrings, err.Error())
 	}
 
-	arch.Client, err = ethereum.InitEthClient(config.ETH_NODE)
+	arch.EthNode = config.ETH_NODE
+	ethClient, err := ethereum.InitEthClient(config.ETH_NODE)
+	if err != nil {
+		errStrings = append(errStrings, err.Error())
+	}
+	arch.Client = ethereum.NewClient(ethClient)
+
+	arch.ChainID, err = ethereum.ChainID(arch.Client, config.CHAIN_ID)
+	if err != nil {
+		errStrings = append(errStrings, err.Error())
+	}
+
+	arch.Subscriptions = new(models.SubscriptionStatus)
+	arch.EventConfirmations, err = parseEventConfirmations(config.EVENT_CONFIRMATIONS)
+	if err != nil {
+		errStrings = append(errStrings, err.Error())
+	}
+
+	arch.EventSource, err = eventSource(config.EVENT_SOURCE, config.ETH_NODE)
+	if err != nil {
+		errStrings = append(errStrings, err.Error())
+	}
+
+	arch.EventPollInterval, err = eventPollInterval(config.EVENT_POLL_INTERVAL)
 	if err != nil {
 		errStrings = append(errStrings, err.Error())
 	}
 
+	txConfirmations, err := parseTxConfirmations(config.TX_CONFIRMATIONS)
+	if err != nil {
+		errStrings = append(errStrings, err.Error())
+	}
+
+	txTimeout, err := parseTxTimeout(config.TX_TIMEOUT)
+	if err != nil {
+		errStrings = append(errStrings, err.Error())
+	}
+
+	arch.SweepInterval, arch.SarcoEthPrice, arch.SweepMinProfit, err = parseSweeper(config)
+	if err != nil {
+		errStrings = append(errStrings, err.Error())
+	}
+
+	gasStrategy, err := parseGasStrategy(config)
+	if err != nil {
+		errStrings = append(errStrings, err.Error())
+	}
+
+	utility.SetAlertWebhook(config.ALERT_WEBHOOK)
+
+	arch.ArweaveNode = config.ARWEAVE_NODE
 	arch.ArweaveTransactor, err = ar.InitArweaveTransactor(config.ARWEAVE_NODE)
 	if err != nil {
 		errStrings = append(errStrings, err.Error())
@@ -61,12 +136,17 @@ func InitializeArchaeologist(arch *models.Archaeologist, config *models.Config)
 		errStrings = append(errStrings, err.Error())
 	}
 
-	arch.SarcoSession, err = initSarcophagusSession(arch.SarcoAddress, arch.Client, arch.PrivateKey)
+	arch.SarcoSession, err = initSarcophagusSession(arch.SarcoAddress, arch.Client, arch.PrivateKey, arch.ChainID)
 	if err != nil {
 		errStrings = append(errStrings, err.Error())
 	}
 
-	arch.TokenSession, err = initTokenSession(config.TOKEN_ADDRESS, arch.Client, arch.PrivateKey)
+	arch.SarcoTokenAddress, err = ethereum.TokenAddress(config.TOKEN_ADDRESS, arch.Client)
+	if err != nil {
+		errStrings = append(errStrings, err.Error())
+	}
+
+	arch.TokenSession, err = initTokenSession(arch.SarcoTokenAddress, arch.Client, arch.PrivateKey, arch.ChainID)
 	if err != nil {
 		errStrings = append(errStrings, err.Error())
 	}
@@ -114,125 +194,645 @@ func InitializeArchaeologist(arch *models.Archaeologist, config *models.Config)
 
 	arch.FilePort = config.FILE_PORT
 
-	arch.Sarcophaguses, arch.FileHandlers, arch.AccountIndex = buildSarcophagusesState(arch)
+	arch.ListenAddress, err = parseListenAddress(config.LISTEN_ADDRESS, config.FILE_PORT)
+	if err != nil {
+		errStrings = append(errStrings, err.Error())
+	}
 
-	arch.CurrentPrivateKey = hdw.PrivateKeyFromIndex(arch.Wallet, arch.AccountIndex)
-	arch.CurrentPublicKeyBytes = hdw.PublicKeyBytesFromIndex(arch.Wallet, arch.AccountIndex)
+	arch.TLSConfig, err = parseTLS(config)
+	if err != nil {
+		errStrings = append(errStrings, err.Error())
+	}
+
+	arch.RateLimiter, err = parseRateLimiter(config)
+	if err != nil {
+		errStrings = append(errStrings, err.Error())
+	}
+
+	arch.AllowedOrigins, err = parseAllowedOrigins(config.ALLOWED_ORIGINS)
+	if err != nil {
+		errStrings = append(errStrings, err.Error())
+	}
+
+	arch.MaxFileSize, err = parseMaxFileSize(config.MAX_FILE_SIZE)
+	if err != nil {
+		errStrings = append(errStrings, err.Error())
+	}
+
+	arch.SarcoArPrice, arch.LossTolerance, err = parseLossProtection(config)
+	if err != nil {
+		errStrings = append(errStrings, err.Error())
+	}
+
+	arch.Store, err = store.Open(stateFilePath(config.STATE_FILE))
+	if err != nil {
+		errStrings = append(errStrings, err.Error())
+	}
+
+	arch.PayloadDir = config.PAYLOAD_DIR
+	if arch.PayloadDir == "" {
+		arch.PayloadDir = DEFAULT_PAYLOAD_DIR
+	}
+
+	// State cannot be built without a working client, contract session and store
+	if len(errStrings) > 0 {
+		return errStrings
+	}
+
+	if arch.TxManager != nil {
+		arch.TxManager.Stop()
+	}
+	arch.TxManager, err = txmanager.New(arch.Client, arch.Store, arch.SarcoSession.TransactOpts, gasStrategy, txConfirmations, txTimeout)
+	if err != nil {
+		errStrings = append(errStrings, fmt.Sprintf("could not load pending transactions from %v: %v", arch.Store.Path(), err))
+		return errStrings
+	}
+	arch.TxManager.Start()
+
+	arch.Registry = models.NewSarcoRegistry(arch.Wallet)
+	if arch.UnwrapScheduler != nil {
+		arch.UnwrapScheduler.Stop()
+	}
+	arch.UnwrapScheduler, err = scheduler.New(arch.Store)
+	if err != nil {
+		errStrings = append(errStrings, fmt.Sprintf("could not load unwrap jobs from %v: %v", arch.Store.Path(), err))
+		return errStrings
+	}
+
+	initSarcophagusesState(arch)
+	arch.UnwrapScheduler.Start(newUnwrapRunner(arch))
+
+	arch.CurrentPrivateKey = hdw.PrivateKeyFromIndex(arch.Wallet, arch.Registry.AccountIndex())
 
 	return errStrings
 }
 
-func buildSarcophagusesState (arch *models.Archaeologist) (map[[32]byte]*models.Sarco, map[[32]byte]*big.Int, int) {
-	var sarcophaguses = map[[32]byte]*models.Sarco{}
-	var fileHandlers = map[[32]byte]*big.Int{}
+// initSarcophagusesState loads the sarcophagi state saved by a previous run and reconciles it against the contract.
+// If no state has been saved yet, the state is built by walking all of the archaeologist's sarcophagi on the contract.
+// The resulting state is saved so the next restart only needs to reconcile.
+func initSarcophagusesState(arch *models.Archaeologist) {
+	// Events emitted from this block onwards are not reflected in the state read from the contract below
+	head, err := arch.Client.BlockNumber(context.Background())
+	if err != nil {
+		log.Fatalf("Could not get current block number from the eth node: %v", err)
+	}
 
-	// Create a slice of sarcos (double hashes) indexed by public keys
-	var pubKeyMap = map[[64]byte][][32]byte{}
+	loaded, err := arch.LoadState()
+	if err != nil {
+		log.Fatalf("Could not load state from %v. Please fix or remove the file and restart: %v", arch.Store.Path(), err)
+	}
 
-	var accountIndex = 0
+	var builder *stateBuilder
+	var from *big.Int
+
+	if loaded {
+		log.Printf("Loaded state from %v, reconciling with the contract", arch.Store.Path())
+		sarcophaguses, fileHandlers, accountIndex := arch.Registry.Export()
+		builder = newStateBuilder(arch, sarcophaguses, fileHandlers, accountIndex)
+		builder.reconcile()
+
+		from, err = arch.SarcophagusCount()
+		if err != nil {
+			log.Fatalf("Could not load state from %v. Please fix or remove the file and restart: %v", arch.Store.Path(), err)
+		}
+	} else {
+		log.Printf("No saved state found at %v, building state from the contract", arch.Store.Path())
+		builder = newStateBuilder(arch, map[[32]byte]*models.Sarco{}, map[[32]byte]*big.Int{}, 0)
+		from = big.NewInt(0)
+	}
 
 	sarcoCount, err := arch.SarcoSession.ArchaeologistSarcophagusCount(arch.ArchAddress)
 	if err != nil {
 		log.Fatalf("Call to ArchaeologistSarcophagusCount in Contract failed. Please check CONTRACT_ADDRESS is correct in the config file: %v", err)
 	}
 
-	// iterate through all sarcos for the archaeologist
+	// iterate through the sarcos for the archaeologist that are not in state yet
 	// build service state
 	// schedule rewraps if sarcophagus is updated and resurrection time + window is in future
-	// clean the sarco if the resurrection time + window is in the future
-	// TODO: We only cleanup our own sarcos. We could be checking if *any* sarcos need cleanup to collect sarco tokens.
-
-	for i := big.NewInt(0); i.Cmp(sarcoCount) == -1; i = big.NewInt(0).Add(i, big.NewInt(1)) {
-		doubleHash, _ := arch.SarcoSession.ArchaeologistSarcophagusIdentifier(arch.ArchAddress, i)
-		sarco, _ := arch.SarcoSession.Sarcophagus(doubleHash)
-
-		/*
-			Sarco States:
-			0 - Does not Exist
-			1 - Exists
-			2 - Done
-		*/
-
-		switch state := sarco.State; state {
-		// Sarco Exists
-		case 1:
-			if utility.TimeWithWindowInFuture(sarco.ResurrectionTime, sarco.ResurrectionWindow) {
-				// Track if the archaeologist public key on the sarcophagus matches
-				// our current account index public key
-				// If it does, no other updated sarcophagus has used this public key yet and so the sarcophagus should be added to state
-				currentPublicKey := hdw.PublicKeyBytesFromIndex(arch.Wallet, accountIndex)
-				pubKeyMatches := bytes.Equal(sarco.ArchaeologistPublicKey, currentPublicKey)
-
-				// Update the public key mapping
-				var currentPublicKeyIndex [64]byte
-				copy(currentPublicKeyIndex[:], currentPublicKey)
-				doubleHashList := append(pubKeyMap[currentPublicKeyIndex], doubleHash)
-				pubKeyMap[currentPublicKeyIndex] = doubleHashList
-
-				if sarco.AssetId == "" {
-					// This is a created sarc that is not updated
-					// If our current pub key matches the one on the sarcophagus,
-					// this means no updated sarcophagus has used our current public key yet
-					// and we need to create a file handler for this sarcophagus
-					// as a file could potentially be sent for this sarcophagus
-					if pubKeyMatches {
-						fileHandlers[doubleHash] = sarco.StorageFee
-						// save created sarco to state
-						sarcophaguses[doubleHash] = &models.Sarco{sarco.ResurrectionTime, accountIndex, false, 0}
-					}
-				} else {
-					// We have a sarcophagus that is updated but not unwrapped
-					// Schedule an unwrap using the current account index private key
-					// and increment the account index as this key pair has been used
-					privateKey := hdw.PrivateKeyFromIndex(arch.Wallet, accountIndex)
-
-					// save updated sarco to state
-					sarcophaguses[doubleHash] = &models.Sarco{sarco.ResurrectionTime, accountIndex, true, 0}
-					scheduleUnwrap(&arch.SarcoSession, arch.ArweaveTransactor.Client.(*api.Client), sarco.ResurrectionTime, arch, doubleHash, privateKey, sarco.AssetId)
-					fileHandlers = map[[32]byte]*big.Int{}
-					accountIndex += 1
-
-					// Remove any other sarcos from state that used this public key
-					for i := range pubKeyMap[currentPublicKeyIndex] {
-						if !bytes.Equal(pubKeyMap[currentPublicKeyIndex][i][:], doubleHash[:]) {
-							delete(sarcophaguses, pubKeyMap[currentPublicKeyIndex][i])
-						}
+	// queue a cleanup if the resurrection time + window is in the past
+	// Sarcophagi of other archaeologists are cleaned up by the sweeper
+	for i := from; i.Cmp(sarcoCount) == -1; i = big.NewInt(0).Add(i, big.NewInt(1)) {
+		doubleHash, err := arch.SarcoSession.ArchaeologistSarcophagusIdentifier(arch.ArchAddress, i)
+		if err != nil {
+			log.Fatalf("Call to ArchaeologistSarcophagusIdentifier in Contract failed for index %v: %v", i, err)
+		}
+
+		sarco, err := arch.SarcoSession.Sarcophagus(doubleHash)
+		if err != nil {
+			log.Fatalf("Call to Sarcophagus in Contract failed for %v: %v", doubleHash, err)
+		}
+
+		builder.add(doubleHash, sarco)
+	}
+
+	arch.Registry.Replace(builder.sarcophaguses, builder.fileHandlers, builder.accountIndex)
+
+	// The state and the count of indexed sarcophagi are saved only after every sarcophagus was processed,
+	// so a failure part way through never leaves a saved account index that skips sarcophagi
+	if err := arch.SaveState(); err != nil {
+		log.Fatalf("Could not save state to %v: %v", arch.Store.Path(), err)
+	}
+
+	if err := arch.SetSarcophagusCount(sarcoCount); err != nil {
+		log.Fatalf("Could not save state to %v: %v", arch.Store.Path(), err)
+	}
+
+	// Without a saved checkpoint, contract events are processed from the block the state was read at
+	if _, ok, _ := arch.LastProcessedBlock(); !ok && head > 0 {
+		if err := arch.SetLastProcessedBlock(head - 1); err != nil {
+			log.Fatalf("Could not save state to %v: %v", arch.Store.Path(), err)
+		}
+	}
+
+	log.Printf("Sarcophaguses not yet complete: %v", builder.sarcophaguses)
+	log.Printf("Sarcophaguses waiting for a file: %v", builder.fileHandlers)
+	log.Printf("Current Account Index: %v", builder.accountIndex)
+}
+
+// stateBuilder tracks the sarcophagi, file handlers and hd wallet account index
+// while the archaeologist's sarcophagi on the contract are processed in the order they were created
+type stateBuilder struct {
+	arch          *models.Archaeologist
+	sarcophaguses map[[32]byte]*models.Sarco
+	fileHandlers  map[[32]byte]*big.Int
+	accountIndex  int
+
+	// Sarcos (double hashes) that are not updated yet, indexed by the account index of the public key they were created with
+	keyIndexMap map[int][][32]byte
+}
+
+// newStateBuilder .
+func newStateBuilder(arch *models.Archaeologist, sarcophaguses map[[32]byte]*models.Sarco, fileHandlers map[[32]byte]*big.Int, accountIndex int) *stateBuilder {
+	builder := &stateBuilder{
+		arch:          arch,
+		sarcophaguses: sarcophaguses,
+		fileHandlers:  fileHandlers,
+		accountIndex:  accountIndex,
+		keyIndexMap:   map[int][][32]byte{},
+	}
+
+	for doubleHash, sarco := range sarcophaguses {
+		if !sarco.Updated {
+			builder.keyIndexMap[sarco.AccountIndex] = append(builder.keyIndexMap[sarco.AccountIndex], doubleHash)
+		}
+	}
+
+	return builder
+}
+
+// add processes a sarcophagus that is not in state yet
+// A sarcophagus already in state was handled by its events before the service stopped, and reconciled, so it is skipped
+func (b *stateBuilder) add(doubleHash [32]byte, sarco contracts.TypesSarcophagus) {
+	if _, ok := b.sarcophaguses[doubleHash]; ok {
+		return
+	}
+
+	/*
+		Sarco States:
+		0 - Does not Exist
+		1 - Exists
+		2 - Done
+	*/
+
+	switch state := sarco.State; state {
+	// Sarco Exists
+	case 1:
+		if utility.TimeWithWindowInFuture(sarco.ResurrectionTime, sarco.ResurrectionWindow) {
+			// Track if the archaeologist public key on the sarcophagus matches
+			// our current account index public key
+			// If it does, no other updated sarcophagus has used this public key yet and so the sarcophagus should be added to state
+			currentPublicKey := hdw.PublicKeyBytesFromIndex(b.arch.Wallet, b.accountIndex)
+			pubKeyMatches := bytes.Equal(sarco.ArchaeologistPublicKey, currentPublicKey)
+
+			if sarco.AssetId == "" {
+				// This is a created sarc that is not updated
+				// If our current pub key matches the one on the sarcophagus,
+				// this means no updated sarcophagus has used our current public key yet
+				// and we need to create a file handler for this sarcophagus
+				// as a file could potentially be sent for this sarcophagus
+				if pubKeyMatches {
+					b.fileHandlers[doubleHash] = sarco.StorageFee
+					// save created sarco to state
+					b.sarcophaguses[doubleHash] = &models.Sarco{
+						ResurrectionTime:   sarco.ResurrectionTime,
+						ResurrectionWindow: sarco.ResurrectionWindow,
+						AccountIndex:       b.accountIndex,
+						Updated:            false,
+						UnwrapAttempts:     0,
 					}
+					b.keyIndexMap[b.accountIndex] = append(b.keyIndexMap[b.accountIndex], doubleHash)
 				}
 			} else {
-				// Sarc's unwrap time + resurrection window is in the past
-				log.Printf("Sarcophagus did not get unwrapped in time: %v", doubleHash)
-				if sarco.AssetId != "" {
-					// Sarco has been updated, increment account index as this sarco uses one of our key pairs.
-					// Clear file handlers b/c we only want file handlers for our current account index
-					fileHandlers = map[[32]byte]*big.Int{}
-					accountIndex += 1
-				}
-
-				// Lets get some money by cleaning it up
-				tx, err := arch.SarcoSession.CleanUpSarcophagus(doubleHash, arch.PaymentAddress)
-				if err != nil {
-					log.Printf("Cleanup Sarcophagus error: %v", err)
+				// We have a sarcophagus that is updated but not unwrapped
+				// save updated sarco to state and schedule an unwrap using the current account index private key
+				b.sarcophaguses[doubleHash] = &models.Sarco{
+					ResurrectionTime:   sarco.ResurrectionTime,
+					ResurrectionWindow: sarco.ResurrectionWindow,
+					AccountIndex:       b.accountIndex,
+					Updated:            true,
+					UnwrapAttempts:     0,
 				}
-				log.Printf("Cleanup Sarcophagus Tx Submitted. Transaction ID: %s", tx.Hash().Hex())
-				log.Printf("Gas Used: %v", tx.Gas())
+				b.scheduleUnwrap(doubleHash, sarco)
+				b.keyUsed(b.accountIndex, doubleHash)
 			}
-		// Sarco is Done
-		case 2:
+		} else {
+			// Sarc's unwrap time + resurrection window is in the past
 			if sarco.AssetId != "" {
 				// Sarco has been updated, increment account index as this sarco uses one of our key pairs.
-				// Clear file handlers b/c we only want file handlers for our current account index
-				fileHandlers = map[[32]byte]*big.Int{}
-				accountIndex += 1
+				b.keyUsed(b.accountIndex, doubleHash)
 			}
+
+			// Lets get some money by cleaning it up
+			queueCleanUp(b.arch, doubleHash)
+		}
+	// Sarco is Done
+	case 2:
+		if sarco.AssetId != "" {
+			// Sarco has been updated, increment account index as this sarco uses one of our key pairs.
+			b.keyUsed(b.accountIndex, doubleHash)
+		}
+	}
+}
+
+// reconcile checks every sarcophagus loaded from state against the contract
+// and applies anything that happened to it while the service was not running
+func (b *stateBuilder) reconcile() {
+	for doubleHash, stateSarco := range b.sarcophaguses {
+		sarco, err := b.arch.SarcoSession.Sarcophagus(doubleHash)
+		if err != nil {
+			log.Fatalf("Call to Sarcophagus in Contract failed for %v: %v", doubleHash, err)
+		}
+
+		// An update on the contract means the key pair at the sarcophagus account index has been used
+		if sarco.AssetId != "" && !stateSarco.Updated {
+			stateSarco.Updated = true
+			b.keyUsed(stateSarco.AccountIndex, doubleHash)
+		}
+
+		if sarco.State != 1 {
+			// Sarco has been unwrapped, cleaned, buried, cancelled or accused
+			b.remove(doubleHash)
+			continue
+		}
+
+		if !utility.TimeWithWindowInFuture(sarco.ResurrectionTime, sarco.ResurrectionWindow) {
+			b.remove(doubleHash)
+			queueCleanUp(b.arch, doubleHash)
+			continue
 		}
+
+		// Pick up any rewrap that happened while the service was not running
+		// (this also fills in the resurrection window for state saved before the window was tracked)
+		stateSarco.ResurrectionTime = sarco.ResurrectionTime
+		stateSarco.ResurrectionWindow = sarco.ResurrectionWindow
+
+		if stateSarco.Updated {
+			b.scheduleUnwrap(doubleHash, sarco)
+		}
+	}
+}
+
+// keyUsed records that the key pair at keyIndex was used by an updated sarcophagus.
+// Sarcos that were created with the same public key can no longer be updated, so they are removed,
+// and the account index moves past keyIndex if it has not already
+func (b *stateBuilder) keyUsed(keyIndex int, doubleHash [32]byte) {
+	for _, otherDoubleHash := range b.keyIndexMap[keyIndex] {
+		if otherDoubleHash != doubleHash {
+			b.remove(otherDoubleHash)
+		}
+	}
+	delete(b.keyIndexMap, keyIndex)
+
+	if keyIndex >= b.accountIndex {
+		// Clear file handlers b/c we only want file handlers for our current account index
+		b.accountIndex = keyIndex + 1
+		b.fileHandlers = map[[32]byte]*big.Int{}
+	}
+}
+
+// remove deletes a sarcophagus and its file handler from the state being built
+func (b *stateBuilder) remove(doubleHash [32]byte) {
+	delete(b.sarcophaguses, doubleHash)
+	delete(b.fileHandlers, doubleHash)
+	b.arch.UnwrapScheduler.Cancel(doubleHash)
+	b.arch.RemovePayload(doubleHash)
+}
+
+// scheduleUnwrap schedules an unwrap for an updated sarcophagus using the private key at its account index
+func (b *stateBuilder) scheduleUnwrap(doubleHash [32]byte, sarco contracts.TypesSarcophagus) {
+	scheduleUnwrap(b.arch, doubleHash, sarco.AssetId, *b.sarcophaguses[doubleHash])
+}
+
+// stateFilePath defaults the state file location if none is set in the config file
+func stateFilePath(stateFile string) string {
+	if stateFile == "" {
+		return DEFAULT_STATE_FILE
+	}
+
+	return stateFile
+}
+
+// parseEventConfirmations defaults to applying events as soon as they are received if EVENT_CONFIRMATIONS is not set in the config file
+func parseEventConfirmations(confirmations string) (uint64, error) {
+	if confirmations == "" {
+		return 0, nil
 	}
 
-	log.Printf("Sarcophaguses not yet complete: %v", sarcophaguses)
-	log.Printf("Sarcophaguses waiting for a file: %v", fileHandlers)
-	log.Printf("Current Account Index: %v", accountIndex)
+	parsed, err := strconv.ParseUint(confirmations, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("EVENT_CONFIRMATIONS must be a whole number of blocks: %v", err)
+	}
 
-	return sarcophaguses, fileHandlers, accountIndex
+	return parsed, nil
+}
+
+// eventSource returns how contract events are received
+// If EVENT_SOURCE is not set in the config file, http nodes are polled and all other nodes are subscribed to
+func eventSource(source string, ethNode string) (string, error) {
+	switch strings.ToLower(source) {
+	case EVENT_SOURCE_SUBSCRIBE:
+		return EVENT_SOURCE_SUBSCRIBE, nil
+	case EVENT_SOURCE_POLL:
+		return EVENT_SOURCE_POLL, nil
+	case "":
+		node := strings.ToLower(ethNode)
+		if strings.HasPrefix(node, "http://") || strings.HasPrefix(node, "https://") {
+			return EVENT_SOURCE_POLL, nil
+		}
+		return EVENT_SOURCE_SUBSCRIBE, nil
+	}
+
+	return "", fmt.Errorf("EVENT_SOURCE must be %v or %v, got: %v", EVENT_SOURCE_SUBSCRIBE, EVENT_SOURCE_POLL, source)
+}
+
+// eventPollInterval defaults to DEFAULT_EVENT_POLL_INTERVAL if EVENT_POLL_INTERVAL (in seconds) is not set in the config file
+func eventPollInterval(seconds string) (time.Duration, error) {
+	if seconds == "" {
+		return DEFAULT_EVENT_POLL_INTERVAL, nil
+	}
+
+	parsed, err := strconv.ParseUint(seconds, 10, 32)
+	if err != nil || parsed == 0 {
+		return 0, fmt.Errorf("EVENT_POLL_INTERVAL must be a positive whole number of seconds, got: %v", seconds)
+	}
+
+	return time.Duration(parsed) * time.Second, nil
+}
+
+// parseTxConfirmations defaults to DEFAULT_TX_CONFIRMATIONS if TX_CONFIRMATIONS is not set in the config file
+func parseTxConfirmations(confirmations string) (uint64, error) {
+	if confirmations == "" {
+		return DEFAULT_TX_CONFIRMATIONS, nil
+	}
+
+	parsed, err := strconv.ParseUint(confirmations, 10, 64)
+	if err != nil || parsed == 0 {
+		return 0, fmt.Errorf("TX_CONFIRMATIONS must be a positive whole number of blocks, got: %v", confirmations)
+	}
+
+	return parsed, nil
+}
+
+// parseTxTimeout defaults to DEFAULT_TX_TIMEOUT if TX_TIMEOUT is not set in the config file
+func parseTxTimeout(seconds string) (time.Duration, error) {
+	if seconds == "" {
+		return DEFAULT_TX_TIMEOUT, nil
+	}
+
+	parsed, err := strconv.ParseUint(seconds, 10, 32)
+	if err != nil || parsed == 0 {
+		return 0, fmt.Errorf("TX_TIMEOUT must be a positive whole number of seconds, got: %v", seconds)
+	}
+
+	return time.Duration(parsed) * time.Second, nil
+}
+
+// parseMaxFileSize returns the largest file accepted from an embalmer in bytes
+// Defaults to DEFAULT_MAX_FILE_SIZE if MAX_FILE_SIZE (in MB) is not set in the config file
+func parseMaxFileSize(megabytes string) (int64, error) {
+	if megabytes == "" {
+		return DEFAULT_MAX_FILE_SIZE, nil
+	}
+
+	size, err := decimal.NewFromString(megabytes)
+	if err != nil || !size.IsPositive() {
+		return 0, fmt.Errorf("MAX_FILE_SIZE must be a positive number of MB, got: %v", megabytes)
+	}
+
+	return size.Mul(decimal.NewFromInt(models.MB)).IntPart(), nil
+}
+
+// parseLossProtection returns the source of the SARCO price in AR, and the loss tolerance in percent
+// Storage fees are not compared with the arweave cost (nil source) unless SARCO_AR_PRICE or SARCO_AR_PRICE_SOURCE is set.
+func parseLossProtection(config *models.Config) (price.Source, decimal.Decimal, error) {
+	tolerance := decimal.Zero
+	if config.LOSS_TOLERANCE != "" {
+		var err error
+		tolerance, err = decimal.NewFromString(config.LOSS_TOLERANCE)
+		if err != nil || tolerance.IsNegative() {
+			return nil, decimal.Zero, fmt.Errorf("LOSS_TOLERANCE must be a percent of the arweave cost, got: %v", config.LOSS_TOLERANCE)
+		}
+	}
+
+	switch {
+	case config.SARCO_AR_PRICE != "" && config.SARCO_AR_PRICE_SOURCE != "":
+		return nil, decimal.Zero, fmt.Errorf("SARCO_AR_PRICE and SARCO_AR_PRICE_SOURCE cannot both be set")
+	case config.SARCO_AR_PRICE != "":
+		static, err := price.NewStatic(config.SARCO_AR_PRICE)
+		if err != nil {
+			return nil, decimal.Zero, fmt.Errorf("SARCO_AR_PRICE must be the value of 1 SARCO in AR: %v", err)
+		}
+		return static, tolerance, nil
+	case config.SARCO_AR_PRICE_SOURCE != "":
+		local := price.NewLocal(config.SARCO_AR_PRICE_SOURCE)
+		if _, err := local.Price(); err != nil {
+			return nil, decimal.Zero, fmt.Errorf("SARCO_AR_PRICE_SOURCE must be a file or url with the value of 1 SARCO in AR: %v", err)
+		}
+		return local, tolerance, nil
+	}
+
+	return nil, tolerance, nil
+}
+
+// parseListenAddress defaults to localhost on FILE_PORT if LISTEN_ADDRESS is not set in the config file
+func parseListenAddress(address string, port string) (string, error) {
+	if address == "" {
+		address = net.JoinHostPort("localhost", port)
+	}
+
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return "", fmt.Errorf("LISTEN_ADDRESS must be a host and port (e.g. 0.0.0.0:443), got: %v", address)
+	}
+
+	return address, nil
+}
+
+// parseTLS returns the TLS config of the file server, or nil if it serves http
+// The certificate is loaded from TLS_CERT_FILE and TLS_KEY_FILE, or requested with ACME for the ENDPOINT domain
+func parseTLS(config *models.Config) (*tls.Config, error) {
+	useACME := false
+	if config.ACME != "" {
+		var err error
+		useACME, err = strconv.ParseBool(config.ACME)
+		if err != nil {
+			return nil, fmt.Errorf("ACME must be true or false, got: %v", config.ACME)
+		}
+	}
+
+	useFiles := config.TLS_CERT_FILE != "" || config.TLS_KEY_FILE != ""
+	switch {
+	case useFiles && useACME:
+		return nil, fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE cannot be set when ACME is true")
+	case useFiles:
+		if config.TLS_CERT_FILE == "" || config.TLS_KEY_FILE == "" {
+			return nil, fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must both be set")
+		}
+		return certs.FileConfig(config.TLS_CERT_FILE, config.TLS_KEY_FILE)
+	case !useACME:
+		return nil, nil
+	}
+
+	endpoint, err := url.Parse(config.ENDPOINT)
+	if err != nil || endpoint.Hostname() == "" {
+		return nil, fmt.Errorf("ENDPOINT must be a url with the domain to request a certificate for when ACME is true, got: %v", config.ENDPOINT)
+	}
+
+	directoryURL := config.ACME_DIRECTORY_URL
+	if directoryURL == "" {
+		directoryURL = DEFAULT_ACME_DIRECTORY_URL
+	}
+
+	cacheDir := config.ACME_CACHE_DIR
+	if cacheDir == "" {
+		cacheDir = DEFAULT_ACME_CACHE_DIR
+	}
+
+	return certs.ACMEConfig(endpoint.Hostname(), directoryURL, cacheDir, config.ACME_EMAIL, config.ACME_CA_FILE)
+}
+
+// parseRateLimiter builds the rate limiter of the file server
+// Defaults to DEFAULT_RATE_LIMIT requests per second with bursts of DEFAULT_RATE_LIMIT_BURST requests from each client
+func parseRateLimiter(config *models.Config) (*utility.IPRateLimiter, error) {
+	limit := config.RATE_LIMIT
+	if limit == "" {
+		limit = DEFAULT_RATE_LIMIT
+	}
+	perSecond, err := strconv.ParseFloat(limit, 64)
+	if err != nil || perSecond <= 0 {
+		return nil, fmt.Errorf("RATE_LIMIT must be a positive number of requests per second, got: %v", config.RATE_LIMIT)
+	}
+
+	burst := DEFAULT_RATE_LIMIT_BURST
+	if config.RATE_LIMIT_BURST != "" {
+		parsed, err := strconv.ParseUint(config.RATE_LIMIT_BURST, 10, 16)
+		if err != nil || parsed == 0 {
+			return nil, fmt.Errorf("RATE_LIMIT_BURST must be a positive whole number of requests, got: %v", config.RATE_LIMIT_BURST)
+		}
+		burst = int(parsed)
+	}
+
+	trustedProxies, err := utility.ParseTrustedProxies(config.TRUSTED_PROXIES)
+	if err != nil {
+		return nil, fmt.Errorf("TRUSTED_PROXIES must be a comma separated list of IPs or CIDRs: %v", err)
+	}
+
+	return utility.NewIPRateLimiter(rate.Limit(perSecond), burst, trustedProxies), nil
+}
+
+// parseAllowedOrigins defaults to DEFAULT_ALLOWED_ORIGINS if ALLOWED_ORIGINS is not set in the config file
+func parseAllowedOrigins(origins string) ([]string, error) {
+	if strings.TrimSpace(origins) == "" {
+		origins = DEFAULT_ALLOWED_ORIGINS
+	}
+
+	allowed, err := utility.ParseAllowedOrigins(origins)
+	if err != nil {
+		return nil, fmt.Errorf("ALLOWED_ORIGINS must be a comma separated list of origins (e.g. https://app.sarcophagus.io) or *: %v", err)
+	}
+
+	return allowed, nil
+}
+
+// parseSweeper returns the sweep interval, the value of 1 SARCO in ETH and the minimum profit of a cleanup in wei
+// The sweeper is disabled (interval of 0) unless SWEEP_INTERVAL is set.
+func parseSweeper(config *models.Config) (time.Duration, decimal.Decimal, *big.Int, error) {
+	if config.SWEEP_INTERVAL == "" {
+		return 0, decimal.Zero, nil, nil
+	}
+
+	seconds, err := strconv.ParseUint(config.SWEEP_INTERVAL, 10, 32)
+	if err != nil || seconds == 0 {
+		return 0, decimal.Zero, nil, fmt.Errorf("SWEEP_INTERVAL must be a positive whole number of seconds, got: %v", config.SWEEP_INTERVAL)
+	}
+
+	price, err := decimal.NewFromString(config.SARCO_ETH_PRICE)
+	if err != nil || !price.IsPositive() {
+		return 0, decimal.Zero, nil, fmt.Errorf("SARCO_ETH_PRICE must be set to the value of 1 SARCO in ETH when SWEEP_INTERVAL is set, got: %v", config.SARCO_ETH_PRICE)
+	}
+
+	minProfit := big.NewInt(0)
+	if config.SWEEP_MIN_PROFIT != "" {
+		profit, err := decimal.NewFromString(config.SWEEP_MIN_PROFIT)
+		if err != nil || profit.IsNegative() {
+			return 0, decimal.Zero, nil, fmt.Errorf("SWEEP_MIN_PROFIT must be an amount of ETH, got: %v", config.SWEEP_MIN_PROFIT)
+		}
+		minProfit = utility.ToWei(profit, 18)
+	}
+
+	return time.Duration(seconds) * time.Second, price, minProfit, nil
+}
+
+// parseGasStrategy builds the gas strategy from the gas config values
+// Gas prices are expressed in gwei.
+func parseGasStrategy(config *models.Config) (*gas.Strategy, error) {
+	strategy := &gas.Strategy{}
+
+	var err error
+	if config.GAS_PRICE_OVERRIDE != "" {
+		strategy.Override, err = parseGwei(config.GAS_PRICE_OVERRIDE, "GAS_PRICE_OVERRIDE")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if config.MAX_GAS_PRICE != "" {
+		strategy.MaxPrice, err = parseGwei(config.MAX_GAS_PRICE, "MAX_GAS_PRICE")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if strategy.Override != nil && strategy.MaxPrice != nil && strategy.Override.Cmp(strategy.MaxPrice) == 1 {
+		return nil, fmt.Errorf("GAS_PRICE_OVERRIDE cannot be greater than MAX_GAS_PRICE")
+	}
+
+	multiplier := config.GAS_PRICE_MULTIPLIER
+	if multiplier == "" {
+		multiplier = DEFAULT_GAS_PRICE_MULTIPLIER
+	}
+	strategy.Multiplier, err = decimal.NewFromString(multiplier)
+	if err != nil || !strategy.Multiplier.IsPositive() {
+		return nil, fmt.Errorf("GAS_PRICE_MULTIPLIER must be a positive number, got: %v", config.GAS_PRICE_MULTIPLIER)
+	}
+
+	if config.LEGACY_TRANSACTIONS != "" {
+		strategy.Legacy, err = strconv.ParseBool(config.LEGACY_TRANSACTIONS)
+		if err != nil {
+			return nil, fmt.Errorf("LEGACY_TRANSACTIONS must be true or false, got: %v", config.LEGACY_TRANSACTIONS)
+		}
+	}
+
+	return strategy, nil
+}
+
+// parseGwei converts a positive amount of gwei to wei
+func parseGwei(amount string, name string) (*big.Int, error) {
+	gwei, err := decimal.NewFromString(amount)
+	if err != nil || !gwei.IsPositive() {
+		return nil, fmt.Errorf("%v must be a positive amount of gwei, got: %v", name, amount)
+	}
+
+	return utility.ToWei(gwei, 9), nil
 }
 
 // calculateFreeBond returns a negative big.Int if free bond should be withdrawn
@@ -255,7 +855,7 @@ func calculateFreeBond(addFreeBond *big.Int, removeFreeBond *big.Int) (*big.Int,
 
 // setPaymentAddress defaults to the eth address derived from eth_private_key
 // if no payment_address is provided in the config file
-func setPaymentAddress(archAddress common.Address, paymentAddress string, client *ethclient.Client) (common.Address, error) {
+func setPaymentAddress(archAddress common.Address, paymentAddress string, client bind.ContractCaller) (common.Address, error) {
 	var addy common.Address
 
 	if paymentAddress != "" {
@@ -272,28 +872,23 @@ func setPaymentAddress(archAddress common.Address, paymentAddress string, client
 }
 
 // initSarcophagusSession .
-func initSarcophagusSession(contractAddress common.Address, client *ethclient.Client, privateKey *ecdsa.PrivateKey) (contracts.SarcophagusSession, error) {
+func initSarcophagusSession(contractAddress common.Address, client bind.ContractBackend, privateKey *ecdsa.PrivateKey, chainID *big.Int) (contracts.SarcophagusSession, error) {
 	sarcoContract, err := contracts.NewSarcophagus(contractAddress, client)
 	if err != nil {
 		return contracts.SarcophagusSession{}, fmt.Errorf("failed to instantiate Sarcophagus contract: %v", err)
 	}
 
-	session := NewSarcophagusSession(context.Background(), sarcoContract, privateKey)
-
-	return session, nil
+	return NewSarcophagusSession(context.Background(), sarcoContract, privateKey, chainID)
 }
 
 // initTokenSession .
-func initTokenSession(tokenAddress string, client *ethclient.Client, privateKey *ecdsa.PrivateKey) (contracts.TokenSession, error) {
-	address := common.HexToAddress(tokenAddress)
-	tokenContract, err := contracts.NewToken(address, client)
+func initTokenSession(tokenAddress common.Address, client bind.ContractBackend, privateKey *ecdsa.PrivateKey, chainID *big.Int) (contracts.TokenSession, error) {
+	tokenContract, err := contracts.NewToken(tokenAddress, client)
 	if err != nil {
 		return contracts.TokenSession{}, fmt.Errorf("failed to instantiate Sarcophagus contract: %v", err)
 	}
 
-	session := NewTokenSession(context.Background(), tokenContract, privateKey)
-
-	return session, nil
+	return NewTokenSession(context.Background(), tokenContract, privateKey, chainID)
 }
 
 // stringToBigInt .
@@ -1,11 +1,15 @@
 package archaeologist
 
 import (
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/contracts"
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/hdw"
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/models"
 	"github.com/stretchr/testify/assert"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/ethclient"
 	"math/big"
 	"testing"
+	"time"
 )
 
 func TestCalculateFreeBond(t *testing.T) {
@@ -49,4 +53,102 @@ func TestSetPaymentAddress(t *testing.T) {
 	address, err := setPaymentAddress(archAddress, paymentAddress, &client)
 	assert.Nil(t, err)
 	assert.Equal(t, archAddress, address)
-}
\ No newline at end of file
+}
+func TestEventSource(t *testing.T) {
+	source, err := eventSource("", "ws://localhost:8545")
+	assert.Nil(t, err)
+	assert.Equal(t, EVENT_SOURCE_SUBSCRIBE, source)
+
+	source, err = eventSource("", "https://mainnet.example.com")
+	assert.Nil(t, err)
+	assert.Equal(t, EVENT_SOURCE_POLL, source)
+
+	source, err = eventSource("subscribe", "http://localhost:8545")
+	assert.Nil(t, err)
+	assert.Equal(t, EVENT_SOURCE_SUBSCRIBE, source)
+
+	_, err = eventSource("stream", "ws://localhost:8545")
+	assert.NotNil(t, err)
+}
+
+func TestParseMaxFileSize(t *testing.T) {
+	size, err := parseMaxFileSize("")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(DEFAULT_MAX_FILE_SIZE), size)
+
+	size, err = parseMaxFileSize("0.5")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(512*1024), size)
+
+	_, err = parseMaxFileSize("0")
+	assert.NotNil(t, err)
+}
+
+func TestParseListenAddress(t *testing.T) {
+	address, err := parseListenAddress("", "8080")
+	assert.Nil(t, err)
+	assert.Equal(t, "localhost:8080", address)
+
+	address, err = parseListenAddress("0.0.0.0:443", "8080")
+	assert.Nil(t, err)
+	assert.Equal(t, "0.0.0.0:443", address)
+
+	_, err = parseListenAddress("0.0.0.0", "8080")
+	assert.NotNil(t, err)
+}
+
+func TestParseTLS(t *testing.T) {
+	config, err := parseTLS(&models.Config{})
+	assert.Nil(t, err)
+	assert.Nil(t, config)
+
+	_, err = parseTLS(&models.Config{TLS_CERT_FILE: "cert.pem"})
+	assert.NotNil(t, err)
+
+	_, err = parseTLS(&models.Config{TLS_CERT_FILE: "cert.pem", TLS_KEY_FILE: "key.pem", ACME: "true"})
+	assert.NotNil(t, err)
+
+	_, err = parseTLS(&models.Config{ACME: "true", ENDPOINT: "not a url"})
+	assert.NotNil(t, err)
+}
+
+func TestStateBuilderRestart(t *testing.T) {
+	arch := testProcessor(t, 0).arch
+	resurrectionTime := big.NewInt(time.Now().Add(time.Hour).Unix())
+	window := big.NewInt(3600)
+
+	// a sarcophagus created with key 0 and updated while the service was running, then reconciled on restart
+	updated := [32]byte{1}
+	sarcophaguses := map[[32]byte]*models.Sarco{
+		updated: {ResurrectionTime: resurrectionTime, ResurrectionWindow: window, AccountIndex: 0, Updated: true},
+	}
+	builder := newStateBuilder(arch, sarcophaguses, map[[32]byte]*big.Int{}, 1)
+
+	// the sarcophagus is walked on the contract again, as it was created after the count was saved
+	builder.add(updated, contracts.TypesSarcophagus{
+		State:                  1,
+		ArchaeologistPublicKey: hdw.PublicKeyBytesFromIndex(arch.Wallet, 0),
+		ResurrectionTime:       resurrectionTime,
+		ResurrectionWindow:     window,
+		AssetId:                "arweave tx",
+		StorageFee:             big.NewInt(10),
+	})
+
+	assert.Equal(t, 1, builder.accountIndex)
+	assert.Equal(t, 0, builder.sarcophaguses[updated].AccountIndex)
+	assert.Empty(t, arch.UnwrapScheduler.Jobs())
+
+	// a sarcophagus created with the current key is still added
+	created := [32]byte{2}
+	builder.add(created, contracts.TypesSarcophagus{
+		State:                  1,
+		ArchaeologistPublicKey: hdw.PublicKeyBytesFromIndex(arch.Wallet, 1),
+		ResurrectionTime:       resurrectionTime,
+		ResurrectionWindow:     window,
+		StorageFee:             big.NewInt(10),
+	})
+
+	assert.Equal(t, 1, builder.accountIndex)
+	assert.Equal(t, 1, builder.sarcophaguses[created].AccountIndex)
+	assert.Equal(t, big.NewInt(10), builder.fileHandlers[created])
+}
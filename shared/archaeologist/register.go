@@ -29,7 +29,7 @@ func RegisterOrUpdateArchaeologist(arch *models.Archaeologist) {
 		}
 
 		if arch.FreeBond.Cmp(big.NewInt(0)) == 1 ||
-			!bytes.Equal(contractArch.CurrentPublicKey, arch.CurrentPublicKeyBytes) ||
+			!bytes.Equal(contractArch.CurrentPublicKey, arch.Registry.CurrentPublicKeyBytes()) ||
 			contractArch.Endpoint != arch.Endpoint ||
 			contractArch.PaymentAddress != arch.PaymentAddress ||
 			contractArch.FeePerByte.Cmp(arch.FeePerByte) != 0 ||
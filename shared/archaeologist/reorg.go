@@ -0,0 +1,180 @@
+// Reorg handling is responsible for undoing applied contract events whose logs are removed by a chain reorg
+// Before an event is applied, the state of the sarcophagus it affects is saved,
+// so the state (including the account index and current public key) can be restored if the log is removed
+// For events that use up a key pair, all the open file handlers are saved as well, so the file handlers
+// of other sarcophagi created with the key can be reopened when the key is given back.
+
+package archaeologist
+
+import (
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/contracts"
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/models"
+	"github.com/ethereum/go-ethereum/core/types"
+	"log"
+	"math/big"
+)
+
+// sarcoSnapshot is the state of a sarcophagus before an event was applied to it
+type sarcoSnapshot struct {
+	sarco        *models.Sarco // copy of the sarcophagus, nil if it was not in state
+	storageFee   *big.Int      // storage fee of the open file handler, nil if there was none
+	accountIndex int
+}
+
+// appliedEvent is an event that has been applied to state and can still be undone
+type appliedEvent struct {
+	key          logKey
+	block        uint64
+	identifier   [32]byte
+	before       sarcoSnapshot
+	accountIndex int // account index after the event was applied
+
+	// file handlers open before the event was applied, saved only if the event used up a key pair
+	fileHandlers map[[32]byte]*big.Int
+}
+
+// takeSnapshot copies the state of the sarcophagus
+func takeSnapshot(arch *models.Archaeologist, identifier [32]byte) sarcoSnapshot {
+	sarco, storageFee, accountIndex := arch.Registry.Snapshot(identifier)
+	return sarcoSnapshot{sarco, storageFee, accountIndex}
+}
+
+// eventIdentifier returns the double hash of the sarcophagus the event is for
+func eventIdentifier(value interface{}) [32]byte {
+	switch event := value.(type) {
+	case *contracts.EventsCreateSarcophagus:
+		return event.Identifier
+	case *contracts.EventsUpdateSarcophagus:
+		return event.Identifier
+	case *contracts.EventsRewrapSarcophagus:
+		return event.Identifier
+	case *contracts.EventsCleanUpSarcophagus:
+		return event.Identifier
+	case *contracts.EventsBurySarcophagus:
+		return event.Identifier
+	case *contracts.EventsCancelSarcophagus:
+		return event.Identifier
+	case *contracts.EventsAccuseArchaeologist:
+		return event.Identifier
+	}
+
+	return [32]byte{}
+}
+
+// apply hands the event to its handler and records how to undo it
+// Events for sarcophagi that are not ours leave state unchanged, so they are not recorded
+func (p *eventProcessor) apply(event contractEvent) {
+	identifier := eventIdentifier(event.value)
+	before := takeSnapshot(p.arch, identifier)
+	fileHandlers := p.arch.Registry.FileHandlers()
+
+	p.dispatch(event.value)
+
+	if before.sarco == nil && !p.arch.IsArchSarcophagus(identifier) {
+		return
+	}
+
+	applied := appliedEvent{
+		key:          logKey{event.raw.TxHash, event.raw.Index},
+		block:        event.raw.BlockNumber,
+		identifier:   identifier,
+		before:       before,
+		accountIndex: p.arch.Registry.AccountIndex(),
+	}
+	if applied.accountIndex != before.accountIndex {
+		applied.fileHandlers = fileHandlers
+	}
+
+	p.applied = append(p.applied, applied)
+}
+
+// rollback handles a log that was removed by a chain reorg
+// If the event is still waiting for confirmations it is dropped.
+// If it has been applied, it is undone along with every later event applied to the same sarcophagus,
+// as those were emitted in the removed blocks as well.
+func (p *eventProcessor) rollback(raw types.Log) {
+	key := logKey{raw.TxHash, raw.Index}
+	delete(p.seen, key)
+
+	for i, event := range p.pending {
+		if event.raw.TxHash == raw.TxHash && event.raw.Index == raw.Index {
+			p.pending = append(p.pending[:i], p.pending[i+1:]...)
+			log.Printf("Dropped unconfirmed event removed by a chain reorg. Transaction ID: %s", raw.TxHash.Hex())
+			return
+		}
+	}
+
+	position := -1
+	for i, applied := range p.applied {
+		if applied.key == key {
+			position = i
+			break
+		}
+	}
+
+	if position < 0 {
+		return
+	}
+
+	identifier := p.applied[position].identifier
+	remaining := p.applied[:position]
+	var undone []appliedEvent
+
+	for _, applied := range p.applied[position:] {
+		if applied.identifier == identifier {
+			undone = append(undone, applied)
+		} else {
+			remaining = append(remaining, applied)
+		}
+	}
+	p.applied = remaining
+
+	// undo the newest event first so each snapshot is restored over the state it was taken from
+	for i := len(undone) - 1; i >= 0; i-- {
+		p.undo(undone[i])
+		delete(p.seen, undone[i].key)
+	}
+
+	log.Printf("Rolled back %v event(s) removed by a chain reorg for the double hash: %v", len(undone), identifier)
+	p.arch.PersistState()
+}
+
+// undo restores the sarcophagus to its state before the event was applied
+func (p *eventProcessor) undo(applied appliedEvent) {
+	arch := p.arch
+	before := applied.before
+
+	arch.Registry.Restore(applied.identifier, before.sarco, before.storageFee)
+
+	// The key pair used by the event can be given back, unless a later sarcophagus has used a key since
+	if before.accountIndex != applied.accountIndex {
+		arch.Registry.RollbackAccountIndex(applied.accountIndex, before.accountIndex)
+	}
+
+	// The file handlers closed since the key was used are reopened, if the key is current again
+	arch.Registry.ReopenFileHandlers(applied.fileHandlers)
+
+	// The unwrap is scheduled for the restored state, or cancelled if the sarcophagus is no longer updated
+	if before.sarco == nil || !before.sarco.Updated {
+		arch.UnwrapScheduler.Cancel(applied.identifier)
+		return
+	}
+
+	assetId, err := p.assetId(applied.identifier)
+	if err != nil {
+		log.Printf("Could not reschedule unwrap after rolling back the sarcophagus %v: %v", applied.identifier, err)
+		return
+	}
+
+	scheduleUnwrap(arch, applied.identifier, assetId, *before.sarco)
+}
+
+// assetId returns the arweave asset id of the sarcophagus from the contract
+func (p *eventProcessor) assetId(identifier [32]byte) (string, error) {
+	sarco, err := p.arch.SarcoSession.Sarcophagus(identifier)
+	if err != nil {
+		return "", err
+	}
+
+	return sarco.AssetId, nil
+}
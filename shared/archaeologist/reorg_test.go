@@ -0,0 +1,145 @@
+package archaeologist
+
+import (
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/contracts"
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/hdw"
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/models"
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/scheduler"
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/store"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	hdwallet "github.com/miguelmota/go-ethereum-hdwallet"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testMnemonic = "index cupboard city neither axis spot thumb pet rabbit stuff culture project top fault wisdom"
+
+func testProcessor(t *testing.T, confirmations uint64) *eventProcessor {
+	wallet, err := hdwallet.NewFromMnemonic(testMnemonic)
+	assert.Nil(t, err)
+
+	stateStore, err := store.Open(filepath.Join(t.TempDir(), "state.json"))
+	assert.Nil(t, err)
+
+	unwrapScheduler, err := scheduler.New(stateStore)
+	assert.Nil(t, err)
+
+	arch := &models.Archaeologist{
+		Wallet:          wallet,
+		Store:           stateStore,
+		Registry:        models.NewSarcoRegistry(wallet),
+		UnwrapScheduler: unwrapScheduler,
+	}
+
+	return &eventProcessor{
+		arch:          arch,
+		confirmations: confirmations,
+		seen:          map[logKey]uint64{},
+	}
+}
+
+func TestEventsWaitForConfirmations(t *testing.T) {
+	p := testProcessor(t, 2)
+	raw := types.Log{BlockNumber: 10, TxHash: common.HexToHash("0x01"), Index: 0}
+
+	p.process(raw, &contracts.EventsRewrapSarcophagus{})
+	assert.Len(t, p.pending, 1)
+
+	p.setHead(11)
+	assert.Len(t, p.pending, 1)
+
+	// the checkpoint stops before the unconfirmed event
+	p.advanceCheckpoint(11)
+	assert.Equal(t, uint64(9), p.checkpoint)
+
+	p.setHead(12)
+	assert.Len(t, p.pending, 0)
+}
+
+func TestRemovedPendingEventIsDropped(t *testing.T) {
+	p := testProcessor(t, 2)
+	raw := types.Log{BlockNumber: 10, TxHash: common.HexToHash("0x01"), Index: 0}
+
+	p.process(raw, &contracts.EventsRewrapSarcophagus{})
+	raw.Removed = true
+	p.process(raw, &contracts.EventsRewrapSarcophagus{})
+
+	assert.Len(t, p.pending, 0)
+	assert.Len(t, p.seen, 0)
+}
+
+func TestRemovedUpdateIsRolledBack(t *testing.T) {
+	p := testProcessor(t, 0)
+	arch := p.arch
+	identifier := [32]byte{1}
+	storageFee := big.NewInt(100)
+	resurrectionTime := big.NewInt(1)
+
+	// state after the update was applied
+	arch.Registry.Replace(map[[32]byte]*models.Sarco{
+		identifier: {ResurrectionTime: resurrectionTime, AccountIndex: 0, Updated: true},
+	}, map[[32]byte]*big.Int{}, 1)
+
+	raw := types.Log{BlockNumber: 10, TxHash: common.HexToHash("0x01"), Index: 0}
+	p.seen[logKey{raw.TxHash, raw.Index}] = raw.BlockNumber
+	p.applied = []appliedEvent{{
+		key:        logKey{raw.TxHash, raw.Index},
+		block:      raw.BlockNumber,
+		identifier: identifier,
+		before: sarcoSnapshot{
+			sarco:        &models.Sarco{ResurrectionTime: resurrectionTime, AccountIndex: 0, Updated: false},
+			storageFee:   storageFee,
+			accountIndex: 0,
+		},
+		accountIndex: 1,
+	}}
+
+	raw.Removed = true
+	p.process(raw, &contracts.EventsUpdateSarcophagus{Identifier: identifier})
+
+	sarco, _ := arch.Registry.Sarco(identifier)
+	assert.False(t, sarco.Updated)
+	fee, _ := arch.Registry.StorageFee(identifier)
+	assert.Equal(t, storageFee, fee)
+	assert.Equal(t, 0, arch.Registry.AccountIndex())
+	assert.Equal(t, hdw.PublicKeyBytesFromIndex(arch.Wallet, 0), arch.Registry.CurrentPublicKeyBytes())
+	assert.Len(t, p.applied, 0)
+	assert.Len(t, p.seen, 0)
+}
+
+func TestRolledBackUpdateReopensFileHandlers(t *testing.T) {
+	p := testProcessor(t, 0)
+	arch := p.arch
+	updated, other := [32]byte{1}, [32]byte{2}
+	storageFee := big.NewInt(100)
+	resurrectionTime := big.NewInt(time.Now().Add(time.Hour).Unix())
+
+	// two sarcophagi created with the current key, waiting for their files
+	arch.Registry.Replace(map[[32]byte]*models.Sarco{
+		updated: {ResurrectionTime: resurrectionTime, ResurrectionWindow: big.NewInt(3600), AccountIndex: 0},
+		other:   {ResurrectionTime: resurrectionTime, ResurrectionWindow: big.NewInt(3600), AccountIndex: 0},
+	}, map[[32]byte]*big.Int{updated: storageFee, other: storageFee}, 0)
+
+	raw := types.Log{BlockNumber: 10, TxHash: common.HexToHash("0x01"), Index: 0}
+	p.process(raw, &contracts.EventsUpdateSarcophagus{Identifier: updated, AssetId: "arweave tx"})
+	assert.Equal(t, 1, arch.Registry.AccountIndex())
+
+	// the last file handler is closed afterwards, e.g. by a failed upload
+	arch.Registry.ClearLastFileHandler()
+	assert.Equal(t, 0, arch.Registry.FileHandlerCount())
+
+	raw.Removed = true
+	p.process(raw, &contracts.EventsUpdateSarcophagus{Identifier: updated, AssetId: "arweave tx"})
+
+	assert.Equal(t, 0, arch.Registry.AccountIndex())
+	for _, identifier := range [][32]byte{updated, other} {
+		fee, ok := arch.Registry.StorageFee(identifier)
+		assert.True(t, ok)
+		assert.Equal(t, storageFee, fee)
+	}
+	assert.Empty(t, arch.UnwrapScheduler.Jobs())
+}
@@ -1,9 +1,7 @@
 package archaeologist
 
 import (
-	"github.com/Dev43/arweave-go/api"
 	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/contracts"
-	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/hdw"
 	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/models"
 	"log"
 )
@@ -12,18 +10,19 @@ import (
 func handleRewrapSarcophagus(event *contracts.EventsRewrapSarcophagus, arch *models.Archaeologist) {
 	log.Println("Rewrap Sarcophagus Event Sent:", event.Identifier)
 
-	if sarcophagus, ok := arch.Sarcophaguses[event.Identifier]; ok {
-		if sarcophagus.ResurrectionTime.Cmp(event.ResurrectionTime) != 0 {
-			// grab the private key for this account index
-			privateKey := hdw.PrivateKeyFromIndex(arch.Wallet, sarcophagus.AccountIndex)
+	if !arch.IsArchSarcophagus(event.Identifier) {
+		log.Printf("We dont have a sarcophagus to update for the rewrapping: %v", event.Identifier)
+		return
+	}
 
-			// Update resurrection time for Sarcophagus in state
-			sarcophagus.ResurrectionTime = event.ResurrectionTime
-			scheduleUnwrap(&arch.SarcoSession, arch.ArweaveTransactor.Client.(*api.Client), event.ResurrectionTime, arch, event.Identifier, privateKey, event.AssetId)
-		} else {
-			log.Printf("Unwrapping already scheduled for: %v, skipping rewrap",  event.Identifier)
-		}
-	} else {
-		log.Printf("We dont have a sarcophagus to update for the rewrapping: %v",  event.Identifier)
+	// Update resurrection time for Sarcophagus in state
+	sarcophagus, ok := arch.Registry.Rewrap(event.Identifier, event.ResurrectionTime, event.ResurrectionWindow)
+	if !ok {
+		log.Printf("Unwrapping already scheduled for: %v, skipping rewrap", event.Identifier)
+		return
 	}
-}
\ No newline at end of file
+	arch.PersistState()
+
+	// replaces the unwrap scheduled for the previous resurrection time
+	scheduleUnwrap(arch, event.Identifier, event.AssetId, sarcophagus)
+}
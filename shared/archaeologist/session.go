@@ -6,25 +6,32 @@ package archaeologist
 import (
 	"context"
 	"crypto/ecdsa"
+	"fmt"
 	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/contracts"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"math/big"
 )
 
-// initAuth .
-func initAuth (privateKey *ecdsa.PrivateKey) *bind.TransactOpts {
-	auth := bind.NewKeyedTransactor(privateKey)
-	auth.Nonce = nil // uses nonce of pending state
+// initAuth returns transact opts that sign transactions for the chain ID (EIP-155)
+func initAuth (privateKey *ecdsa.PrivateKey, chainID *big.Int) (*bind.TransactOpts, error) {
+	auth, err := bind.NewKeyedTransactorWithChainID(privateKey, chainID)
+	if err != nil {
+		return nil, fmt.Errorf("could not create transaction signer: %v", err)
+	}
+	auth.Nonce = nil // set by the transaction manager for each transaction
 	auth.Value = big.NewInt(0)
 	auth.GasLimit = 0 // 0 estimates gas limit
-	auth.GasPrice = nil // nil suggests gas price
+	auth.GasPrice = nil // set by the gas strategy for each transaction
 
-	return auth
+	return auth, nil
 }
 
 // NewSarcophagusSession .
-func NewSarcophagusSession(ctx context.Context, sarcophagusContract *contracts.Sarcophagus, privateKey *ecdsa.PrivateKey) contracts.SarcophagusSession {
-	auth := initAuth(privateKey)
+func NewSarcophagusSession(ctx context.Context, sarcophagusContract *contracts.Sarcophagus, privateKey *ecdsa.PrivateKey, chainID *big.Int) (contracts.SarcophagusSession, error) {
+	auth, err := initAuth(privateKey, chainID)
+	if err != nil {
+		return contracts.SarcophagusSession{}, err
+	}
 
 	return contracts.SarcophagusSession{
 		Contract: sarcophagusContract,
@@ -33,12 +40,15 @@ func NewSarcophagusSession(ctx context.Context, sarcophagusContract *contracts.S
 			From:    auth.From,
 			Context: ctx,
 		},
-	}
+	}, nil
 }
 
 // NewTokenSession .
-func NewTokenSession(ctx context.Context, tokenContract *contracts.Token, privateKey *ecdsa.PrivateKey)  contracts.TokenSession {
-	auth := initAuth(privateKey)
+func NewTokenSession(ctx context.Context, tokenContract *contracts.Token, privateKey *ecdsa.PrivateKey, chainID *big.Int)  (contracts.TokenSession, error) {
+	auth, err := initAuth(privateKey, chainID)
+	if err != nil {
+		return contracts.TokenSession{}, err
+	}
 
 	return contracts.TokenSession{
 		Contract: tokenContract,
@@ -47,5 +57,5 @@ func NewTokenSession(ctx context.Context, tokenContract *contracts.Token, privat
 			From:    auth.From,
 			Context: ctx,
 		},
-	}
+	}, nil
 }
\ No newline at end of file
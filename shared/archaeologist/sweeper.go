@@ -0,0 +1,309 @@
+// Sweeper cleans up the sarcophagi of any archaeologist that were not unwrapped before their resurrection window closed.
+// The contract pays half of the archaeologist's cursed bond to whoever cleans up the sarcophagus.
+// Every sarcophagus on the contract is indexed once, in batches, and the index is saved to its own store file next
+// to the state file so only new sarcophagi are read after a restart. Indexed sarcophagi are checked again once their
+// window has closed, and cleaned up if the reward (valued with SARCO_ETH_PRICE) is worth more than the gas by at least
+// SWEEP_MIN_PROFIT. Our own sarcophagi are not indexed, they are cleaned up separately.
+
+package archaeologist
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/contracts"
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/models"
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/store"
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/utility"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/shopspring/decimal"
+	"log"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	SWEEP_INDEX_BATCH = 100 // number of sarcophagi read from the contract before they are written to the index
+
+	sweepIndexBucket = "sweep_index"
+	sweepBucket      = "sweep"
+	sweepCursorKey   = "next_sarcophagus_index" // index of the next sarcophagus on the contract to add to the index
+)
+
+// sweepEntry is an indexed sarcophagus of another archaeologist
+type sweepEntry struct {
+	Deadline time.Time // end of the resurrection window when the sarcophagus was last read
+}
+
+// sweepBackend is the part of the contract used by the sweeper
+type sweepBackend interface {
+	SarcophagusCount() (*big.Int, error)
+	SarcophagusIdentifier(index *big.Int) ([32]byte, error)
+	Sarcophagus(identifier [32]byte) (contracts.TypesSarcophagus, error)
+	// CleanUpCost returns the most the cleanup transaction is expected to cost in wei
+	CleanUpCost(identifier [32]byte) (*big.Int, error)
+	CleanUp(identifier [32]byte) error
+}
+
+type sweeper struct {
+	backend   sweepBackend
+	store     *store.Store
+	self      common.Address
+	price     decimal.Decimal // value of 1 SARCO in ETH
+	minProfit *big.Int
+	batchSize int64 // number of sarcophagi written to the index in one update
+}
+
+// StartSweeper sweeps the contract every SWEEP_INTERVAL, if the sweeper is enabled
+func StartSweeper(arch *models.Archaeologist) {
+	if arch.SweepInterval == 0 {
+		return
+	}
+
+	indexStore, err := store.Open(sweepIndexPath(arch.Store.Path()))
+	if err != nil {
+		log.Fatalf("Could not load the sweep index. Please fix or remove the file and restart: %v", err)
+	}
+
+	s := &sweeper{
+		backend:   &archSweepBackend{archCleanUpBackend{arch, "Sweep Sarcophagus"}},
+		store:     indexStore,
+		self:      arch.ArchAddress,
+		price:     arch.SarcoEthPrice,
+		minProfit: arch.SweepMinProfit,
+		batchSize: SWEEP_INDEX_BATCH,
+	}
+
+	log.Printf("Sweeping expired sarcophagi every %v", arch.SweepInterval)
+	go func() {
+		for {
+			s.sweep(time.Now())
+			time.Sleep(arch.SweepInterval)
+		}
+	}()
+}
+
+// sweep indexes new sarcophagi and cleans up the indexed sarcophagi whose window has closed
+func (s *sweeper) sweep(now time.Time) {
+	if err := s.index(); err != nil {
+		log.Printf("Error indexing sarcophagi for the sweeper: %v", err)
+	}
+
+	for _, identifier := range s.expired(now) {
+		if err := s.check(identifier, now); err != nil {
+			log.Printf("Error sweeping the sarcophagus %v: %v", hexutil.Encode(identifier[:]), err)
+		}
+	}
+}
+
+// index adds the sarcophagi created since the last sweep to the index
+// The sarcophagi are written in batches with the cursor, and the sarcophagi read before an error are kept.
+func (s *sweeper) index() error {
+	next := big.NewInt(0)
+	var cursor string
+	if ok, err := s.store.Get(sweepBucket, sweepCursorKey, &cursor); err != nil {
+		return err
+	} else if ok {
+		if _, valid := next.SetString(cursor, 10); !valid {
+			return fmt.Errorf("invalid sweep cursor in %v: %v", s.store.Path(), cursor)
+		}
+	}
+
+	count, err := s.backend.SarcophagusCount()
+	if err != nil {
+		return err
+	}
+
+	for next.Cmp(count) < 0 {
+		end := new(big.Int).Add(next, big.NewInt(s.batchSize))
+		if end.Cmp(count) > 0 {
+			end = count
+		}
+
+		entries, read, readErr := s.read(next, end)
+		if read.Cmp(next) > 0 {
+			if err := s.write(entries, read); err != nil {
+				return err
+			}
+		}
+		if readErr != nil {
+			return readErr
+		}
+
+		next = end
+	}
+
+	return nil
+}
+
+// read reads the sarcophagi from start up to end, and returns the ones to index and the index of the first sarcophagus not read
+func (s *sweeper) read(start *big.Int, end *big.Int) (map[string]sweepEntry, *big.Int, error) {
+	entries := map[string]sweepEntry{}
+
+	i := new(big.Int).Set(start)
+	for ; i.Cmp(end) < 0; i.Add(i, big.NewInt(1)) {
+		identifier, err := s.backend.SarcophagusIdentifier(i)
+		if err != nil {
+			return entries, i, err
+		}
+
+		sarco, err := s.backend.Sarcophagus(identifier)
+		if err != nil {
+			return entries, i, err
+		}
+
+		if sarco.State == 1 && sarco.Archaeologist != s.self {
+			entries[hexutil.Encode(identifier[:])] = sweepEntry{windowEnd(sarco)}
+		}
+	}
+
+	return entries, i, nil
+}
+
+// write adds the entries to the index and moves the cursor in a single update
+func (s *sweeper) write(entries map[string]sweepEntry, next *big.Int) error {
+	return s.store.Update(func(b *store.Batch) error {
+		for key, entry := range entries {
+			if err := b.Put(sweepIndexBucket, key, entry); err != nil {
+				return err
+			}
+		}
+		return b.Put(sweepBucket, sweepCursorKey, next.String())
+	})
+}
+
+// expired returns the indexed sarcophagi whose window had closed when they were last read
+func (s *sweeper) expired(now time.Time) [][32]byte {
+	var identifiers [][32]byte
+
+	err := s.store.ForEach(sweepIndexBucket, func(key string, raw []byte) error {
+		var entry sweepEntry
+		if err := json.Unmarshal(raw, &entry); err != nil {
+			return fmt.Errorf("could not decode sweep index entry %v: %v", key, err)
+		}
+		if entry.Deadline.After(now) {
+			return nil
+		}
+
+		identifier, err := hexutil.Decode(key)
+		if err != nil || len(identifier) != 32 {
+			return fmt.Errorf("invalid sweep index identifier: %v", key)
+		}
+
+		var id [32]byte
+		copy(id[:], identifier)
+		identifiers = append(identifiers, id)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error reading the sweep index from %v: %v", s.store.Path(), err)
+	}
+
+	return identifiers
+}
+
+// check reads the sarcophagus again, and cleans it up if its window has closed and the cleanup is profitable.
+// Sarcophagi that are done (unwrapped, buried, cancelled, accused or cleaned up) are removed from the index.
+func (s *sweeper) check(identifier [32]byte, now time.Time) error {
+	key := hexutil.Encode(identifier[:])
+
+	sarco, err := s.backend.Sarcophagus(identifier)
+	if err != nil {
+		return err
+	}
+
+	if sarco.State != 1 {
+		return s.store.Delete(sweepIndexBucket, key)
+	}
+
+	// the sarcophagus has been rewrapped
+	if deadline := windowEnd(sarco); deadline.After(now) {
+		return s.store.Put(sweepIndexBucket, key, sweepEntry{deadline})
+	}
+
+	reward := cleanUpReward(sarco.CurrentCursedBond)
+	cost, err := s.backend.CleanUpCost(identifier)
+	if err != nil {
+		return fmt.Errorf("could not estimate the cost of cleaning up: %v", err)
+	}
+
+	profit := cleanUpProfit(reward, s.price, cost)
+	if profit.Sign() <= 0 || profit.Cmp(s.minProfit) < 0 {
+		log.Printf("Not cleaning up the sarcophagus %v yet. Reward: %v SARCO, cost: %v ETH, profit: %v ETH", key, utility.ToDecimal(reward, 18), utility.ToDecimal(cost, 18), utility.ToDecimal(profit, 18))
+		return nil
+	}
+
+	log.Printf("Cleaning up the sarcophagus %v of archaeologist %v. Reward: %v SARCO, cost: %v ETH, profit: %v ETH", key, sarco.Archaeologist.Hex(), utility.ToDecimal(reward, 18), utility.ToDecimal(cost, 18), utility.ToDecimal(profit, 18))
+	if err := s.backend.CleanUp(identifier); err != nil {
+		return err
+	}
+
+	log.Printf("Cleaned up the sarcophagus %v", key)
+	return s.store.Delete(sweepIndexBucket, key)
+}
+
+// sweepIndexPath returns the location of the sweep index, next to the state file
+func sweepIndexPath(stateFile string) string {
+	ext := filepath.Ext(stateFile)
+	return strings.TrimSuffix(stateFile, ext) + "_sweep_index" + ext
+}
+
+// windowEnd returns the end of the sarcophagus' resurrection window
+func windowEnd(sarco contracts.TypesSarcophagus) time.Time {
+	return time.Unix(new(big.Int).Add(sarco.ResurrectionTime, sarco.ResurrectionWindow).Int64(), 0)
+}
+
+// cleanUpReward returns the part of the cursed bond paid to the cleaner, the rest goes to the embalmer
+func cleanUpReward(cursedBond *big.Int) *big.Int {
+	return new(big.Int).Sub(cursedBond, new(big.Int).Div(cursedBond, big.NewInt(2)))
+}
+
+// cleanUpProfit returns the value of the reward in ETH less the cost, in wei
+func cleanUpProfit(reward *big.Int, price decimal.Decimal, cost *big.Int) *big.Int {
+	value := decimal.NewFromBigInt(reward, 0).Mul(price).BigInt()
+	return value.Sub(value, cost)
+}
+
+// archSweepBackend sweeps with the archaeologist's contract session and transaction manager
+type archSweepBackend struct {
+	archCleanUpBackend
+}
+
+func (b *archSweepBackend) SarcophagusCount() (*big.Int, error) {
+	return b.arch.SarcoSession.SarcophagusCount()
+}
+
+func (b *archSweepBackend) SarcophagusIdentifier(index *big.Int) ([32]byte, error) {
+	return b.arch.SarcoSession.SarcophagusIdentifier(index)
+}
+
+func (b *archSweepBackend) CleanUpCost(identifier [32]byte) (*big.Int, error) {
+	parsed, err := abi.JSON(strings.NewReader(contracts.SarcophagusABI))
+	if err != nil {
+		return nil, err
+	}
+
+	input, err := parsed.Pack("cleanUpSarcophagus", identifier, b.arch.PaymentAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	// estimating gas also checks the cleanup will succeed
+	msg := ethereum.CallMsg{From: b.arch.ArchAddress, To: &b.arch.SarcoAddress, Data: input}
+	gasLimit, err := b.arch.Client.EstimateGas(context.Background(), msg)
+	if err != nil {
+		return nil, err
+	}
+
+	fees, err := b.arch.TxManager.Fees(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).Mul(new(big.Int).SetUint64(gasLimit), fees.Price()), nil
+}
@@ -0,0 +1,174 @@
+package archaeologist
+
+import (
+	"errors"
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/contracts"
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/store"
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/utility"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type fakeSweepBackend struct {
+	identifiers [][32]byte
+	sarcophagi  map[[32]byte]contracts.TypesSarcophagus
+	cost        *big.Int
+	reads       int
+	cleaned     [][32]byte
+	broken      *[32]byte // the sarcophagus that cannot be read
+}
+
+func (b *fakeSweepBackend) SarcophagusCount() (*big.Int, error) {
+	return big.NewInt(int64(len(b.identifiers))), nil
+}
+
+func (b *fakeSweepBackend) SarcophagusIdentifier(index *big.Int) ([32]byte, error) {
+	return b.identifiers[index.Int64()], nil
+}
+
+func (b *fakeSweepBackend) Sarcophagus(identifier [32]byte) (contracts.TypesSarcophagus, error) {
+	if b.broken != nil && *b.broken == identifier {
+		return contracts.TypesSarcophagus{}, errors.New("could not read the sarcophagus")
+	}
+	b.reads += 1
+	return b.sarcophagi[identifier], nil
+}
+
+func (b *fakeSweepBackend) CleanUpCost(identifier [32]byte) (*big.Int, error) {
+	return b.cost, nil
+}
+
+func (b *fakeSweepBackend) CleanUp(identifier [32]byte) error {
+	b.cleaned = append(b.cleaned, identifier)
+	sarco := b.sarcophagi[identifier]
+	sarco.State = 2
+	b.sarcophagi[identifier] = sarco
+	return nil
+}
+
+func (b *fakeSweepBackend) add(identifier [32]byte, sarco contracts.TypesSarcophagus) {
+	b.identifiers = append(b.identifiers, identifier)
+	b.sarcophagi[identifier] = sarco
+}
+
+// testSarco returns a sarcophagus whose hour long resurrection window closes at windowEnd
+func testSarco(archaeologist common.Address, windowEnd time.Time, cursedBond string) contracts.TypesSarcophagus {
+	return contracts.TypesSarcophagus{
+		State:              1,
+		Archaeologist:      archaeologist,
+		ResurrectionTime:   big.NewInt(windowEnd.Add(-time.Hour).Unix()),
+		ResurrectionWindow: big.NewInt(int64(time.Hour / time.Second)),
+		CurrentCursedBond:  utility.ToWei(cursedBond, 18),
+	}
+}
+
+func testSweeper(t *testing.T) (*sweeper, *fakeSweepBackend) {
+	s, err := store.Open(filepath.Join(t.TempDir(), "state_sweep_index.json"))
+	assert.Nil(t, err)
+
+	backend := &fakeSweepBackend{
+		sarcophagi: map[[32]byte]contracts.TypesSarcophagus{},
+		cost:       utility.ToWei("0.01", 18),
+	}
+
+	return &sweeper{
+		backend:   backend,
+		store:     s,
+		self:      common.HexToAddress("0x01"),
+		price:     decimal.RequireFromString("0.001"),
+		minProfit: utility.ToWei("0.005", 18),
+		batchSize: 2,
+	}, backend
+}
+
+func TestSweeperIndexesOtherArchaeologists(t *testing.T) {
+	s, backend := testSweeper(t)
+	other := common.HexToAddress("0x02")
+	now := time.Now()
+
+	backend.add([32]byte{1}, testSarco(other, now.Add(time.Hour), "100"))
+	backend.add([32]byte{2}, testSarco(s.self, now.Add(-time.Hour), "100"))
+	done := testSarco(other, now.Add(-time.Hour), "100")
+	done.State = 2
+	backend.add([32]byte{3}, done)
+
+	assert.Nil(t, s.index())
+	assert.Len(t, s.store.Keys(sweepIndexBucket), 1)
+	assert.Len(t, s.expired(now), 0)
+	assert.Len(t, s.expired(now.Add(2*time.Hour)), 1)
+
+	// only new sarcophagi are read on the next sweep
+	backend.add([32]byte{4}, testSarco(other, now.Add(-time.Minute), "100"))
+	backend.reads = 0
+	assert.Nil(t, s.index())
+	assert.Equal(t, 1, backend.reads)
+	assert.Equal(t, [][32]byte{{4}}, s.expired(now))
+}
+
+func TestSweeperIndexesInBatches(t *testing.T) {
+	s, backend := testSweeper(t)
+	other := common.HexToAddress("0x02")
+	now := time.Now()
+
+	for i := byte(1); i <= 5; i++ {
+		backend.add([32]byte{i}, testSarco(other, now.Add(-time.Minute), "100"))
+	}
+	backend.broken = &[32]byte{4}
+
+	// the sarcophagi read before the error are saved
+	assert.NotNil(t, s.index())
+	reopened, err := store.Open(s.store.Path())
+	assert.Nil(t, err)
+	assert.Len(t, reopened.Keys(sweepIndexBucket), 3)
+	var cursor string
+	_, err = reopened.Get(sweepBucket, sweepCursorKey, &cursor)
+	assert.Nil(t, err)
+	assert.Equal(t, "3", cursor)
+
+	// the next sweep starts from the sarcophagus that could not be read
+	backend.broken = nil
+	backend.reads = 0
+	assert.Nil(t, s.index())
+	assert.Equal(t, 2, backend.reads)
+	assert.Len(t, s.store.Keys(sweepIndexBucket), 5)
+}
+
+func TestSweepIndexPath(t *testing.T) {
+	assert.Equal(t, "archaeologist_state_sweep_index.json", sweepIndexPath("archaeologist_state.json"))
+	assert.Equal(t, filepath.Join("data", "state_sweep_index"), sweepIndexPath(filepath.Join("data", "state")))
+}
+
+func TestSweeperCleansUpProfitableSarcophagi(t *testing.T) {
+	s, backend := testSweeper(t)
+	other := common.HexToAddress("0x02")
+	now := time.Now()
+
+	// reward of 5 SARCO is worth 0.005 ETH, not enough to cover the gas
+	backend.add([32]byte{1}, testSarco(other, now.Add(-time.Minute), "10"))
+	// reward of 50 SARCO is worth 0.05 ETH, a profit of 0.04 ETH
+	backend.add([32]byte{2}, testSarco(other, now.Add(-time.Minute), "100"))
+	// rewrapped since it was indexed
+	backend.add([32]byte{3}, testSarco(other, now.Add(-time.Minute), "100"))
+
+	assert.Nil(t, s.index())
+	backend.sarcophagi[[32]byte{3}] = testSarco(other, now.Add(time.Hour), "100")
+
+	s.sweep(now)
+
+	assert.Equal(t, [][32]byte{{2}}, backend.cleaned)
+	assert.Equal(t, [][32]byte{{1}}, s.expired(now))
+	assert.Equal(t, [][32]byte{{1}, {3}}, s.expired(now.Add(2*time.Hour)))
+}
+
+func TestCleanUpProfit(t *testing.T) {
+	reward := cleanUpReward(big.NewInt(101))
+	assert.Equal(t, big.NewInt(51), reward)
+
+	profit := cleanUpProfit(utility.ToWei("50", 18), decimal.RequireFromString("0.001"), utility.ToWei("0.01", 18))
+	assert.Equal(t, utility.ToWei("0.04", 18), profit)
+}
@@ -2,163 +2,361 @@
 package archaeologist
 
 import (
+	"bytes"
 	"context"
 	"crypto/ecdsa"
-	"github.com/Dev43/arweave-go/api"
+	"errors"
+	"fmt"
 	"github.com/Dev43/arweave-go/utils"
 	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/contracts"
-	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/models"
+	ar "github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/arweave"
 	eth "github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/ethereum"
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/hdw"
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/models"
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/scheduler"
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/store"
 	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/utility"
 	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
 	"log"
-	"math/big"
 	"math/rand"
+	"os"
 	"strings"
-	"sync"
+	"text/tabwriter"
 	"time"
 )
 
 const (
-	UNWRAP_RETRY_LIMIT = 3 // How many attempts after first failure to retry the unwrapping if it fails
 	UNWRAP_RETRY_INTERVAL_LB = 1000 // lower bound of retry in centiseconds (10 seconds)
 	UNWRAP_RETRY_INTERVAL_UB = 10000 // upper bound of retry in centiseconds (100 seconds)
+	UNWRAP_URGENT_RETRY_INTERVAL = 10 * time.Second // retry interval once less than half of the resurrection window is left
+	UNWRAP_DELAY = 2 * time.Second // delay after the resurrection time before the first attempt, so the unwrap is not mined before the resurrection time
+	UNWRAP_ARWEAVE_TIMEOUT = 10 * time.Second // how long the file can take to retrieve from arweave when there is no local copy
 )
-var mutex = &sync.Mutex{}
 
-// scheduleUnwrap is responsible for scheduling and
-// unwrapping a sarcophagus. The unwrapping will be
-// executed at the resurrectionTime passed into the function.
-//
-// Before unwrapping, it will check if the sarcophagus's resurrection time in
-// state matches the resurrectionTime param
-// If they don't match, then the sarcophagus has been rewrapped,
-// and scheduleUnwrap will return without unwrapping.
+// Urgency of a failing unwrap, based on how much of the resurrection window is left
+const (
+	unwrapUrgencyNormal = iota
+	unwrapUrgencyUrgent // less than half of the window is left
+	unwrapUrgencyCritical // less than a quarter of the window is left
+)
+
+// scheduleUnwrap adds an unwrap job for the sarcophagus at its resurrection time
+// The job replaces any unwrap already scheduled for the sarcophagus at a different resurrection time (i.e. it has been rewrapped).
+func scheduleUnwrap(arch *models.Archaeologist, assetDoubleHash [32]byte, assetId string, sarco models.Sarco) {
+	arch.UnwrapScheduler.Schedule(scheduler.Job{
+		Identifier:         assetDoubleHash,
+		AssetId:            assetId,
+		ResurrectionTime:   sarco.ResurrectionTime,
+		ResurrectionWindow: sarco.ResurrectionWindow,
+		KeyIndex:           sarco.AccountIndex,
+		NextAttempt:        time.Unix(sarco.ResurrectionTime.Int64(), 0).Add(UNWRAP_DELAY),
+	})
+}
+
+// newUnwrapRunner .
+func newUnwrapRunner(arch *models.Archaeologist) *unwrapRunner {
+	r := &unwrapRunner{
+		arch:      arch,
+		alerted:   map[[32]byte]int{},
+		pendingTx: map[[32]byte]*unwrapTx{},
+		random:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	if arch != nil {
+		r.waitMined = func(hash common.Hash) error {
+			_, err := arch.TxManager.Wait(context.Background(), hash)
+			return err
+		}
+		r.expedite = arch.UnwrapScheduler.Expedite
+	}
+
+	return r
+}
+
+// unwrapRunner attempts unwrap jobs for the scheduler
 //
-// If the sarcophagus does not exist in state, then scheduleUnwrap
-// will return without unwrapping.
+// Before unwrapping, it will check the sarcophagus is still in state, is updated,
+// and that its resurrection time in state matches the resurrection time of the job.
+// If not, the sarcophagus has been rewrapped, cleaned, buried, cancelled, accused
+// or its update was removed by a chain reorg, and the job is dropped without unwrapping.
 //
-// To complete a successful unwrapping the function must:
-//   1. Generate the Single Hash (Single Encrypted File Bytes -
-//      Encrypted with Recipients public key only)
+// To complete a successful unwrapping the runner must:
+//   1. Verify the file for the sarcophagus can be decrypted with the private key (see verifyPayload).
+//      This check is advisory, the contract does not need the file, so if it fails a warning is logged
+//      and the unwrap goes ahead.
 //   2. Call the UnwrapSarcophagus function on the smart contract.
 //
-// Before calling unwrapSarcphagus, scheduleUnwrap will attempt
+// Before calling unwrapSarcphagus, the runner will attempt
 // to estimate the gas for the contract call
 // This allows us to know if the contract call will fail
 // without actually calling it.
 //
-// If estimateGas or UnwrapSarcophagus fails, scheduleUnwrap will
-// keep track of how many times the unwrapping has failed for this sarcophagus.
-// In the event of a failure (either of estimateGas or UnwrapSarcophagus),
-// it will reschedule the unwrap UNWRAP_RETRY_LIMIT number of times.
-// The retry will be scheduled at a random interval
-// between UNWRAP_RETRY_INTERVAL_LB and UNWRAP_RETRY_INTERVAL_UB seconds.
+// If any step fails, the job is retried until the resurrection window closes (see Retry).
 //
-// The random interval is put in place in case multiple unwraps
-// are scheduled at the same exact time.
-//   - In this case, there may be a nonce issue when creating the
-//     unwrapSarcophagus transaction, so this avoids rescheduling
-//     unwraps at the same time
-func scheduleUnwrap(session *contracts.SarcophagusSession, arweaveClient *api.Client, resurrectionTime *big.Int, arch *models.Archaeologist, assetDoubleHash [32]byte, privateKey *ecdsa.PrivateKey, assetId string) {
-	timeToUnwrap := time.Until(time.Unix(resurrectionTime.Int64(), 0))
+// The runner does not wait for the unwrap transaction to be mined, so a slow transaction does not hold up
+// the other unwraps that are due. The receipt is waited for in the background, and the attempt that submitted
+// the transaction fails with errUnwrapPending. Once the receipt is in, the job is made due again and the next
+// attempt removes the sarcophagus from state, or acts on the error. A transaction that is not mined within
+// TX_TIMEOUT is still tracked by the transaction manager, so it is waited for again instead of sending another one.
+type unwrapRunner struct {
+	arch      *models.Archaeologist
+	alerted   map[[32]byte]int       // highest urgency alerted for each failing job, only used by the scheduler worker
+	pendingTx map[[32]byte]*unwrapTx // unwrap transaction sent for each job, only used by the scheduler worker
+	random    *rand.Rand             // source of the retry intervals, only used by the scheduler worker
+	waitMined func(hash common.Hash) error
+	expedite  func(identifier [32]byte)
+}
+
+// unwrapTx is an unwrap transaction that is waited for in the background
+type unwrapTx struct {
+	hash common.Hash
+	done chan struct{} // closed once err is set
+	err  error
+}
+
+var errUnwrapPending = errors.New("the unwrap transaction has not been mined yet")
+
+// Run .
+func (r *unwrapRunner) Run(job scheduler.Job) error {
+	err := r.unwrap(job)
+	if err == nil {
+		r.forget(job.Identifier)
+	}
+
+	return err
+}
+
+// forget clears what the runner keeps for a job that will not be attempted again
+func (r *unwrapRunner) forget(identifier [32]byte) {
+	delete(r.alerted, identifier)
+	delete(r.pendingTx, identifier)
+}
+
+// unwrap attempts to unwrap the sarcophagus
+func (r *unwrapRunner) unwrap(job scheduler.Job) error {
+	arch := r.arch
+
+	sarcophagus, ok := arch.Registry.Sarco(job.Identifier)
+	if !ok {
+		// Sarcophagus does not exist in state
+		// It has either been cleaned / buried / cancelled / accused
+		log.Printf("Unwrapping cancelled. Sarcophagus was cancelled, buried, or cleaned, or archaeologist was successfully accused.")
+		return nil
+	}
 
+	if !sarcophagus.Updated {
+		log.Printf("Unwrapping cancelled. Sarcophagus update was removed by a chain reorg.")
+		return nil
+	}
+
+	if sarcophagus.ResurrectionTime.Cmp(job.ResurrectionTime) != 0 {
+		log.Printf("Sarco has been rewrapped, do not need to unwrap!")
+		return nil
+	}
+
+	tx, ok := r.pendingTx[job.Identifier]
+	if !ok {
+		txHash, err := r.submit(job)
+		if err != nil {
+			return err
+		}
+		tx = r.wait(job.Identifier, txHash)
+		r.pendingTx[job.Identifier] = tx
+	}
+
+	select {
+	case <-tx.done:
+	default:
+		return fmt.Errorf("%w. Transaction ID: %s", errUnwrapPending, tx.hash.Hex())
+	}
+
+	txHash, err := tx.hash, tx.err
+	switch {
+	case err == nil:
+		log.Printf("Unwrap Sarcophagus Transaction Successful. Transaction ID: %s", txHash.Hex())
+
+	case errors.Is(err, eth.ErrTimedOut):
+		// the transaction is still tracked by the transaction manager, so it is waited for again
+		r.pendingTx[job.Identifier] = r.wait(job.Identifier, txHash)
+		return fmt.Errorf("%w. Transaction ID: %s", errUnwrapPending, txHash.Hex())
+
+	case errors.Is(err, eth.ErrReverted):
+		delete(r.pendingTx, job.Identifier)
+
+		// The unwrap reverts if the sarcophagus is no longer open, e.g. it was unwrapped by a transaction
+		// sent before a restart, or it was accused. There is nothing left to do for it then.
+		sarco, contractErr := arch.SarcoSession.Sarcophagus(job.Identifier)
+		if contractErr != nil || sarco.State == 1 {
+			return fmt.Errorf("the unwrap transaction %s reverted", txHash.Hex())
+		}
+		log.Printf("Unwrap transaction reverted, the sarcophagus is no longer open: %v", job.Identifier)
+
+	default:
+		delete(r.pendingTx, job.Identifier)
+		return fmt.Errorf("there was an error mining the unwrap transaction %s: %v", txHash.Hex(), err)
+	}
+
+	// Remove from state
+	arch.RemoveArchSarcophagus(job.Identifier)
+	return nil
+}
+
+// wait waits for the unwrap transaction in the background, and makes the job due once it is mined or has failed
+func (r *unwrapRunner) wait(identifier [32]byte, hash common.Hash) *unwrapTx {
+	tx := &unwrapTx{hash: hash, done: make(chan struct{})}
+
+	go func() {
+		tx.err = r.waitMined(hash)
+		close(tx.done)
+		r.expedite(identifier)
+	}()
+
+	return tx
+}
+
+// submit sends the unwrap transaction for the job
+func (r *unwrapRunner) submit(job scheduler.Job) (common.Hash, error) {
+	arch := r.arch
+
+	privateKey := hdw.PrivateKeyFromIndex(arch.Wallet, job.KeyIndex)
 	var privateKeyBytes [32]byte
 	copy(privateKeyBytes[:], crypto.FromECDSA(privateKey))
 
-	time.AfterFunc(timeToUnwrap, func() {
-		// Delay the unwrap time by a couple seconds in case of unwraps scheduled during service startup that are in the past
-		time.Sleep(2000 * time.Millisecond)
-
-		// Confirm the sarcophagus is in state
-		if sarcophagus, ok := arch.Sarcophaguses[assetDoubleHash]; ok {
-			resTime := sarcophagus.ResurrectionTime
-			// Confirm resurrection time passed at the time of the function call matches the
-			// resurrection time in state for this sarcophagus
-			if resTime.Cmp(resurrectionTime) == 0 {
-
-				// Validate that we can generate the single hash
-				_, err := generateSingleHash(arweaveClient, assetId, privateKey)
-				if err != nil {
-					log.Printf("Error generating single hash during unwrapping process. Most likely the arweave transaction was not finished being mined or failed. Unwrapping cancelled: %v", err)
-				} else {
-					mutex.Lock()
-					attempts := sarcophagus.UnwrapAttempts
-					mutex.Unlock()
-
-					attempts += 1
-
-					mutex.Lock()
-					sarcophagus.UnwrapAttempts = attempts
-					mutex.Unlock()
-
-					// estimate gas is used to check if the unwrap will succeed
-					err := estimateGasForUnwrap(arch, assetDoubleHash, privateKeyBytes)
-
-					if err != nil {
-						log.Printf("Unwrapping aborted, transaction will fail: %v", err)
-						if attempts <= UNWRAP_RETRY_LIMIT {
-							time.Sleep(randomRetryInterval() * time.Millisecond)
-							scheduleUnwrap(session, arweaveClient, resTime, arch, assetDoubleHash, privateKey, assetId)
-						}
-					} else {
-						txn, err := session.UnwrapSarcophagus(assetDoubleHash, privateKeyBytes)
-						if err != nil {
-							log.Printf("Transaction reverted. There was an error unwrapping the sarcophagus: %v", err)
-							if attempts <= UNWRAP_RETRY_LIMIT {
-								time.Sleep(randomRetryInterval() * time.Millisecond)
-								scheduleUnwrap(session, arweaveClient, resTime, arch, assetDoubleHash, privateKey, assetId)
-							}
-							// TODO: Do we need to remove the sarco from state if the unwrap fails more than the allowed times?
-						} else {
-							log.Printf("Unwrap Sarcophagus Transaction Submitted. Transaction ID: %s", txn.Hash().Hex())
-							log.Printf("Gas Used: %v", txn.Gas())
-							log.Printf("AssetDoubleHash: %v", assetDoubleHash)
-
-							err = eth.WaitMined(arch.Client, txn.Hash(), "Unwrap Sarcophagus")
-
-							if err != nil {
-								if attempts <= UNWRAP_RETRY_LIMIT {
-									log.Printf("There was an error mining the unwrap archaeologist transaction: %v. Retrying...", err)
-									scheduleUnwrap(session, arweaveClient, resTime, arch, assetDoubleHash, privateKey, assetId)
-								}
-							} else {
-								log.Printf("Unwrap Sarcophagus Transaction Successful. Transaction ID: %s", txn.Hash().Hex())
-
-								// Remove from state
-								arch.RemoveArchSarcophagus(assetDoubleHash)
-							}
-						}
-					}
-				}
-			} else {
-				// Resurrection time is different in state for this sarc, meaning sarc has been rewrapped
-				log.Printf("Sarco has been rewrapped, do not need to unwrap!")
-			}
-		} else {
-			// Sarcophagus does not exist in state
-			// It has either been cleaned / buried / cancelled / accused
-			// Do nothing
-			log.Printf("Unwrapping cancelled. Sarcophagus was cancelled, buried, or cleaned, or archaeologist was successfully accused.")
-		}
+	if err := verifyPayload(arch, job, privateKey); err != nil {
+		log.Printf("WARNING: Could not verify the file for the double hash %v, unwrapping anyway: %v", job.Identifier, err)
+	}
+
+	// estimate gas is used to check if the unwrap will succeed
+	if err := estimateGasForUnwrap(arch, job.Identifier, privateKeyBytes); err != nil {
+		return common.Hash{}, fmt.Errorf("unwrapping aborted, transaction will fail: %v", err)
+	}
+
+	// The transaction's fees are bumped more aggressively as the end of the resurrection window approaches
+	txn, err := arch.TxManager.SubmitBefore("Unwrap Sarcophagus", job.Deadline(), func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return arch.SarcoSession.Contract.UnwrapSarcophagus(opts, job.Identifier, privateKeyBytes)
 	})
+	if err != nil {
+		return common.Hash{}, fmt.Errorf("there was an error unwrapping the sarcophagus: %v", err)
+	}
+
+	log.Printf("Unwrap Sarcophagus Transaction Submitted. Transaction ID: %s", txn.Hash().Hex())
+	log.Printf("Gas Used: %v", txn.Gas())
+	log.Printf("AssetDoubleHash: %v", job.Identifier)
+
+	return txn.Hash(), nil
+}
+
+// Retry keeps retrying a failed unwrap until the resurrection window closes, as the cursed bond is lost if it is missed.
+// While more than half of the window is left, the next attempt is at a random interval, in case multiple unwraps
+// failed at the same time (e.g. because the eth node was unavailable).
+// After that the unwrap is retried every UNWRAP_URGENT_RETRY_INTERVAL, and an alert is raised when half and
+// then a quarter of the window is left, so the operator can step in before the deadline.
+func (r *unwrapRunner) Retry(job scheduler.Job) (time.Time, bool) {
+	now := time.Now()
+	deadline := job.Deadline()
+	doubleHash := hexutil.Encode(job.Identifier[:])
+
+	if !now.Before(deadline) {
+		r.forget(job.Identifier)
+		utility.Alert("Unwrap of sarcophagus %v failed %v times and its resurrection window has closed, the cursed bond will be lost. Last error: %v",
+			doubleHash, job.Attempts, job.LastError)
+		return time.Time{}, false
+	}
+
+	remaining := deadline.Sub(now)
+	urgency := unwrapUrgency(job, now)
+
+	if urgency > r.alerted[job.Identifier] {
+		r.alerted[job.Identifier] = urgency
+
+		switch urgency {
+		case unwrapUrgencyUrgent:
+			utility.Alert("Unwrap of sarcophagus %v has failed %v times and less than half of its resurrection window is left (closes in %v). Last error: %v",
+				doubleHash, job.Attempts, remaining.Round(time.Second), job.LastError)
+		case unwrapUrgencyCritical:
+			utility.Alert("CRITICAL: Unwrap of sarcophagus %v has failed %v times and its resurrection window closes in %v, the cursed bond will be lost if it is not unwrapped. Last error: %v",
+				doubleHash, job.Attempts, remaining.Round(time.Second), job.LastError)
+		}
+	}
 
-	log.Println("Unwrap scheduled in:", timeToUnwrap)
+	interval := randomRetryInterval(r.random) * time.Millisecond
+	if urgency > unwrapUrgencyNormal {
+		interval = UNWRAP_URGENT_RETRY_INTERVAL
+	}
+
+	// leave time for another attempt before the window closes
+	if interval > remaining/2 {
+		interval = remaining / 2
+	}
+
+	return now.Add(interval), true
+}
+
+// unwrapUrgency returns how urgent the unwrap is at the time, based on how much of the resurrection window is left
+func unwrapUrgency(job scheduler.Job, at time.Time) int {
+	var window time.Duration
+	if job.ResurrectionWindow != nil {
+		window = time.Duration(job.ResurrectionWindow.Int64()) * time.Second
+	}
+
+	remaining := job.Deadline().Sub(at)
+	switch {
+	case remaining <= window/4:
+		return unwrapUrgencyCritical
+	case remaining <= window/2:
+		return unwrapUrgencyUrgent
+	}
+
+	return unwrapUrgencyNormal
 }
 
 // randomRetryInterval - used to schedule the unwrap time randomly between 2 values in the future
 // in the event that the unwrap fails
-func randomRetryInterval() time.Duration {
-	rand.Seed(time.Now().UnixNano())
-	return time.Duration((rand.Intn(UNWRAP_RETRY_INTERVAL_UB - UNWRAP_RETRY_INTERVAL_LB) + UNWRAP_RETRY_INTERVAL_LB) * 10)
+func randomRetryInterval(random *rand.Rand) time.Duration {
+	return time.Duration((random.Intn(UNWRAP_RETRY_INTERVAL_UB - UNWRAP_RETRY_INTERVAL_LB) + UNWRAP_RETRY_INTERVAL_LB) * 10)
+}
+
+// verifyPayload checks the private key decrypts the file for the sarcophagus to its single hash
+// The local copy kept when the file was uploaded is checked. If there is none, the file is retrieved from arweave
+// in a single request bounded by UNWRAP_ARWEAVE_TIMEOUT, as the check runs on the scheduler worker and holds up
+// the other unwraps that are due.
+func verifyPayload(arch *models.Archaeologist, job scheduler.Job, privateKey *ecdsa.PrivateKey) error {
+	payload, loadErr := arch.LoadPayload(job.Identifier)
+	if loadErr == nil {
+		decryptedPayload, err := utility.DecryptFile(payload, privateKey)
+		if err != nil {
+			return fmt.Errorf("could not decrypt the local copy of the file: %v", err)
+		}
+		return checkSingleHash(crypto.Keccak256(decryptedPayload), job.Identifier)
+	}
+
+	singleHash, err := generateSingleHash(arch.ArweaveNode, job.AssetId, privateKey)
+	if err != nil {
+		return fmt.Errorf("there is no local copy of the file (%v) and it could not be retrieved from arweave (%v)", loadErr, err)
+	}
+
+	log.Printf("There is no local copy of the file, verified the file on arweave instead")
+	return checkSingleHash(singleHash, job.Identifier)
+}
+
+// checkSingleHash returns an error if the hash of the single hash is not the sarcophagus double hash
+func checkSingleHash(singleHash []byte, assetDoubleHash [32]byte) error {
+	if !bytes.Equal(crypto.Keccak256(singleHash), assetDoubleHash[:]) {
+		return fmt.Errorf("the decrypted file does not match the double hash")
+	}
+
+	return nil
 }
 
 // generateSingleHash - returns a hash of the arweave file bytes decrypted with the private key
-func generateSingleHash(arweaveClient *api.Client, assetId string, privateKey *ecdsa.PrivateKey) ([]byte, error) {
+func generateSingleHash(arweaveNode string, assetId string, privateKey *ecdsa.PrivateKey) ([]byte, error) {
 	log.Printf("Getting arweave data for assetID: %v", assetId)
-	dataString, err := arweaveClient.GetData(context.Background(), assetId)
+	dataString, err := ar.ArweaveData(arweaveNode, assetId, UNWRAP_ARWEAVE_TIMEOUT)
 	if err != nil {
 		return nil, err
 	}
@@ -194,4 +392,47 @@ func estimateGasForUnwrap(arch *models.Archaeologist, assetDoubleHash [32]byte,
 		return err
 	}
 	return nil
-}
\ No newline at end of file
+}
+
+// PrintUnwrapJobs lists the upcoming and failed unwrap jobs saved in the state file
+func PrintUnwrapJobs(config *models.Config) error {
+	stateStore, err := store.Open(stateFilePath(config.STATE_FILE))
+	if err != nil {
+		return err
+	}
+
+	jobs, err := scheduler.LoadJobs(stateStore)
+	if err != nil {
+		return err
+	}
+
+	if len(jobs) == 0 {
+		fmt.Println("No unwrap jobs are scheduled.")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DOUBLE HASH\tASSET ID\tRESURRECTION TIME\tDEADLINE\tNEXT ATTEMPT\tATTEMPTS\tSTATUS\tLAST ERROR")
+
+	for _, job := range jobs {
+		status := "upcoming"
+		if job.Failed {
+			status = "failed"
+		} else if job.Attempts > 0 {
+			status = "retrying"
+		}
+
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+			hexutil.Encode(job.Identifier[:]),
+			job.AssetId,
+			time.Unix(job.ResurrectionTime.Int64(), 0).Format(time.RFC3339),
+			job.Deadline().Format(time.RFC3339),
+			job.NextAttempt.Format(time.RFC3339),
+			job.Attempts,
+			status,
+			job.LastError,
+		)
+	}
+
+	return w.Flush()
+}
@@ -0,0 +1,155 @@
+package archaeologist
+
+import (
+	crand "crypto/rand"
+	"errors"
+	"github.com/Dev43/arweave-go/utils"
+	eth "github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/ethereum"
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/models"
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/scheduler"
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/utility"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/crypto/ecies"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// testUnwrapJob returns a failed job whose resurrection window of an hour closes after remaining
+func testUnwrapJob(remaining time.Duration) scheduler.Job {
+	window := time.Hour
+	resurrectionTime := time.Now().Add(remaining).Add(-window)
+
+	return scheduler.Job{
+		Identifier:         [32]byte{1},
+		ResurrectionTime:   big.NewInt(resurrectionTime.Unix()),
+		ResurrectionWindow: big.NewInt(int64(window / time.Second)),
+		Attempts:           1,
+		NextAttempt:        resurrectionTime,
+		LastError:          "node unavailable",
+	}
+}
+
+func TestUnwrapRetriesUntilWindowCloses(t *testing.T) {
+	runner := newUnwrapRunner(nil)
+
+	// there is no fixed retry limit while the window is open
+	job := testUnwrapJob(50 * time.Minute)
+	job.Attempts = 100
+	next, ok := runner.Retry(job)
+	assert.True(t, ok)
+	assert.True(t, next.Before(job.Deadline()))
+	assert.Equal(t, unwrapUrgencyNormal, runner.alerted[job.Identifier])
+
+	_, ok = runner.Retry(testUnwrapJob(-time.Second))
+	assert.False(t, ok)
+}
+
+func TestUnwrapRetryEscalates(t *testing.T) {
+	runner := newUnwrapRunner(nil)
+
+	job := testUnwrapJob(25 * time.Minute)
+	next, ok := runner.Retry(job)
+	assert.True(t, ok)
+	assert.Equal(t, unwrapUrgencyUrgent, runner.alerted[job.Identifier])
+	assert.True(t, time.Until(next) <= UNWRAP_URGENT_RETRY_INTERVAL)
+
+	job = testUnwrapJob(10 * time.Minute)
+	_, ok = runner.Retry(job)
+	assert.True(t, ok)
+	assert.Equal(t, unwrapUrgencyCritical, runner.alerted[job.Identifier])
+
+	// the last attempts are scheduled before the window closes
+	job = testUnwrapJob(4 * time.Second)
+	next, ok = runner.Retry(job)
+	assert.True(t, ok)
+	assert.True(t, next.Before(job.Deadline()))
+}
+
+func TestUnwrapDoesNotWaitForTheTransaction(t *testing.T) {
+	arch := testProcessor(t, 0).arch
+	job := testUnwrapJob(time.Hour)
+	arch.Registry.Replace(map[[32]byte]*models.Sarco{
+		job.Identifier: {ResurrectionTime: job.ResurrectionTime, ResurrectionWindow: job.ResurrectionWindow, Updated: true},
+	}, map[[32]byte]*big.Int{}, 1)
+
+	mined := make(chan error)
+	expedited := make(chan [32]byte, 1)
+	runner := newUnwrapRunner(nil)
+	runner.waitMined = func(hash common.Hash) error { return <-mined }
+	runner.expedite = func(identifier [32]byte) { expedited <- identifier }
+	runner.arch = arch
+
+	// the transaction was submitted by a previous attempt and is not mined yet
+	runner.pendingTx[job.Identifier] = runner.wait(job.Identifier, common.HexToHash("0x01"))
+	assert.True(t, errors.Is(runner.Run(job), errUnwrapPending))
+
+	// once the transaction is mined, the job is made due and the next attempt removes the sarcophagus
+	mined <- nil
+	assert.Equal(t, job.Identifier, <-expedited)
+	assert.Nil(t, runner.Run(job))
+	assert.False(t, arch.IsArchSarcophagus(job.Identifier))
+	assert.Empty(t, runner.pendingTx)
+}
+
+func TestUnwrapWaitsAgainAfterTimeout(t *testing.T) {
+	arch := testProcessor(t, 0).arch
+	job := testUnwrapJob(time.Hour)
+	arch.Registry.Replace(map[[32]byte]*models.Sarco{
+		job.Identifier: {ResurrectionTime: job.ResurrectionTime, ResurrectionWindow: job.ResurrectionWindow, Updated: true},
+	}, map[[32]byte]*big.Int{}, 1)
+
+	results := make(chan error, 2)
+	results <- eth.ErrTimedOut
+	expedited := make(chan [32]byte, 2)
+	runner := newUnwrapRunner(nil)
+	runner.waitMined = func(hash common.Hash) error { return <-results }
+	runner.expedite = func(identifier [32]byte) { expedited <- identifier }
+	runner.arch = arch
+
+	hash := common.HexToHash("0x01")
+	runner.pendingTx[job.Identifier] = runner.wait(job.Identifier, hash)
+	<-expedited
+
+	// the same transaction is waited for again instead of sending another one
+	assert.True(t, errors.Is(runner.Run(job), errUnwrapPending))
+	assert.Equal(t, hash, runner.pendingTx[job.Identifier].hash)
+
+	results <- nil
+	<-expedited
+	assert.Nil(t, runner.Run(job))
+}
+
+func TestVerifyPayloadChecksTheLocalCopyFirst(t *testing.T) {
+	privateKey, err := crypto.GenerateKey()
+	assert.Nil(t, err)
+	file := []byte("the sarcophagus payload")
+	encrypted, err := ecies.Encrypt(crand.Reader, ecies.ImportECDSAPublic(&privateKey.PublicKey), file, nil, nil)
+	assert.Nil(t, err)
+
+	requests := 0
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests += 1
+		w.Write([]byte(utils.EncodeToBase64(encrypted)))
+	}))
+	defer node.Close()
+
+	job := scheduler.Job{Identifier: utility.FileBytesToDoubleHashBytes(file), AssetId: "asset"}
+	arch := &models.Archaeologist{PayloadDir: t.TempDir(), ArweaveNode: node.URL}
+	assert.Nil(t, arch.SavePayload(job.Identifier, encrypted))
+
+	assert.Nil(t, verifyPayload(arch, job, privateKey))
+	assert.Equal(t, 0, requests)
+
+	// without a local copy the file is retrieved from arweave
+	arch.RemovePayload(job.Identifier)
+	assert.Nil(t, verifyPayload(arch, job, privateKey))
+	assert.Equal(t, 1, requests)
+
+	job.Identifier = [32]byte{1}
+	assert.NotNil(t, verifyPayload(arch, job, privateKey))
+}
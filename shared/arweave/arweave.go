@@ -3,19 +3,73 @@ package ar
 import (
 	"context"
 	"fmt"
-	"github.com/Dev43/arweave-go/api"
 	"github.com/Dev43/arweave-go/transactor"
 	"github.com/Dev43/arweave-go/wallet"
-	"log"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
 )
 
-func ArweaveBalance(client *api.Client, arWallet *wallet.Wallet) string {
+// BalanceClient is the part of the arweave client used to get a wallet's balance
+type BalanceClient interface {
+	GetBalance(ctx context.Context, address string) (string, error)
+}
+
+// ArweaveBalance returns the balance of the wallet in winston
+func ArweaveBalance(client BalanceClient, arWallet *wallet.Wallet) (string, error) {
 	balance, err := client.GetBalance(context.Background(), arWallet.Address())
 	if err != nil {
-		log.Fatal("couldnt get arweave balance %s", balance)
+		return "", fmt.Errorf("couldnt get arweave balance: %v", err)
+	}
+
+	return balance, nil
+}
+
+// ARWEAVE_PRICE_TIMEOUT is how long to wait for the arweave node to price an upload
+const ARWEAVE_PRICE_TIMEOUT = 30 * time.Second
+
+var priceClient = &http.Client{Timeout: ARWEAVE_PRICE_TIMEOUT}
+
+// ArweaveReward returns the reward in winston for uploading a file of the size
+// The node prices an upload by its size, so the file is not needed.
+func ArweaveReward(arweaveNode string, bytes int64) (string, error) {
+	reward, err := get(priceClient, fmt.Sprintf("%s/price/%d", arweaveNode, bytes))
+	if err != nil {
+		return "", fmt.Errorf("couldnt get arweave reward: %v", err)
+	}
+
+	return reward, nil
+}
+
+// ArweaveData returns the base64url encoded data of the transaction, giving up after the timeout
+// The arweave-go client does not pass its context on to the request, so it cannot be used when the request must be bounded.
+func ArweaveData(arweaveNode string, txID string, timeout time.Duration) (string, error) {
+	data, err := get(&http.Client{Timeout: timeout}, fmt.Sprintf("%s/tx/%s/data", arweaveNode, txID))
+	if err != nil {
+		return "", fmt.Errorf("couldnt get arweave data: %v", err)
+	}
+
+	return data, nil
+}
+
+// get returns the body of a successful GET request
+func get(client *http.Client, url string) (string, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", fmt.Errorf("%v %v", resp.Status, strings.TrimSpace(string(body)))
 	}
 
-	return balance
+	return string(body), nil
 }
 
 func InitArweaveTransactor(arweaveNode string) (*transactor.Transactor, error) {
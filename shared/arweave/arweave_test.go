@@ -0,0 +1,46 @@
+package ar
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestArweaveReward(t *testing.T) {
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/price/3145728" {
+			http.Error(w, "Not Found", http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("1234567"))
+	}))
+	defer node.Close()
+
+	reward, err := ArweaveReward(node.URL, 3145728)
+	assert.Nil(t, err)
+	assert.Equal(t, "1234567", reward)
+
+	_, err = ArweaveReward(node.URL, 1)
+	assert.NotNil(t, err)
+}
+
+func TestArweaveDataTimesOut(t *testing.T) {
+	release := make(chan struct{})
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/tx/slow/data" {
+			<-release
+		}
+		w.Write([]byte("ZGF0YQ"))
+	}))
+	defer node.Close()
+	defer close(release)
+
+	data, err := ArweaveData(node.URL, "fast", time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, "ZGF0YQ", data)
+
+	_, err = ArweaveData(node.URL, "slow", 50*time.Millisecond)
+	assert.NotNil(t, err)
+}
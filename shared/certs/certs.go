@@ -0,0 +1,152 @@
+// Certs provides the TLS certificates for the file server, so it can serve https without a reverse proxy.
+// Certificates are either:
+// 1. Loaded from a certificate and key file. The files are reloaded when they change,
+//    so a renewed certificate is used without restarting the service.
+// 2. Requested from an ACME certificate authority (Let's Encrypt by default) and renewed automatically.
+//    Any ACME directory can be used, e.g. a local test CA.
+
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Reloader serves the certificate in certFile and keyFile, reloading it when either file changes
+type Reloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time // latest modification time of the files the certificate was loaded from
+}
+
+// NewReloader loads the certificate, returns an error if it cannot be loaded
+func NewReloader(certFile string, keyFile string) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// GetCertificate is used as the tls.Config GetCertificate
+// If the files have changed but the new certificate cannot be loaded (e.g. only one file has been replaced so far),
+// the previous certificate is served until it can be.
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if err := r.reload(); err != nil {
+		log.Printf("Could not reload the TLS certificate, using the previous one: %v", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cert, nil
+}
+
+// reload loads the certificate if the files have changed since it was last loaded
+func (r *Reloader) reload() error {
+	modTime, err := latestModTime(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.cert != nil && !modTime.After(r.modTime) {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("could not load the TLS certificate from %v and %v: %v", r.certFile, r.keyFile, err)
+	}
+
+	if r.cert != nil {
+		log.Printf("Reloaded the TLS certificate from %v", r.certFile)
+	}
+	r.cert = &cert
+	r.modTime = modTime
+	return nil
+}
+
+// latestModTime returns the modification time of the file changed most recently
+func latestModTime(files ...string) (time.Time, error) {
+	var latest time.Time
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest, nil
+}
+
+// FileConfig returns a TLS config that serves the certificate in certFile and keyFile
+func FileConfig(certFile string, keyFile string) (*tls.Config, error) {
+	reloader, err := NewReloader(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{GetCertificate: reloader.GetCertificate, MinVersion: tls.VersionTLS12}, nil
+}
+
+// ACMEConfig returns a TLS config that serves a certificate for domain, requested from the ACME directory
+// Certificates are issued with the tls-alpn-01 challenge, so the server must be reachable on port 443 at the domain.
+// Issued certificates and the ACME account key are saved in cacheDir.
+// caFile is an optional PEM file of certificate authorities trusted when connecting to the directory (e.g. for a local test CA).
+func ACMEConfig(domain string, directoryURL string, cacheDir string, email string, caFile string) (*tls.Config, error) {
+	client := &acme.Client{DirectoryURL: directoryURL}
+
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read the ACME CA file: %v", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in the ACME CA file %v", caFile)
+		}
+		client.HTTPClient = &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		}
+	}
+
+	// check the directory can be reached before starting, instead of when the first certificate is requested
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if _, err := client.Discover(ctx); err != nil {
+		return nil, fmt.Errorf("could not reach the ACME directory %v: %v", directoryURL, err)
+	}
+
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(cacheDir),
+		HostPolicy: autocert.HostWhitelist(domain),
+		Email:      email,
+		Client:     client,
+	}
+
+	config := m.TLSConfig()
+	config.MinVersion = tls.VersionTLS12
+	return config, nil
+}
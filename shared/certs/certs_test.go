@@ -0,0 +1,71 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCert writes a self signed certificate for commonName, and returns it DER encoded
+func writeCert(t *testing.T, certFile string, keyFile string, commonName string) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+
+	assert.Nil(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.Nil(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return der
+}
+
+func TestReloaderReloadsChangedCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	_, err := NewReloader(certFile, keyFile)
+	assert.NotNil(t, err)
+
+	first := writeCert(t, certFile, keyFile, "first")
+	r, err := NewReloader(certFile, keyFile)
+	assert.Nil(t, err)
+
+	cert, err := r.GetCertificate(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, first, cert.Certificate[0])
+
+	// the renewed certificate is served once the files change
+	second := writeCert(t, certFile, keyFile, "second")
+	later := time.Now().Add(time.Minute)
+	assert.Nil(t, os.Chtimes(certFile, later, later))
+	cert, err = r.GetCertificate(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, second, cert.Certificate[0])
+
+	// an invalid certificate is not served
+	assert.Nil(t, ioutil.WriteFile(keyFile, []byte("partially written"), 0600))
+	later = later.Add(time.Minute)
+	assert.Nil(t, os.Chtimes(keyFile, later, later))
+	cert, err = r.GetCertificate(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, second, cert.Certificate[0])
+}
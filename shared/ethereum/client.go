@@ -0,0 +1,164 @@
+// Client is an eth client whose connection to the eth node can be replaced while it is in use
+// The contract sessions, the transaction manager and the event processor are bound to the Client once,
+// and every call goes to the current connection. When the connection is replaced, the previous connection
+// is closed once the calls in flight on it have returned.
+
+package ethereum
+
+import (
+	"context"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"math/big"
+	"sync"
+)
+
+type Client struct {
+	mu   sync.RWMutex
+	conn *conn
+}
+
+// conn is a connection to the eth node and the calls using it
+type conn struct {
+	client *ethclient.Client
+	calls  sync.WaitGroup
+}
+
+// NewClient returns a Client using the connection
+func NewClient(client *ethclient.Client) *Client {
+	return &Client{conn: &conn{client: client}}
+}
+
+// Replace swaps the connection for a new one
+// The previous connection is closed in the background once nothing is using it.
+func (c *Client) Replace(client *ethclient.Client) {
+	c.mu.Lock()
+	old := c.conn
+	c.conn = &conn{client: client}
+	c.mu.Unlock()
+
+	go func() {
+		old.calls.Wait()
+		old.client.Close()
+	}()
+}
+
+// acquire returns the current connection, which is not closed until release is called
+func (c *Client) acquire() *conn {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	c.conn.calls.Add(1)
+	return c.conn
+}
+
+// release .
+func (cn *conn) release() {
+	cn.calls.Done()
+}
+
+func (c *Client) BlockNumber(ctx context.Context) (uint64, error) {
+	cn := c.acquire()
+	defer cn.release()
+	return cn.client.BlockNumber(ctx)
+}
+
+func (c *Client) ChainID(ctx context.Context) (*big.Int, error) {
+	cn := c.acquire()
+	defer cn.release()
+	return cn.client.ChainID(ctx)
+}
+
+func (c *Client) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	cn := c.acquire()
+	defer cn.release()
+	return cn.client.HeaderByNumber(ctx, number)
+}
+
+func (c *Client) BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error) {
+	cn := c.acquire()
+	defer cn.release()
+	return cn.client.BalanceAt(ctx, account, blockNumber)
+}
+
+func (c *Client) CodeAt(ctx context.Context, account common.Address, blockNumber *big.Int) ([]byte, error) {
+	cn := c.acquire()
+	defer cn.release()
+	return cn.client.CodeAt(ctx, account, blockNumber)
+}
+
+func (c *Client) PendingCodeAt(ctx context.Context, account common.Address) ([]byte, error) {
+	cn := c.acquire()
+	defer cn.release()
+	return cn.client.PendingCodeAt(ctx, account)
+}
+
+func (c *Client) NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error) {
+	cn := c.acquire()
+	defer cn.release()
+	return cn.client.NonceAt(ctx, account, blockNumber)
+}
+
+func (c *Client) PendingNonceAt(ctx context.Context, account common.Address) (uint64, error) {
+	cn := c.acquire()
+	defer cn.release()
+	return cn.client.PendingNonceAt(ctx, account)
+}
+
+func (c *Client) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	cn := c.acquire()
+	defer cn.release()
+	return cn.client.CallContract(ctx, call, blockNumber)
+}
+
+func (c *Client) EstimateGas(ctx context.Context, call ethereum.CallMsg) (uint64, error) {
+	cn := c.acquire()
+	defer cn.release()
+	return cn.client.EstimateGas(ctx, call)
+}
+
+func (c *Client) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
+	cn := c.acquire()
+	defer cn.release()
+	return cn.client.SuggestGasPrice(ctx)
+}
+
+func (c *Client) SuggestGasTipCap(ctx context.Context) (*big.Int, error) {
+	cn := c.acquire()
+	defer cn.release()
+	return cn.client.SuggestGasTipCap(ctx)
+}
+
+func (c *Client) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	cn := c.acquire()
+	defer cn.release()
+	return cn.client.SendTransaction(ctx, tx)
+}
+
+func (c *Client) TransactionByHash(ctx context.Context, hash common.Hash) (*types.Transaction, bool, error) {
+	cn := c.acquire()
+	defer cn.release()
+	return cn.client.TransactionByHash(ctx, hash)
+}
+
+func (c *Client) TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error) {
+	cn := c.acquire()
+	defer cn.release()
+	return cn.client.TransactionReceipt(ctx, txHash)
+}
+
+func (c *Client) FilterLogs(ctx context.Context, query ethereum.FilterQuery) ([]types.Log, error) {
+	cn := c.acquire()
+	defer cn.release()
+	return cn.client.FilterLogs(ctx, query)
+}
+
+// SubscribeFilterLogs subscribes on the current connection
+// The subscription fails when the connection is replaced and closed, like any other dropped subscription.
+func (c *Client) SubscribeFilterLogs(ctx context.Context, query ethereum.FilterQuery, ch chan<- types.Log) (ethereum.Subscription, error) {
+	cn := c.acquire()
+	defer cn.release()
+	return cn.client.SubscribeFilterLogs(ctx, query, ch)
+}
@@ -0,0 +1,66 @@
+package ethereum
+
+import (
+	"context"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// fakeEthService answers eth_blockNumber, blocking until release is closed
+type fakeEthService struct {
+	block   uint64
+	called  chan struct{}
+	release chan struct{}
+}
+
+func (s *fakeEthService) BlockNumber(ctx context.Context) (hexutil.Uint64, error) {
+	if s.release != nil {
+		s.called <- struct{}{}
+		<-s.release
+	}
+	return hexutil.Uint64(s.block), nil
+}
+
+func dialFake(t *testing.T, service *fakeEthService) *ethclient.Client {
+	server := rpc.NewServer()
+	assert.Nil(t, server.RegisterName("eth", service))
+	t.Cleanup(server.Stop)
+
+	return ethclient.NewClient(rpc.DialInProc(server))
+}
+
+func TestClientReplace(t *testing.T) {
+	old := &fakeEthService{block: 1, called: make(chan struct{}), release: make(chan struct{})}
+	client := NewClient(dialFake(t, old))
+
+	type result struct {
+		block uint64
+		err   error
+	}
+	inFlight := make(chan result)
+	go func() {
+		block, err := client.BlockNumber(context.Background())
+		inFlight <- result{block, err}
+	}()
+	<-old.called
+
+	// new calls go to the new connection while the old one is still in use
+	client.Replace(dialFake(t, &fakeEthService{block: 2}))
+	block, err := client.BlockNumber(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), block)
+
+	// the call in flight on the old connection is not broken by the replace
+	close(old.release)
+	select {
+	case r := <-inFlight:
+		assert.Nil(t, r.err)
+		assert.Equal(t, uint64(1), r.block)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the call in flight did not return")
+	}
+}
@@ -3,15 +3,43 @@
 package ethereum
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/utility"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethclient"
-	"context"
 	"log"
+	"math/big"
 	"time"
 )
 
+const (
+	RECEIPT_POLL_INTERVAL = time.Second
+	DROPPED_TIMEOUT       = time.Minute // how long a transaction can be unknown to the eth node before it is considered dropped
+)
+
+var (
+	ErrReverted = errors.New("transaction reverted")
+	ErrDropped  = errors.New("transaction was dropped by the eth node")
+	ErrTimedOut = errors.New("timed out waiting for the transaction to be mined")
+)
+
+// ReceiptBackend is the part of the eth client used to wait for transactions
+type ReceiptBackend interface {
+	BlockNumber(ctx context.Context) (uint64, error)
+	TransactionByHash(ctx context.Context, hash common.Hash) (*types.Transaction, bool, error)
+	TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error)
+}
+
+// ChainIDBackend is the part of the eth client used to check which network it is on
+type ChainIDBackend interface {
+	ChainID(ctx context.Context) (*big.Int, error)
+}
+
 // InitEthClient connects to and returns eth client supplied in the config file
 func InitEthClient(ethNode string) (*ethclient.Client, error) {
 	cli, err := ethclient.Dial(ethNode)
@@ -24,45 +52,114 @@ func InitEthClient(ethNode string) (*ethclient.Client, error) {
 
 // SarcoAddress validates and returns the address of the Sarcophagus address
 // using the contract address supplied in the config file
-func SarcoAddress(contractAddress string, client *ethclient.Client) (common.Address, error) {
+func SarcoAddress(contractAddress string, client bind.ContractCaller) (common.Address, error) {
 	address := common.HexToAddress(contractAddress)
 	if isContract := utility.IsContract(address, client); !isContract {
-		return address, fmt.Errorf("Config value CONTRACT_ADDRESS is not a valid contract. Please check the value is correct")
+		return address, fmt.Errorf("Config value CONTRACT_ADDRESS is not a valid contract. Please check the value is correct and ETH_NODE is on the right network")
+	}
+
+	return address, nil
+}
+
+// TokenAddress validates and returns the address of the Sarcophagus token
+// using the token address supplied in the config file
+func TokenAddress(tokenAddress string, client bind.ContractCaller) (common.Address, error) {
+	address := common.HexToAddress(tokenAddress)
+	if isContract := utility.IsContract(address, client); !isContract {
+		return address, fmt.Errorf("Config value TOKEN_ADDRESS is not a valid contract. Please check the value is correct and ETH_NODE is on the right network")
 	}
 
 	return address, nil
 }
 
-// WaitMined waits for an ethereum transaction to be confirmed (or fail)
-// Returns an error if there was an error confirming the transaction
-func WaitMined(client *ethclient.Client, txHash common.Hash, label string) error {
-	var miningErr error = nil
-	retries := 4
+// ChainID returns the chain ID of the network the eth node is on, used to sign transactions (EIP-155)
+// If a chain ID is supplied in the config file, the eth node must be on that network.
+func ChainID(client ChainIDBackend, configChainID string) (*big.Int, error) {
+	chainID, err := client.ChainID(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("could not get the chain ID from the eth node: %v", err)
+	}
+
+	if configChainID == "" {
+		return chainID, nil
+	}
+
+	expected, ok := new(big.Int).SetString(configChainID, 10)
+	if !ok || expected.Sign() <= 0 {
+		return nil, fmt.Errorf("CHAIN_ID must be a positive whole number, got: %v", configChainID)
+	}
+
+	if expected.Cmp(chainID) != 0 {
+		return nil, fmt.Errorf("the eth node is on chain ID %v, but CHAIN_ID in the config file is %v. Please check ETH_NODE is on the right network", chainID, expected)
+	}
 
+	return chainID, nil
+}
+
+// WaitMined waits for an ethereum transaction to be mined with the given number of confirmations (or fail)
+// Returns ErrReverted if the transaction failed, ErrDropped if the eth node no longer knows about the transaction,
+// or ErrTimedOut if ctx is done before the transaction is confirmed.
+// If the block the transaction was mined in is removed by a chain reorg, it waits for the transaction to be mined again.
+func WaitMined(ctx context.Context, client ReceiptBackend, txHash common.Hash, label string, confirmations uint64) (*types.Receipt, error) {
 	log.Printf("Waiting for %s transaction to be mined", label)
 
+	ticker := time.NewTicker(RECEIPT_POLL_INTERVAL)
+	defer ticker.Stop()
+
+	var unknownSince time.Time
+
 	for {
-		_, pending, err := client.TransactionByHash(context.Background(), txHash)
-
-		if err != nil {
-			if retries > 0 {
-				log.Printf("%v transaction not found, retrying", label)
-				time.Sleep(3 * time.Second)
-				retries -= 1
-			} else {
-				miningErr = err
+		receipt, err := client.TransactionReceipt(ctx, txHash)
+
+		switch {
+		case err == nil:
+			unknownSince = time.Time{}
+
+			if receipt.Status != types.ReceiptStatusSuccessful {
+				return receipt, ErrReverted
+			}
+
+			head, err := client.BlockNumber(ctx)
+			if err != nil {
+				log.Printf("Error getting the current block number: %v", err)
 				break
 			}
-		}
 
-		fmt.Print(".")
-		time.Sleep(1 * time.Second)
+			if confirmations <= 1 || head+1 >= receipt.BlockNumber.Uint64()+confirmations {
+				return receipt, nil
+			}
 
-		if !pending {
-			fmt.Println("")
-			break
+		case err == ethereum.NotFound:
+			_, _, err := client.TransactionByHash(ctx, txHash)
+			if err == nil {
+				// still pending
+				unknownSince = time.Time{}
+				break
+			}
+			if err != ethereum.NotFound {
+				log.Printf("Error getting the %v transaction: %v", label, err)
+				break
+			}
+
+			if unknownSince.IsZero() {
+				unknownSince = time.Now()
+			} else if time.Since(unknownSince) > DROPPED_TIMEOUT {
+				return nil, ErrDropped
+			}
+
+		default:
+			if ctx.Err() == nil {
+				log.Printf("Error getting the receipt of the %v transaction: %v", label, err)
+			}
 		}
-	}
 
-	return miningErr
-}
\ No newline at end of file
+		select {
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				return nil, ErrTimedOut
+			}
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package ethereum
+
+import (
+	"context"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeReceiptBackend struct {
+	mu      sync.Mutex
+	head    uint64
+	receipt *types.Receipt
+	pending bool
+}
+
+func (b *fakeReceiptBackend) BlockNumber(ctx context.Context) (uint64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.head, nil
+}
+
+func (b *fakeReceiptBackend) TransactionByHash(ctx context.Context, hash common.Hash) (*types.Transaction, bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.pending {
+		return new(types.Transaction), true, nil
+	}
+	return nil, false, ethereum.NotFound
+}
+
+func (b *fakeReceiptBackend) TransactionReceipt(ctx context.Context, hash common.Hash) (*types.Receipt, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.receipt == nil {
+		return nil, ethereum.NotFound
+	}
+	return b.receipt, nil
+}
+
+func (b *fakeReceiptBackend) setHead(head uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.head = head
+}
+
+func TestWaitMinedWaitsForConfirmations(t *testing.T) {
+	backend := &fakeReceiptBackend{
+		head:    10,
+		receipt: &types.Receipt{Status: types.ReceiptStatusSuccessful, BlockNumber: big.NewInt(10)},
+	}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		backend.setHead(12)
+	}()
+
+	start := time.Now()
+	receipt, err := WaitMined(context.Background(), backend, common.Hash{1}, "Test", 3)
+	assert.Nil(t, err)
+	assert.Equal(t, backend.receipt, receipt)
+	assert.True(t, time.Since(start) >= RECEIPT_POLL_INTERVAL)
+}
+
+func TestWaitMinedErrors(t *testing.T) {
+	reverted := &fakeReceiptBackend{
+		head:    10,
+		receipt: &types.Receipt{Status: types.ReceiptStatusFailed, BlockNumber: big.NewInt(10)},
+	}
+	_, err := WaitMined(context.Background(), reverted, common.Hash{1}, "Test", 1)
+	assert.Equal(t, ErrReverted, err)
+
+	pending := &fakeReceiptBackend{pending: true}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = WaitMined(ctx, pending, common.Hash{1}, "Test", 1)
+	assert.Equal(t, ErrTimedOut, err)
+}
+
+type fakeChainIDBackend struct {
+	chainID *big.Int
+}
+
+func (b fakeChainIDBackend) ChainID(ctx context.Context) (*big.Int, error) {
+	return b.chainID, nil
+}
+
+func TestChainID(t *testing.T) {
+	backend := fakeChainIDBackend{chainID: big.NewInt(4)}
+
+	chainID, err := ChainID(backend, "")
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewInt(4), chainID)
+
+	chainID, err = ChainID(backend, "4")
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewInt(4), chainID)
+
+	_, err = ChainID(backend, "1")
+	assert.NotNil(t, err)
+
+	_, err = ChainID(backend, "mainnet")
+	assert.NotNil(t, err)
+}
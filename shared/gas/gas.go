@@ -0,0 +1,212 @@
+// Strategy decides the gas price of every transaction the archaeologist sends.
+// A fixed override is used as the gas price of a legacy transaction when it is set.
+// Otherwise the eth node's suggestion is used, increased by the multiplier and limited by the max price.
+// On chains with a base fee (EIP-1559), dynamic fee transactions are sent: the suggested priority fee (tip)
+// is multiplied, and the fee cap allows for the base fee to double before the transaction can no longer be mined.
+// A transaction that is stuck in the mempool can be replaced with bumped fees, the max price still applies.
+
+package gas
+
+import (
+	"context"
+	"fmt"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/shopspring/decimal"
+	"log"
+	"math/big"
+)
+
+// MIN_BUMP_PERCENT is the smallest fee increase the eth node accepts for a replacement transaction (geth's default price bump)
+const MIN_BUMP_PERCENT = 10
+
+// Backend is the part of the eth client used to price transactions
+type Backend interface {
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+	SuggestGasPrice(ctx context.Context) (*big.Int, error)
+	SuggestGasTipCap(ctx context.Context) (*big.Int, error)
+}
+
+// Fees are the gas prices of a transaction
+// Either GasPrice is set (legacy transaction), or GasFeeCap and GasTipCap are set (dynamic fee transaction).
+type Fees struct {
+	GasPrice  *big.Int
+	GasFeeCap *big.Int
+	GasTipCap *big.Int
+}
+
+// TxFees returns the fees of a signed transaction
+func TxFees(tx *types.Transaction) Fees {
+	if tx.Type() == types.DynamicFeeTxType {
+		return Fees{GasFeeCap: tx.GasFeeCap(), GasTipCap: tx.GasTipCap()}
+	}
+
+	return Fees{GasPrice: tx.GasPrice()}
+}
+
+// Apply sets the fees on the transact opts passed to a contract binding
+func (f Fees) Apply(opts *bind.TransactOpts) {
+	opts.GasPrice = f.GasPrice
+	opts.GasFeeCap = f.GasFeeCap
+	opts.GasTipCap = f.GasTipCap
+}
+
+// Price returns the most the transaction can pay per unit of gas
+func (f Fees) Price() *big.Int {
+	if f.GasPrice != nil {
+		return f.GasPrice
+	}
+
+	return f.GasFeeCap
+}
+
+// String .
+func (f Fees) String() string {
+	if f.GasPrice != nil {
+		return fmt.Sprintf("gas price %v gwei", toGwei(f.GasPrice))
+	}
+
+	return fmt.Sprintf("fee cap %v gwei, tip %v gwei", toGwei(f.GasFeeCap), toGwei(f.GasTipCap))
+}
+
+type Strategy struct {
+	Override   *big.Int        // fixed gas price in wei, nil to use the eth node's suggestion
+	Multiplier decimal.Decimal // applied to the suggested gas price or tip
+	MaxPrice   *big.Int        // cap on the gas price or fee cap in wei, nil for no cap
+	Legacy     bool            // send legacy transactions even if the chain has a base fee
+}
+
+// Fees returns the fees to use for a new transaction
+func (s *Strategy) Fees(ctx context.Context, backend Backend) (Fees, error) {
+	if s.Override != nil {
+		return Fees{GasPrice: new(big.Int).Set(s.Override)}, nil
+	}
+
+	if !s.Legacy {
+		head, err := backend.HeaderByNumber(ctx, nil)
+		if err != nil {
+			return Fees{}, fmt.Errorf("could not get the latest block header from the eth node: %v", err)
+		}
+
+		if head.BaseFee != nil {
+			return s.dynamicFees(ctx, backend, head.BaseFee)
+		}
+	}
+
+	return s.legacyFees(ctx, backend)
+}
+
+// legacyFees returns the gas price of a legacy transaction
+func (s *Strategy) legacyFees(ctx context.Context, backend Backend) (Fees, error) {
+	suggested, err := backend.SuggestGasPrice(ctx)
+	if err != nil {
+		return Fees{}, fmt.Errorf("could not get a gas price suggestion from the eth node: %v", err)
+	}
+
+	return Fees{GasPrice: s.limit(s.multiply(suggested))}, nil
+}
+
+// dynamicFees returns the fee cap and tip of a dynamic fee transaction
+func (s *Strategy) dynamicFees(ctx context.Context, backend Backend, baseFee *big.Int) (Fees, error) {
+	suggested, err := backend.SuggestGasTipCap(ctx)
+	if err != nil {
+		return Fees{}, fmt.Errorf("could not get a gas tip suggestion from the eth node: %v", err)
+	}
+
+	tip := s.multiply(suggested)
+	feeCap := new(big.Int).Add(tip, new(big.Int).Mul(baseFee, big.NewInt(2)))
+	feeCap = s.limit(feeCap)
+
+	if feeCap.Cmp(baseFee) < 0 {
+		log.Printf("Max gas price of %v gwei is below the current base fee of %v gwei. The transaction will not be mined until the base fee drops", toGwei(feeCap), toGwei(baseFee))
+	}
+
+	// The tip is paid on top of the base fee, but the total can never be more than the fee cap
+	if tip.Cmp(feeCap) > 0 {
+		tip = new(big.Int).Set(feeCap)
+	}
+
+	return Fees{GasFeeCap: feeCap, GasTipCap: tip}, nil
+}
+
+// Bump returns the fees to replace a pending transaction with, keeping its transaction type.
+// The fees are increased by percent, or raised to the current fees if those are higher.
+// Returns false if the max price does not leave room for the increase the eth node requires of a replacement.
+func (s *Strategy) Bump(ctx context.Context, backend Backend, current Fees, percent int64) (Fees, bool, error) {
+	if current.GasPrice != nil {
+		price := increase(current.GasPrice, percent)
+		if s.Override == nil {
+			fresh, err := s.legacyFees(ctx, backend)
+			if err != nil {
+				return Fees{}, false, err
+			}
+			price = max(price, fresh.GasPrice)
+		}
+
+		price = s.limit(price)
+		return Fees{GasPrice: price}, price.Cmp(increase(current.GasPrice, MIN_BUMP_PERCENT)) >= 0, nil
+	}
+
+	feeCap := increase(current.GasFeeCap, percent)
+	tip := increase(current.GasTipCap, percent)
+
+	head, err := backend.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return Fees{}, false, fmt.Errorf("could not get the latest block header from the eth node: %v", err)
+	}
+	if head.BaseFee != nil {
+		fresh, err := s.dynamicFees(ctx, backend, head.BaseFee)
+		if err != nil {
+			return Fees{}, false, err
+		}
+		feeCap = max(feeCap, fresh.GasFeeCap)
+		tip = max(tip, fresh.GasTipCap)
+	}
+
+	feeCap = s.limit(feeCap)
+	if tip.Cmp(feeCap) > 0 {
+		tip = new(big.Int).Set(feeCap)
+	}
+
+	ok := feeCap.Cmp(increase(current.GasFeeCap, MIN_BUMP_PERCENT)) >= 0 && tip.Cmp(increase(current.GasTipCap, MIN_BUMP_PERCENT)) >= 0
+	return Fees{GasFeeCap: feeCap, GasTipCap: tip}, ok, nil
+}
+
+// multiply applies the multiplier, rounding down to a whole wei
+func (s *Strategy) multiply(price *big.Int) *big.Int {
+	if s.Multiplier.IsZero() {
+		return new(big.Int).Set(price)
+	}
+
+	return decimal.NewFromBigInt(price, 0).Mul(s.Multiplier).BigInt()
+}
+
+// limit caps the price at the max price
+func (s *Strategy) limit(price *big.Int) *big.Int {
+	if s.MaxPrice == nil || price.Cmp(s.MaxPrice) <= 0 {
+		return price
+	}
+
+	log.Printf("Gas price of %v gwei is above the max gas price, using %v gwei", toGwei(price), toGwei(s.MaxPrice))
+	return new(big.Int).Set(s.MaxPrice)
+}
+
+// increase returns the price increased by percent, rounded up to a whole wei
+func increase(price *big.Int, percent int64) *big.Int {
+	increased := new(big.Int).Mul(price, big.NewInt(100+percent))
+	increased.Add(increased, big.NewInt(99))
+	return increased.Div(increased, big.NewInt(100))
+}
+
+// max .
+func max(a *big.Int, b *big.Int) *big.Int {
+	if a.Cmp(b) >= 0 {
+		return a
+	}
+	return new(big.Int).Set(b)
+}
+
+// toGwei .
+func toGwei(wei *big.Int) string {
+	return decimal.NewFromBigInt(wei, -9).String()
+}
@@ -0,0 +1,119 @@
+package gas
+
+import (
+	"context"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"testing"
+)
+
+type fakeBackend struct {
+	baseFee  *big.Int
+	gasPrice *big.Int
+	tip      *big.Int
+}
+
+func (b *fakeBackend) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	return &types.Header{BaseFee: b.baseFee}, nil
+}
+
+func (b *fakeBackend) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
+	return b.gasPrice, nil
+}
+
+func (b *fakeBackend) SuggestGasTipCap(ctx context.Context) (*big.Int, error) {
+	return b.tip, nil
+}
+
+func gwei(amount int64) *big.Int {
+	return new(big.Int).Mul(big.NewInt(amount), big.NewInt(1e9))
+}
+
+func TestOverrideIsUsedAsGasPrice(t *testing.T) {
+	backend := &fakeBackend{baseFee: gwei(50), gasPrice: gwei(60), tip: gwei(2)}
+	strategy := &Strategy{Override: gwei(80), MaxPrice: gwei(10)}
+
+	fees, err := strategy.Fees(context.Background(), backend)
+	assert.Nil(t, err)
+	assert.Equal(t, gwei(80), fees.GasPrice)
+	assert.Nil(t, fees.GasFeeCap)
+	assert.Nil(t, fees.GasTipCap)
+}
+
+func TestLegacyGasPrice(t *testing.T) {
+	backend := &fakeBackend{gasPrice: gwei(40)}
+	strategy := &Strategy{Multiplier: decimal.RequireFromString("1.5")}
+
+	fees, err := strategy.Fees(context.Background(), backend)
+	assert.Nil(t, err)
+	assert.Equal(t, gwei(60), fees.GasPrice)
+
+	strategy.MaxPrice = gwei(50)
+	fees, err = strategy.Fees(context.Background(), backend)
+	assert.Nil(t, err)
+	assert.Equal(t, gwei(50), fees.GasPrice)
+
+	// legacy transactions can be forced on chains with a base fee
+	backend.baseFee = gwei(30)
+	strategy.Legacy = true
+	fees, err = strategy.Fees(context.Background(), backend)
+	assert.Nil(t, err)
+	assert.Equal(t, gwei(50), fees.GasPrice)
+}
+
+func TestDynamicFees(t *testing.T) {
+	backend := &fakeBackend{baseFee: gwei(30), tip: gwei(2)}
+	strategy := &Strategy{Multiplier: decimal.RequireFromString("2.0")}
+
+	fees, err := strategy.Fees(context.Background(), backend)
+	assert.Nil(t, err)
+	assert.Nil(t, fees.GasPrice)
+	assert.Equal(t, gwei(4), fees.GasTipCap)
+	assert.Equal(t, gwei(64), fees.GasFeeCap)
+
+	// the fee cap is limited by the max price, and the tip by the fee cap
+	strategy.MaxPrice = gwei(40)
+	fees, err = strategy.Fees(context.Background(), backend)
+	assert.Nil(t, err)
+	assert.Equal(t, gwei(4), fees.GasTipCap)
+	assert.Equal(t, gwei(40), fees.GasFeeCap)
+
+	strategy.MaxPrice = gwei(3)
+	fees, err = strategy.Fees(context.Background(), backend)
+	assert.Nil(t, err)
+	assert.Equal(t, gwei(3), fees.GasTipCap)
+	assert.Equal(t, gwei(3), fees.GasFeeCap)
+}
+
+func TestBump(t *testing.T) {
+	backend := &fakeBackend{baseFee: gwei(30), gasPrice: gwei(40), tip: gwei(2)}
+	strategy := &Strategy{}
+
+	// legacy transactions are bumped by the percent, or raised to the current gas price
+	fees, ok, err := strategy.Bump(context.Background(), backend, Fees{GasPrice: gwei(20)}, 20)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, gwei(40), fees.GasPrice)
+
+	fees, ok, err = strategy.Bump(context.Background(), backend, Fees{GasPrice: gwei(100)}, 20)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, gwei(120), fees.GasPrice)
+
+	// dynamic fee transactions bump both the fee cap and the tip
+	fees, ok, err = strategy.Bump(context.Background(), backend, Fees{GasFeeCap: gwei(100), GasTipCap: gwei(1)}, 20)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Nil(t, fees.GasPrice)
+	assert.Equal(t, gwei(120), fees.GasFeeCap)
+	assert.Equal(t, gwei(2), fees.GasTipCap)
+
+	// the max price leaves no room for a replacement
+	strategy.MaxPrice = gwei(105)
+	fees, ok, err = strategy.Bump(context.Background(), backend, Fees{GasFeeCap: gwei(100), GasTipCap: gwei(1)}, 20)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, gwei(105), fees.GasFeeCap)
+}
@@ -52,6 +52,7 @@ func PublicKeyBytesFromIndex(wallet *hdwallet.Wallet, index int) []byte {
 }
 
 // PrivateKeyFromIndex -- given an index of an account on the hd wallet, return the corresponding private key
+// The hdwallet library returns keys on the btcec curve, which ecies cannot decrypt with, so the key is converted to the go-ethereum curve
 func PrivateKeyFromIndex(wallet *hdwallet.Wallet, index int) *ecdsa.PrivateKey {
 	account := AccountFromIndex(wallet, index)
 	privateKey, err := wallet.PrivateKey(account)
@@ -59,5 +60,10 @@ func PrivateKeyFromIndex(wallet *hdwallet.Wallet, index int) *ecdsa.PrivateKey {
 		log.Fatalf("There was an error getting private key from account index: %d, %v", index, err)
 	}
 
+	privateKey, err = crypto.ToECDSA(crypto.FromECDSA(privateKey))
+	if err != nil {
+		log.Fatalf("There was an error converting the private key from account index: %d, %v", index, err)
+	}
+
 	return privateKey
 }
\ No newline at end of file
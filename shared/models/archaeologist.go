@@ -10,11 +10,11 @@ package models
 import (
 	"context"
 	"crypto/ecdsa"
-	"encoding/base64"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/Dev43/arweave-go"
-	"github.com/Dev43/arweave-go/api"
 	"github.com/Dev43/arweave-go/transactor"
 	"github.com/Dev43/arweave-go/tx"
 	"github.com/Dev43/arweave-go/wallet"
@@ -22,10 +22,15 @@ import (
 	ar "github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/arweave"
 	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/ethereum"
 	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/hdw"
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/price"
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/scheduler"
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/store"
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/txmanager"
 	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/utility"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
-	"github.com/ethereum/go-ethereum/ethclient"
 	hdwallet "github.com/miguelmota/go-ethereum-hdwallet"
 	"github.com/shopspring/decimal"
 	"log"
@@ -34,16 +39,24 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"sync"
 	"time"
 )
 
 type Archaeologist struct {
-	Client                    *ethclient.Client
+	Client                    *ethereum.Client // redialed by the event listener when the connection drops
+	EthNode                   string
+	ChainID                   *big.Int
+	Subscriptions             *SubscriptionStatus
+	EventConfirmations        uint64
+	EventSource               string
+	EventPollInterval         time.Duration
+	ArweaveNode               string
 	ArweaveWallet             *wallet.Wallet
 	ArweaveTransactor         *transactor.Transactor
 	ArweaveMultiplier		  decimal.Decimal
 	PrivateKey                *ecdsa.PrivateKey
-	CurrentPublicKeyBytes     []byte
 	CurrentPrivateKey         *ecdsa.PrivateKey
 	ArchAddress               common.Address
 	PaymentAddress            common.Address
@@ -58,19 +71,58 @@ type Archaeologist struct {
 	MaxResurectionTime        *big.Int
 	Endpoint                  string
 	FilePort                  string
+	ListenAddress             string
+	TLSConfig                 *tls.Config // nil if the file server does not serve https itself
+	RateLimiter               *utility.IPRateLimiter
+	AllowedOrigins            []string
 	Mnemonic                  string
 	Wallet                    *hdwallet.Wallet
-	AccountIndex              int
 	Server                    *http.Server
-	Sarcophaguses             map[[32]byte]*Sarco
-	FileHandlers              map[[32]byte]*big.Int
+	Store                     *store.Store
+	PayloadDir                string
+	Registry                  *SarcoRegistry
+	UnwrapScheduler           *scheduler.Scheduler
+	TxManager                 *txmanager.Manager
+	SweepInterval             time.Duration // 0 if the sweeper is disabled
+	SarcoEthPrice             decimal.Decimal
+	SweepMinProfit            *big.Int
+	MaxFileSize               int64 // in bytes
+	uploadLocks               uploadLocks
+	uploadJobs                uploadJobs
+	arweaveMu                 sync.Mutex
+	arweaveReserved           big.Int   // guarded by arweaveMu, the cost of the uploads in progress
+	arweaveAlertedAt          time.Time // guarded by arweaveMu
+	SarcoArPrice              price.Source // nil if storage fees are not compared with the arweave cost
+	LossTolerance             decimal.Decimal // percent of the arweave cost the storage fee can fall short by
 }
 
-// MB used for validating file size
+// The arweave wallet must hold the reward for uploading a file plus this margin, in case the reward goes up before it is sent
+const (
+	ARWEAVE_BALANCE_MARGIN_PERCENT = 10
+	ARWEAVE_BALANCE_ALERT_INTERVAL = time.Hour
+	ARWEAVE_RETRY_AFTER            = 300 // seconds the embalmer is asked to wait before sending the file again
+)
+
+var (
+	ErrArweaveBalanceTooLow = errors.New("arweave balance is too low")
+	ErrStorageFeeTooLow     = errors.New("storage fee does not cover the arweave cost")
+)
+
+// MB used for the default max file size
 const (
 	MB = 1 << 20
 )
 
+// Timeouts of the file server
+// Reading the request allows for a large file on a slow connection, and writing the response allows for a slow upload to arweave
+const (
+	SERVER_READ_HEADER_TIMEOUT = 10 * time.Second
+	SERVER_READ_TIMEOUT        = 5 * time.Minute
+	SERVER_WRITE_TIMEOUT       = 10 * time.Minute
+	SERVER_IDLE_TIMEOUT        = 2 * time.Minute
+	SERVER_MAX_HEADER_BYTES    = 1 << 16
+)
+
 // SarcoBalance returns archaeologists Sarco Balance at the address
 // derived from the config value: eth_private_key
 func (arch *Archaeologist) SarcoBalance() *big.Int {
@@ -105,10 +157,9 @@ func (arch *Archaeologist) ApproveFreeBondTransfer() {
 		log.Fatalf("Your balance is too low to cover the free bond transfer. \n Balance Needed: %v \n Current Balance: %v", arch.FreeBond, archSarcoBalance)
 	}
 
-	txn, err := arch.TokenSession.Approve(
-		arch.SarcoAddress,
-		arch.FreeBond,
-	)
+	txn, err := arch.TxManager.Submit("Approval of Free Bond", func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return arch.TokenSession.Contract.Approve(opts, arch.SarcoAddress, arch.FreeBond)
+	})
 
 	if err != nil {
 		log.Fatalf("Transaction reverted. Error Approving Transaction: %v \n Config value ADD_TO_FREE_BOND has been reset to 0. You will need to reset this.", err)
@@ -117,10 +168,10 @@ func (arch *Archaeologist) ApproveFreeBondTransfer() {
 	log.Printf("Approval Transaction for %v Sarco Tokens Submitted. Transaction ID: %v", utility.ToDecimal(arch.FreeBond, 18), txn.Hash().Hex())
 	log.Printf("Gas Used for Approval: %v", txn.Gas())
 
-	err = ethereum.WaitMined(arch.Client, txn.Hash(), "Approval of Free Bond")
+	_, err = arch.TxManager.Wait(context.Background(), txn.Hash())
 
 	if err != nil {
-		log.Fatalf("There was an error mining the approval of free bond transaction: %v", err)
+		log.Fatalf("There was an error mining the approval of free bond transaction: %v. %v", err, txErrorHint(err))
 	}
 
 	// 5 second buffer added to avoid issue with post-approval tx failing
@@ -129,7 +180,9 @@ func (arch *Archaeologist) ApproveFreeBondTransfer() {
 
 // WithdrawBond withdraws Sarco tokens from the archaeologist's Free Bond balance
 func (arch *Archaeologist) WithdrawBond(bondToWithdraw *big.Int) {
-	txn, err := arch.SarcoSession.WithdrawBond(bondToWithdraw)
+	txn, err := arch.TxManager.Submit("Withdraw Bond", func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return arch.SarcoSession.Contract.WithdrawBond(opts, bondToWithdraw)
+	})
 
 	if err != nil {
 		log.Fatalf("Transaction reverted. Error Withdrawing Bond: %v \n Config value REMOVE_FROM_FREE_BOND has been reset to 0. You will need to reset this.", err)
@@ -138,10 +191,10 @@ func (arch *Archaeologist) WithdrawBond(bondToWithdraw *big.Int) {
 	log.Printf("Withdrawal of %v Sarco Tokens transaction submitted. Transaction ID: %v", bondToWithdraw, txn.Hash().Hex())
 	log.Printf("Gas Used for Withdrawal: %v", txn.Gas())
 
-	err = ethereum.WaitMined(arch.Client, txn.Hash(), "Withdraw Bond")
+	_, err = arch.TxManager.Wait(context.Background(), txn.Hash())
 
 	if err != nil {
-		log.Fatalf("There was an error mining the withdraw bond transaction: %v", err)
+		log.Fatalf("There was an error mining the withdraw bond transaction: %v. %v", err, txErrorHint(err))
 	} else {
 		log.Printf("Withdrawal of Sarco Tokens transaction successful")
 	}
@@ -151,16 +204,19 @@ func (arch *Archaeologist) WithdrawBond(bondToWithdraw *big.Int) {
 // If the free bond value is > 0 then the arch can accept new jobs
 func (arch *Archaeologist) RegisterArchaeologist() {
 	log.Println("***REGISTERING ARCHAEOLOGIST***")
-	txn, err := arch.SarcoSession.RegisterArchaeologist(
-		arch.CurrentPublicKeyBytes,
-		arch.Endpoint,
-		arch.PaymentAddress,
-		arch.FeePerByte,
-		arch.MinBounty,
-		arch.MinDiggingFee,
-		arch.MaxResurectionTime,
-		arch.FreeBond,
-	)
+	txn, err := arch.TxManager.Submit("Register Archaeologist", func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return arch.SarcoSession.Contract.RegisterArchaeologist(
+			opts,
+			arch.Registry.CurrentPublicKeyBytes(),
+			arch.Endpoint,
+			arch.PaymentAddress,
+			arch.FeePerByte,
+			arch.MinBounty,
+			arch.MinDiggingFee,
+			arch.MaxResurectionTime,
+			arch.FreeBond,
+		)
+	})
 
 	if err != nil {
 		log.Fatalf("Transaction reverted. Error registering Archaeologist: %v Config values ADD_TO_FREE_BOND and REMOVE_FROM_FREE_BOND have been reset to 0. You will need to reset this.", err)
@@ -170,10 +226,10 @@ func (arch *Archaeologist) RegisterArchaeologist() {
 	log.Printf("Register Archaeologist Transaction Submitted. Transaction ID: %s", txn.Hash().Hex())
 	log.Printf("Gas Used: %v", txn.Gas())
 
-	err = ethereum.WaitMined(arch.Client, txn.Hash(), "Registering Archaeologist")
+	_, err = arch.TxManager.Wait(context.Background(), txn.Hash())
 
 	if err != nil {
-		log.Fatalf("There was an error mining the register archaeologist transaction: %v", err)
+		log.Fatalf("There was an error mining the register archaeologist transaction: %v. %v", err, txErrorHint(err))
 	} else {
 		log.Printf("Register Archaeologist Transaction Successful")
 	}
@@ -182,16 +238,19 @@ func (arch *Archaeologist) RegisterArchaeologist() {
 // UpdateArchaeologist .
 func (arch *Archaeologist) UpdateArchaeologist() {
 	log.Println("***UPDATING ARCHAEOLOGIST***")
-	txn, err := arch.SarcoSession.UpdateArchaeologist(
-		arch.Endpoint,
-		arch.CurrentPublicKeyBytes,
-		arch.ArchAddress,
-		arch.FeePerByte,
-		arch.MinBounty,
-		arch.MinDiggingFee,
-		arch.MaxResurectionTime,
-		arch.FreeBond,
-	)
+	txn, err := arch.TxManager.Submit("Update Archaeologist", func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return arch.SarcoSession.Contract.UpdateArchaeologist(
+			opts,
+			arch.Endpoint,
+			arch.Registry.CurrentPublicKeyBytes(),
+			arch.ArchAddress,
+			arch.FeePerByte,
+			arch.MinBounty,
+			arch.MinDiggingFee,
+			arch.MaxResurectionTime,
+			arch.FreeBond,
+		)
+	})
 
 	if err != nil {
 		log.Fatalf("Transaction reverted. Error updating Archaeologist: %v Config values ADD_TO_FREE_BOND and REMOVE_FROM_FREE_BOND have been reset to 0. You will need to reset these.", err)
@@ -201,15 +260,29 @@ func (arch *Archaeologist) UpdateArchaeologist() {
 	log.Printf("Update Archaeologist Transaction Submitted. Transaction ID: %s", txn.Hash().Hex())
 	log.Printf("Gas Used: %v", txn.Gas())
 
-	err = ethereum.WaitMined(arch.Client, txn.Hash(), "Update Archaeologist")
+	_, err = arch.TxManager.Wait(context.Background(), txn.Hash())
 
 	if err != nil {
-		log.Fatalf("There was an error mining the update archaeologist transaction: %v", err)
+		log.Fatalf("There was an error mining the update archaeologist transaction: %v. %v", err, txErrorHint(err))
 	} else {
 		log.Printf("Update Archaeologist Transaction Successful")
 	}
 }
 
+// txErrorHint explains what happened to a transaction that was not successful
+func txErrorHint(err error) string {
+	switch {
+	case errors.Is(err, ethereum.ErrReverted):
+		return "The transaction was mined but reverted, please check your config values."
+	case errors.Is(err, ethereum.ErrDropped):
+		return "The transaction was dropped by the eth node, please restart the service to send it again."
+	case errors.Is(err, ethereum.ErrTimedOut):
+		return "The transaction may still be mined. It is tracked and will be resubmitted if needed when the service restarts."
+	}
+
+	return ""
+}
+
 // CreateArweaveTransaction
 // Emulates CreateTransaction in the arweave-go library's tx package
 // There is 1 difference: The arweave_multiplier set in config can increase the estimated fee to increase chances of successfully confirmed tx
@@ -226,12 +299,12 @@ func (arch *Archaeologist) CreateArweaveTransaction(ctx context.Context, w arwea
 	}
 
 	// Apply fee multiplier
-	priceDecimal, err := decimal.NewFromString(price)
+	priceDecimal, err := multiplyArweaveReward(price, arch.ArweaveMultiplier)
 	if err != nil {
 		return nil, err
 	}
 
-	priceMultiplied := priceDecimal.Mul(arch.ArweaveMultiplier).Round(0).String()
+	priceMultiplied := priceDecimal.String()
 
 	// Non encoded transaction fields
 	txn := tx.NewTransaction(
@@ -284,9 +357,8 @@ func (arch *Archaeologist) UploadFileToArweave(fileBytes []byte) (*tx.Transactio
 // fileHandlerCheck resets the file handlers if there is only 1 file handler open
 // called either when an error has occurred in the file upload process or a sarcophagus is removed from state
 func (arch *Archaeologist) fileHandlerCheck() {
-	fileHandlerLen := len(arch.FileHandlers)
-	if fileHandlerLen <= 1 {
-		arch.FileHandlers = map[[32]byte]*big.Int{}
+	if arch.Registry.ClearLastFileHandler() {
+		arch.PersistState()
 	}
 }
 
@@ -299,92 +371,209 @@ func (arch *Archaeologist) fileUploadError(logMsg string, httpErrMsg string, htt
 	arch.fileHandlerCheck()
 }
 
-// validateArweaveBalance returns false if user's balance is not enough to cover
-// not currently being used
-// TODO: Add arweave_multiplier to the validated amount
-func (arch *Archaeologist) validateArweaveBalance(fileBytes []byte) bool {
-	txFeeInt := new(big.Int)
-	balanceInt := new(big.Int)
-	txFee, _ := arch.ArweaveTransactor.Client.GetReward(context.Background(), fileBytes)
-	balance := ar.ArweaveBalance(arch.ArweaveTransactor.Client.(*api.Client), arch.ArweaveWallet)
-	txFeeInt, _ = txFeeInt.SetString(txFee, 10)
-	balanceInt, _ = balanceInt.SetString(balance, 10)
+// arweaveCost returns the reward for uploading a file of the size to arweave with the arweave_multiplier applied, in winston
+func (arch *Archaeologist) arweaveCost(bytes int64) (decimal.Decimal, error) {
+	reward, err := ar.ArweaveReward(arch.ArweaveNode, bytes)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("could not get the arweave reward: %v", err)
+	}
+
+	return multiplyArweaveReward(reward, arch.ArweaveMultiplier)
+}
+
+// reserveArweaveBalance returns ErrArweaveBalanceTooLow if the arweave wallet cannot pay the cost of uploading the file,
+// with ARWEAVE_BALANCE_MARGIN_PERCENT on top, as well as the uploads in progress
+// Otherwise the cost is reserved until the returned function is called, once the file has been uploaded or given up on.
+// The operator is alerted (at most every ARWEAVE_BALANCE_ALERT_INTERVAL) when the balance is too low.
+func (arch *Archaeologist) reserveArweaveBalance(fileSize int64, cost decimal.Decimal) (func(), error) {
+	balanceInt, err := arch.arweaveBalance()
+	if err != nil {
+		return nil, err
+	}
+
+	required := requiredArweaveBalance(cost)
+
+	arch.arweaveMu.Lock()
+	defer arch.arweaveMu.Unlock()
+
+	available := new(big.Int).Sub(balanceInt, &arch.arweaveReserved)
+	if available.Cmp(required) >= 0 {
+		arch.arweaveReserved.Add(&arch.arweaveReserved, required)
+		return func() {
+			arch.arweaveMu.Lock()
+			defer arch.arweaveMu.Unlock()
+			arch.arweaveReserved.Sub(&arch.arweaveReserved, required)
+		}, nil
+	}
+
+	if time.Since(arch.arweaveAlertedAt) >= ARWEAVE_BALANCE_ALERT_INTERVAL {
+		arch.arweaveAlertedAt = time.Now()
+		utility.Alert("Arweave balance is too low to accept a file of %v bytes. Balance: %v AR, reserved for uploads in progress: %v AR, needed: %v AR. Please add AR to %v", fileSize, utility.ToDecimal(balanceInt, 12), utility.ToDecimal(&arch.arweaveReserved, 12), utility.ToDecimal(required, 12), arch.ArweaveWallet.Address())
+	}
+
+	return nil, ErrArweaveBalanceTooLow
+}
+
+// arweaveBalance returns the balance of the arweave wallet in winston
+func (arch *Archaeologist) arweaveBalance() (*big.Int, error) {
+	client, ok := arch.ArweaveTransactor.Client.(ar.BalanceClient)
+	if !ok {
+		return nil, fmt.Errorf("the arweave client cannot get balances")
+	}
+	balance, err := ar.ArweaveBalance(client, arch.ArweaveWallet)
+	if err != nil {
+		return nil, err
+	}
+
+	balanceInt, ok := new(big.Int).SetString(balance, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid arweave balance: %v", balance)
+	}
+
+	return balanceInt, nil
+}
+
+// requiredArweaveBalance returns the cost with ARWEAVE_BALANCE_MARGIN_PERCENT applied, in winston
+func requiredArweaveBalance(cost decimal.Decimal) *big.Int {
+	margin := decimal.NewFromInt(100 + ARWEAVE_BALANCE_MARGIN_PERCENT).Div(decimal.NewFromInt(100))
+	return cost.Mul(margin).Ceil().BigInt()
+}
+
+// validateStorageFeeCoversCost returns ErrStorageFeeTooLow if the storage fee is worth less than the cost of uploading the file,
+// by more than the loss tolerance. The margin is logged when the SARCO price is known.
+func (arch *Archaeologist) validateStorageFeeCoversCost(storageFee *big.Int, cost decimal.Decimal) error {
+	if arch.SarcoArPrice == nil {
+		return nil
+	}
+
+	sarcoArPrice, err := arch.SarcoArPrice.Price()
+	if err != nil {
+		return err
+	}
+
+	value := storageFeeValue(storageFee, sarcoArPrice)
+	margin := storageFeeMargin(value, cost)
+	log.Printf("Storage fee is worth %v AR, the upload costs %v AR. Margin: %v%%", value.Shift(-12), cost.Shift(-12), margin.StringFixed(2))
+
+	if margin.Add(arch.LossTolerance).IsNegative() {
+		return ErrStorageFeeTooLow
+	}
+
+	return nil
+}
+
+// storageFeeValue returns the value of the storage fee (in SARCO wei) in winston
+func storageFeeValue(storageFee *big.Int, sarcoArPrice decimal.Decimal) decimal.Decimal {
+	return decimal.NewFromBigInt(storageFee, -18).Mul(sarcoArPrice).Shift(12)
+}
 
-	return balanceInt.Cmp(txFeeInt) != -1
+// storageFeeMargin returns the profit (or loss, if negative) from the storage fee as a percent of the cost
+func storageFeeMargin(value decimal.Decimal, cost decimal.Decimal) decimal.Decimal {
+	if !cost.IsPositive() {
+		return decimal.NewFromInt(100)
+	}
+
+	return value.Sub(cost).Div(cost).Mul(decimal.NewFromInt(100))
+}
+
+// multiplyArweaveReward applies the arweave_multiplier to the reward estimated by the arweave node
+func multiplyArweaveReward(reward string, multiplier decimal.Decimal) (decimal.Decimal, error) {
+	rewardDecimal, err := decimal.NewFromString(reward)
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	return rewardDecimal.Mul(multiplier).Round(0), nil
 }
 
 func (arch *Archaeologist) pingHandler(w http.ResponseWriter, r *http.Request) {
-	(w).Header().Set("Access-Control-Allow-Origin", "*")
 	fmt.Fprintf(w, "true")
 }
 
 // fileUploadHandler validates the file sent by the embalmer for:
-// 1. Size (< 3MB)
+// 1. Size (<= MaxFileSize)
 // 2. Can be Decrypted using private key a the current account index from the hd wallet
 // 3. Storage Fee sent by embalmer is adequate
 func (arch *Archaeologist) fileUploadHandler(w http.ResponseWriter, r *http.Request) {
-	(w).Header().Set("Access-Control-Allow-Origin", "*")
-
 	log.Print("Receiving File...")
 
-	fileHandlerLen := len(arch.FileHandlers)
-	if fileHandlerLen < 1 {
+	// a file that was already uploaded can be sent again after its file handler has been cleared
+	fileHandlerLen := arch.Registry.FileHandlerCount()
+	if fileHandlerLen < 1 && !arch.HasSavedResponses() {
 		log.Print("Not expecting a file, but received request")
 		http.Error(w, "We are not expecting a file", 406)
 		return
 	}
 
-	if r.Method != "POST" && r.Method != "OPTIONS" {
+	if r.Method != "POST" {
 		arch.fileUploadError("File handler received non-post method, exiting.", "Method not allowed", http.StatusMethodNotAllowed, w)
 		return
 	}
 
-	// Double Encrypted file bytes are sent as encoded json
-	// Decode the file bytes into a struct
-	var sarcoFile SarcoFile
-
-	if r.Body == nil {
-		http.Error(w, "Please send a request body", 400)
+	// Double Encrypted file bytes are sent as encoded json, or streamed as raw bytes or a multipart form
+	file, err := arch.readUpload(w, r)
+	if err == ErrFileTooLarge {
+		errMsg := fmt.Sprintf("The file sent is larger than the limit of %v bytes.", arch.MaxFileSize)
+		arch.fileUploadError(errMsg, errMsg, http.StatusRequestEntityTooLarge, w)
 		return
 	}
 
-	err := json.NewDecoder(r.Body).Decode(&sarcoFile)
-	if err != nil {
-		log.Printf("error decoding: %v", err)
-		http.Error(w, err.Error(), 400)
+	var invalid *uploadError
+	if errors.As(err, &invalid) {
+		log.Printf("error reading upload: %v", err)
+		http.Error(w, invalid.Error(), 400)
 		return
 	}
 
-	fileBytes, err := base64.StdEncoding.DecodeString(sarcoFile.FileBytes)
 	if err != nil {
-		log.Printf("error decoding file: %v", err)
-	}
-
-	fileByteLen := len(fileBytes)
-
-	// validate Size
-	if fileByteLen > (3 * MB) {
-		arch.fileUploadError("File was too large to receive. Size:"+string(fileByteLen), "The file sent is larger than the limit of 3MB.", http.StatusBadRequest, w)
+		arch.fileUploadError("Error receiving file: "+err.Error(), "There was an error receiving the file.", http.StatusInternalServerError, w)
 		return
 	}
 
-	// validate outer layer of file encryption can be decrypted
+	defer file.remove()
+	log.Printf("Received file of %v bytes", file.size)
+
+	// validate outer layer of file encryption can be decrypted, and calculate the sarcophagus identifier from the decrypted file
 	log.Print("Decrypting file...")
-	currentPrivateKey := hdw.PrivateKeyFromIndex(arch.Wallet, arch.AccountIndex)
-	decryptedFileBytes, err := utility.DecryptFile(fileBytes, currentPrivateKey)
+	accountIndex := arch.Registry.AccountIndex()
+	currentPrivateKey := hdw.PrivateKeyFromIndex(arch.Wallet, accountIndex)
+	assetDoubleHash, err := file.doubleHash(currentPrivateKey)
 
 	if err != nil {
 		arch.fileUploadError("Error decrypting file:"+err.Error(), "The file cannot be decrypted by archaeologist. Confirm it was encrypted with the correct public key.", http.StatusBadRequest, w)
 		return
 	}
-
-	// calculate the sarcophagus identifier from the file bytes
-	assetDoubleHash := utility.FileBytesToDoubleHashBytes(decryptedFileBytes)
 	log.Printf("asset double hash: %v", assetDoubleHash)
 
+	// the file is already being uploaded in the background
+	if job, ok := arch.uploadJobs.get(assetDoubleHash); ok && isAsync(r) && job.Status != UPLOAD_FAILED {
+		log.Printf("File is already being uploaded to arweave: %v", job.Id)
+		writeUploadJob(w, job, http.StatusAccepted)
+		return
+	}
+
+	// one upload of the file is handled at a time, so a retry sent while the first upload is in progress waits for its response
+	// files for other sarcophagi are uploaded at the same time
+	unlock := arch.uploadLocks.lock(assetDoubleHash)
+	defer unlock()
+
+	// the file has already been uploaded to arweave, respond with the same values as before
+	if response, ok := arch.SavedResponse(assetDoubleHash); ok {
+		log.Printf("File was already uploaded to arweave, sending the saved response to embalmer: %v", response)
+		if isAsync(r) {
+			job, _ := arch.uploadStatus(assetDoubleHash)
+			writeUploadJob(w, job, http.StatusOK)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
 	// validate the sarcophagus identifier matches a sarcophagus identifier the archaeologist is expecting to receive a file for
 	// this would be an edge case where the embalmer sends a correctly encrypted file for the wrong sarcophagus
-	storageFee, ok := arch.FileHandlers[assetDoubleHash]
+	storageFee, ok := arch.Registry.StorageFee(assetDoubleHash)
 	if !ok {
 		errMsg := "The double hash of the file does not match any open double hashes."
 		arch.fileUploadError(errMsg, errMsg, http.StatusBadRequest, w)
@@ -392,44 +581,102 @@ func (arch *Archaeologist) fileUploadHandler(w http.ResponseWriter, r *http.Requ
 	}
 
 	// validate storage fee is sufficient
-	storageExpectation := new(big.Int).Mul(big.NewInt(int64(fileByteLen)), arch.FeePerByte)
+	storageExpectation := new(big.Int).Mul(big.NewInt(file.size), arch.FeePerByte)
 	if storageExpectation.Cmp(storageFee) == 1 {
 		errMsg := fmt.Sprintf("The storage fee is not enough. Expected storage fee of at least: %v, storage fee was: %v", utility.ToDecimal(storageExpectation, 18), utility.ToDecimal(storageFee, 18))
 		arch.fileUploadError(errMsg, errMsg, http.StatusBadRequest, w)
 		return
 	}
 
+	// the storage fee is worth at least the cost of the upload, within the loss tolerance
+	cost, err := arch.arweaveCost(file.size)
+	if err == nil {
+		err = arch.validateStorageFeeCoversCost(storageFee, cost)
+	}
+	if err == ErrStorageFeeTooLow {
+		errMsg := "The storage fee does not cover the cost of storing the file on arweave at current prices."
+		arch.fileUploadError(errMsg, errMsg, http.StatusBadRequest, w)
+		return
+	}
+
+	// the arweave wallet can pay for the upload, on top of the uploads in progress
+	// the file handler is kept, so the embalmer can send the file again once the wallet has been topped up
+	var release func()
+	if err == nil {
+		release, err = arch.reserveArweaveBalance(file.size, cost)
+	}
+	if err != nil {
+		log.Printf("Error uploading file: could not accept the file, %v", err)
+		w.Header().Set("Retry-After", strconv.Itoa(ARWEAVE_RETRY_AFTER))
+		if err == ErrArweaveBalanceTooLow {
+			http.Error(w, "The archaeologist cannot pay to store the file on arweave right now. Please try again later.", http.StatusServiceUnavailable)
+		} else {
+			http.Error(w, "The archaeologist cannot store files on arweave right now. Please try again later.", http.StatusServiceUnavailable)
+		}
+		return
+	}
+
 	// all validations have passed
 	log.Printf("File was validated successfully")
 
-	// create arweave tx
-	arweaveTx, err := arch.UploadFileToArweave(fileBytes)
+	if isAsync(r) {
+		writeUploadJob(w, arch.startUploadJob(file, release, assetDoubleHash, accountIndex), http.StatusAccepted)
+		return
+	}
+
+	defer release()
+	response, err := arch.uploadFile(file, assetDoubleHash, accountIndex)
 	if err != nil {
 		errMsg := fmt.Sprintf("There was an error with the file. Error: %v", err)
 		arch.fileUploadError(errMsg, errMsg, http.StatusBadRequest, w)
 		return
 	}
 
+	log.Printf("Sending Response to Embalmer: %v", response)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+	arch.fileHandlerCheck()
+}
+
+// uploadFile uploads the validated file to arweave, and saves the response to embalmer
+// accountIndex is the index of the key pair the file was encrypted with
+func (arch *Archaeologist) uploadFile(file *uploadedFile, assetDoubleHash [32]byte, accountIndex int) (ResponseToEmbalmer, error) {
+	// the arweave transaction is signed over the whole file, so it is read into memory here
+	fileBytes, err := file.bytes()
+	if err != nil {
+		return ResponseToEmbalmer{}, fmt.Errorf("could not read the uploaded file: %v", err)
+	}
+
+	// create arweave tx
+	arweaveTx, err := arch.UploadFileToArweave(fileBytes)
+	if err != nil {
+		return ResponseToEmbalmer{}, err
+	}
+
 	log.Printf("Transaction from arweave successful: %v", arweaveTx.Hash())
 
+	// keep a copy of the file in case it cannot be retrieved from arweave when unwrapping
+	if err := arch.SavePayload(assetDoubleHash, fileBytes); err != nil {
+		log.Printf("Error saving a local copy of the file for the double hash %v: %v", assetDoubleHash, err)
+	}
+
 	// respond to embalmer with:
 	// 1. Public key from the hd wallet at the next index
 	// 2. Sarcophagus identifier
 	// 3. Arweave Tx Hash
 	// 4. Signature of New Public Key + Tx Hash (concatenated)
 	arweaveTxHash := arweaveTx.Hash()
-	newPublicKey := hdw.PublicKeyFromIndex(arch.Wallet, arch.AccountIndex+1)
+	newPublicKey := hdw.PublicKeyFromIndex(arch.Wallet, accountIndex+1)
 	pubKeyConcatTxHash := append(crypto.FromECDSAPub(newPublicKey)[1:], []byte(arweaveTxHash)...)
 	hash := crypto.Keccak256Hash(pubKeyConcatTxHash)
 	assetIdSig, err := crypto.Sign(hash.Bytes(), arch.PrivateKey)
 	if err != nil {
-		arch.fileUploadError("Couldnt sign the arweave tx: "+err.Error(), "There was an error with the file.", http.StatusBadRequest, w)
-		return
+		return ResponseToEmbalmer{}, fmt.Errorf("couldnt sign the arweave tx: %v", err)
 	}
 
 	R, S, V := utility.SigRSV(assetIdSig)
 
-	w.Header().Set("Content-Type", "application/json")
 	response := ResponseToEmbalmer{
 		NewPublicKey:    crypto.FromECDSAPub(newPublicKey)[1:],
 		AssetDoubleHash: assetDoubleHash,
@@ -439,10 +686,11 @@ func (arch *Archaeologist) fileUploadHandler(w http.ResponseWriter, r *http.Requ
 		S:               S,
 	}
 
-	log.Printf("Sending Response to Embalmer: %v", response)
+	if err := arch.SaveResponse(response); err != nil {
+		log.Printf("Error saving the response for the double hash %v to %v: %v", assetDoubleHash, arch.Store.Path(), err)
+	}
 
-	json.NewEncoder(w).Encode(response)
-	arch.fileHandlerCheck()
+	return response, nil
 }
 
 // ListenForFile .
@@ -456,16 +704,39 @@ func (arch *Archaeologist) ListenForFile() {
 // IsServerRunning .
 func (arch *Archaeologist) IsServerRunning() bool {
 	timeout := 1 * time.Second
-	_, err := net.DialTimeout("tcp", net.JoinHostPort("localhost", arch.FilePort), timeout)
+	_, err := net.DialTimeout("tcp", dialAddress(arch.ListenAddress), timeout)
 	return err == nil
 }
 
+// dialAddress returns the address to connect to the server on when it listens on all interfaces
+func dialAddress(listenAddress string) string {
+	host, port, err := net.SplitHostPort(listenAddress)
+	if err != nil {
+		return listenAddress
+	}
+
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "localhost"
+	}
+
+	return net.JoinHostPort(host, port)
+}
+
+// listenAndServe serves https if the server has a TLS config, otherwise http
+func (arch *Archaeologist) listenAndServe() error {
+	if arch.Server.TLSConfig != nil {
+		return arch.Server.ListenAndServeTLS("", "")
+	}
+
+	return arch.Server.ListenAndServe()
+}
+
 // InitAndTestServer .
 func (arch *Archaeologist) InitAndTestServer() {
 	arch.InitServer()
 	log.Printf("Testing Server...")
 	go func() {
-		if err := arch.Server.ListenAndServe(); err != nil {
+		if err := arch.listenAndServe(); err != nil {
 			log.Fatalf("Could not start server. Please check that the port in CONFIG is set correctly and is open. Error: %v", err)
 		}
 	}()
@@ -478,14 +749,29 @@ func (arch *Archaeologist) InitServer() {
 	sm := http.NewServeMux()
 	sm.Handle("/ping", http.HandlerFunc(arch.pingHandler))
 	sm.Handle("/file", http.HandlerFunc(arch.fileUploadHandler))
-	arch.Server = &http.Server{Addr: "localhost:" + arch.FilePort, Handler: utility.LimitMiddleware(sm)}
+	sm.Handle("/file/", http.HandlerFunc(arch.uploadStatusHandler))
+	sm.Handle("/quote", http.HandlerFunc(arch.quoteHandler))
+	arch.Server = &http.Server{
+		Addr:              arch.ListenAddress,
+		Handler:           utility.CORSMiddleware(arch.AllowedOrigins, utility.LimitMiddleware(arch.RateLimiter, sm)),
+		TLSConfig:         arch.TLSConfig,
+		ReadHeaderTimeout: SERVER_READ_HEADER_TIMEOUT,
+		ReadTimeout:       SERVER_READ_TIMEOUT,
+		WriteTimeout:      SERVER_WRITE_TIMEOUT,
+		IdleTimeout:       SERVER_IDLE_TIMEOUT,
+		MaxHeaderBytes:    SERVER_MAX_HEADER_BYTES,
+	}
 }
 
 // Start Server .
 func (arch *Archaeologist) StartServer() {
 	go func() {
-		log.Printf("Server starting on %s:", arch.Server.Addr)
-		if err := arch.Server.ListenAndServe(); err != nil {
+		if arch.Server.TLSConfig != nil {
+			log.Printf("Server starting on %s with TLS", arch.Server.Addr)
+		} else {
+			log.Printf("Server starting on %s:", arch.Server.Addr)
+		}
+		if err := arch.listenAndServe(); err != nil {
 			log.Println("Server shutting down:", err)
 		}
 	}()
@@ -514,20 +800,17 @@ func (arch *Archaeologist) ShutdownServer() {
 
 // IsArchSarcophagus returns true if the sarcophagus is in state
 func (arch *Archaeologist) IsArchSarcophagus(doubleHash [32]byte) bool {
-	_, ok := arch.Sarcophaguses[doubleHash]
-	return ok
+	return arch.Registry.Exists(doubleHash)
 }
 
 // RemoveArchSarcophagus deletes the sarcophagus from state if it exists
-// also removes the file handler
+// also removes the file handler, cancels the unwrap and deletes the local copy of the file, the saved response and any upload job
 func (arch *Archaeologist) RemoveArchSarcophagus(doubleHash [32]byte) {
-	if arch.IsArchSarcophagus(doubleHash) {
-		delete(arch.Sarcophaguses, doubleHash)
-
-		_, ok := arch.FileHandlers[doubleHash]
-		if ok {
-			arch.fileHandlerCheck()
-			delete(arch.FileHandlers, doubleHash)
-		}
+	arch.UnwrapScheduler.Cancel(doubleHash)
+	arch.RemovePayload(doubleHash)
+	arch.RemoveResponse(doubleHash)
+	arch.uploadJobs.remove(doubleHash)
+	if arch.Registry.Remove(doubleHash) {
+		arch.PersistState()
 	}
 }
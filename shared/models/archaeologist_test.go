@@ -0,0 +1,51 @@
+package models
+
+import (
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/price"
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/utility"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"testing"
+)
+
+func TestDialAddress(t *testing.T) {
+	assert.Equal(t, "localhost:8080", dialAddress("localhost:8080"))
+	assert.Equal(t, "localhost:443", dialAddress("0.0.0.0:443"))
+	assert.Equal(t, "localhost:443", dialAddress(":443"))
+	assert.Equal(t, "10.0.0.2:443", dialAddress("10.0.0.2:443"))
+}
+
+func TestRequiredArweaveBalance(t *testing.T) {
+	// 1000 winston reward, multiplied by 1.5 and with a 10% margin
+	cost, err := multiplyArweaveReward("1000", decimal.RequireFromString("1.5"))
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewInt(1650), requiredArweaveBalance(cost))
+
+	// rounded up
+	assert.Equal(t, big.NewInt(2), requiredArweaveBalance(decimal.NewFromInt(1)))
+
+	_, err = multiplyArweaveReward("not a number", decimal.RequireFromString("1.0"))
+	assert.NotNil(t, err)
+}
+
+func TestValidateStorageFeeCoversCost(t *testing.T) {
+	arch := &Archaeologist{}
+	storageFee := utility.ToWei("10", 18)
+	cost := decimal.New(1, 12) // 1 AR
+
+	// not checked without a price
+	assert.Nil(t, arch.validateStorageFeeCoversCost(storageFee, cost))
+
+	// 10 SARCO is worth 1.2 AR
+	arch.SarcoArPrice, _ = price.NewStatic("0.12")
+	assert.Nil(t, arch.validateStorageFeeCoversCost(storageFee, cost))
+	assert.True(t, decimal.NewFromInt(20).Equal(storageFeeMargin(storageFeeValue(storageFee, decimal.RequireFromString("0.12")), cost)))
+
+	// 10 SARCO is worth 0.9 AR, a 10% loss
+	arch.SarcoArPrice, _ = price.NewStatic("0.09")
+	assert.Equal(t, ErrStorageFeeTooLow, arch.validateStorageFeeCoversCost(storageFee, cost))
+
+	arch.LossTolerance = decimal.NewFromInt(10)
+	assert.Nil(t, arch.validateStorageFeeCoversCost(storageFee, cost))
+}
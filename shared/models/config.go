@@ -11,11 +11,28 @@ import (
 
 type Config struct {
 	ETH_NODE              string
+	CHAIN_ID              string
 	ETH_PRIVATE_KEY       string
 	ARWEAVE_KEY_FILE      string
 	ARWEAVE_MULTIPLIER    string
 	ARWEAVE_NODE          string
 	FILE_PORT             string
+	MAX_FILE_SIZE         string
+	LISTEN_ADDRESS        string
+	TLS_CERT_FILE         string
+	TLS_KEY_FILE          string
+	ACME                  string
+	ACME_DIRECTORY_URL    string
+	ACME_CACHE_DIR        string
+	ACME_EMAIL            string
+	ACME_CA_FILE          string
+	RATE_LIMIT            string
+	RATE_LIMIT_BURST      string
+	TRUSTED_PROXIES       string
+	ALLOWED_ORIGINS       string
+	SARCO_AR_PRICE        string
+	SARCO_AR_PRICE_SOURCE string
+	LOSS_TOLERANCE        string
 	ENDPOINT              string
 	FEE_PER_BYTE          string
 	MIN_BOUNTY            string
@@ -27,7 +44,21 @@ type Config struct {
 	REMOVE_FROM_FREE_BOND string
 	PAYMENT_ADDRESS       string
 	GAS_PRICE_OVERRIDE    string
+	GAS_PRICE_MULTIPLIER  string
+	MAX_GAS_PRICE         string
+	LEGACY_TRANSACTIONS   string
 	MNEMONIC              string
+	STATE_FILE            string
+	PAYLOAD_DIR           string
+	ALERT_WEBHOOK         string
+	EVENT_CONFIRMATIONS   string
+	EVENT_SOURCE          string
+	EVENT_POLL_INTERVAL   string
+	TX_CONFIRMATIONS      string
+	TX_TIMEOUT            string
+	SWEEP_INTERVAL        string
+	SARCO_ETH_PRICE       string
+	SWEEP_MIN_PROFIT      string
 }
 
 // LoadConfig .
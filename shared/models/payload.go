@@ -0,0 +1,39 @@
+// Payloads are the double encrypted files uploaded to arweave
+// A copy of each payload is kept in PayloadDir until its sarcophagus is removed from state,
+// so the unwrap can still be verified if the file cannot be retrieved from arweave
+
+package models
+
+import (
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// SavePayload keeps a copy of the file uploaded to arweave for the sarcophagus
+func (arch *Archaeologist) SavePayload(doubleHash [32]byte, fileBytes []byte) error {
+	if err := os.MkdirAll(arch.PayloadDir, 0700); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(arch.payloadPath(doubleHash), fileBytes, 0600)
+}
+
+// LoadPayload returns the copy of the file uploaded to arweave for the sarcophagus
+func (arch *Archaeologist) LoadPayload(doubleHash [32]byte) ([]byte, error) {
+	return ioutil.ReadFile(arch.payloadPath(doubleHash))
+}
+
+// RemovePayload deletes the copy of the file uploaded for the sarcophagus, if there is one
+func (arch *Archaeologist) RemovePayload(doubleHash [32]byte) {
+	if err := os.Remove(arch.payloadPath(doubleHash)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Error removing payload for the double hash %v: %v", doubleHash, err)
+	}
+}
+
+// payloadPath .
+func (arch *Archaeologist) payloadPath(doubleHash [32]byte) string {
+	return filepath.Join(arch.PayloadDir, hexutil.Encode(doubleHash[:]))
+}
@@ -0,0 +1,25 @@
+package models
+
+import (
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+)
+
+func TestPayloadSaveLoadRemove(t *testing.T) {
+	arch := &Archaeologist{PayloadDir: filepath.Join(t.TempDir(), "payloads")}
+	doubleHash := [32]byte{1}
+
+	_, err := arch.LoadPayload(doubleHash)
+	assert.NotNil(t, err)
+
+	assert.Nil(t, arch.SavePayload(doubleHash, []byte("encrypted file")))
+	payload, err := arch.LoadPayload(doubleHash)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("encrypted file"), payload)
+
+	arch.RemovePayload(doubleHash)
+	arch.RemovePayload(doubleHash)
+	_, err = arch.LoadPayload(doubleHash)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,190 @@
+// Quotes tell an embalmer what the archaeologist charges for a sarcophagus before it is created
+// GET /quote?bytes=N&resurrection=T returns the storage fee required for a file of N bytes, the minimum bounty
+// and digging fee, the maximum resurrection time and the public key the file must be encrypted with.
+// It also says whether the archaeologist has capacity for the sarcophagus right now, and if not, why.
+// The values are the ones loaded from the config file, so a quote matches what the file upload is validated against.
+// Fees are in SARCO wei and the maximum resurrection time is in seconds from creation, all sent as strings.
+
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/shopspring/decimal"
+	"log"
+	"math/big"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Quote is the response to GET /quote
+type Quote struct {
+	Bytes               int64    `json:"bytes"`
+	StorageFee          string   `json:"storageFee"`
+	MinBounty           string   `json:"minBounty"`
+	MinDiggingFee       string   `json:"minDiggingFee"`
+	MaxResurrectionTime string   `json:"maxResurrectionTime"`
+	CurrentPublicKey    string   `json:"currentPublicKey"`
+	Capacity            bool     `json:"capacity"`
+	Reasons             []string `json:"reasons,omitempty"` // why there is no capacity
+}
+
+// quoteRequest is the query of a quote
+type quoteRequest struct {
+	bytes        int64
+	resurrection *big.Int // nil if the embalmer did not send a resurrection time
+}
+
+// quoteState is what the quote is computed from, read from arweave and the contract when the quote is requested
+type quoteState struct {
+	cost           decimal.Decimal  // cost of uploading the file to arweave in winston
+	sarcoArPrice   *decimal.Decimal // nil if storage fees are not compared with the arweave cost
+	arweaveBalance *big.Int         // nil if the balance could not be read
+	freeBond       *big.Int         // nil if the free bond could not be read
+	publicKey      []byte
+	now            time.Time
+}
+
+// parseQuoteRequest .
+func parseQuoteRequest(r *http.Request) (quoteRequest, error) {
+	query := r.URL.Query()
+
+	bytes, err := strconv.ParseInt(query.Get("bytes"), 10, 64)
+	if err != nil || bytes < 1 {
+		return quoteRequest{}, fmt.Errorf("bytes must be a positive number of bytes, got: %v", query.Get("bytes"))
+	}
+
+	req := quoteRequest{bytes: bytes}
+	if resurrection := query.Get("resurrection"); resurrection != "" {
+		parsed, ok := new(big.Int).SetString(resurrection, 10)
+		if !ok || parsed.Sign() < 1 {
+			return quoteRequest{}, fmt.Errorf("resurrection must be a unix timestamp in seconds, got: %v", resurrection)
+		}
+		req.resurrection = parsed
+	}
+
+	return req, nil
+}
+
+// quote returns the quote for the request
+func (arch *Archaeologist) quote(req quoteRequest, state quoteState) Quote {
+	q := Quote{
+		Bytes:               req.bytes,
+		StorageFee:          arch.requiredStorageFee(req.bytes, state.cost, state.sarcoArPrice).String(),
+		MinBounty:           arch.MinBounty.String(),
+		MinDiggingFee:       arch.MinDiggingFee.String(),
+		MaxResurrectionTime: arch.MaxResurectionTime.String(),
+		CurrentPublicKey:    hexutil.Encode(state.publicKey),
+	}
+
+	// the cursed bond of a sarcophagus is its digging fee plus its bounty
+	cursedBond := new(big.Int).Add(arch.MinBounty, arch.MinDiggingFee)
+	if state.freeBond == nil {
+		q.Reasons = append(q.Reasons, "the free bond could not be read")
+	} else if state.freeBond.Cmp(cursedBond) < 0 {
+		q.Reasons = append(q.Reasons, "the free bond does not cover the cursed bond")
+	}
+
+	if state.arweaveBalance == nil {
+		q.Reasons = append(q.Reasons, "the arweave balance could not be read")
+	} else if state.arweaveBalance.Cmp(requiredArweaveBalance(state.cost)) < 0 {
+		q.Reasons = append(q.Reasons, "the arweave balance does not cover the cost of storing the file")
+	}
+
+	if req.resurrection != nil {
+		now := big.NewInt(state.now.Unix())
+		latest := new(big.Int).Add(now, arch.MaxResurectionTime)
+		if req.resurrection.Cmp(now) <= 0 {
+			q.Reasons = append(q.Reasons, "the resurrection time has passed")
+		} else if req.resurrection.Cmp(latest) > 0 {
+			q.Reasons = append(q.Reasons, fmt.Sprintf("the resurrection time is later than the maximum resurrection time of %v seconds from now", arch.MaxResurectionTime))
+		}
+	}
+
+	q.Capacity = len(q.Reasons) == 0
+	return q
+}
+
+// requiredStorageFee returns the storage fee for a file of the size in SARCO wei: the fee per byte,
+// raised to cover the cost of uploading the file to arweave (within the loss tolerance) when the SARCO price is known
+func (arch *Archaeologist) requiredStorageFee(bytes int64, cost decimal.Decimal, sarcoArPrice *decimal.Decimal) *big.Int {
+	storageFee := new(big.Int).Mul(big.NewInt(bytes), arch.FeePerByte)
+	if sarcoArPrice == nil {
+		return storageFee
+	}
+
+	// the inverse of storageFeeValue, for the value the loss tolerance allows
+	minValue := cost.Mul(decimal.NewFromInt(100).Sub(arch.LossTolerance)).Div(decimal.NewFromInt(100))
+	minStorageFee := minValue.Div(*sarcoArPrice).Shift(6).Ceil().BigInt()
+	if minStorageFee.Cmp(storageFee) > 0 {
+		return minStorageFee
+	}
+
+	return storageFee
+}
+
+// quoteState reads the values the quote is computed from
+// Returns an error if the storage fee cannot be computed. The balances are left nil if they cannot be read,
+// which is reported as a lack of capacity.
+func (arch *Archaeologist) quoteState(bytes int64) (quoteState, error) {
+	state := quoteState{publicKey: arch.Registry.CurrentPublicKeyBytes(), now: time.Now()}
+
+	cost, err := arch.arweaveCost(bytes)
+	if err != nil {
+		return quoteState{}, err
+	}
+	state.cost = cost
+
+	if arch.SarcoArPrice != nil {
+		sarcoArPrice, err := arch.SarcoArPrice.Price()
+		if err != nil {
+			return quoteState{}, err
+		}
+		state.sarcoArPrice = &sarcoArPrice
+	}
+
+	if state.arweaveBalance, err = arch.arweaveBalance(); err != nil {
+		log.Printf("Error reading the arweave balance for a quote: %v", err)
+	}
+
+	contractArch, err := arch.SarcoSession.Archaeologists(arch.ArchAddress)
+	if err != nil {
+		log.Printf("Error reading the free bond for a quote: %v", err)
+	} else {
+		state.freeBond = contractArch.FreeBond
+	}
+
+	return state, nil
+}
+
+// quoteHandler responds with the quote for the file size and resurrection time in the query
+func (arch *Archaeologist) quoteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	req, err := parseQuoteRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.bytes > arch.MaxFileSize {
+		http.Error(w, fmt.Sprintf("Files larger than the limit of %v bytes are not accepted.", arch.MaxFileSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	state, err := arch.quoteState(req.bytes)
+	if err != nil {
+		log.Printf("Error quoting a file of %v bytes: %v", req.bytes, err)
+		w.Header().Set("Retry-After", strconv.Itoa(ARWEAVE_RETRY_AFTER))
+		http.Error(w, "The archaeologist cannot quote the cost of storing the file right now. Please try again later.", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(arch.quote(req, state))
+}
@@ -0,0 +1,106 @@
+package models
+
+import (
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/utility"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseQuoteRequest(t *testing.T) {
+	req, err := parseQuoteRequest(httptest.NewRequest("GET", "/quote?bytes=1024&resurrection=1700000000", nil))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1024), req.bytes)
+	assert.Equal(t, big.NewInt(1700000000), req.resurrection)
+
+	req, err = parseQuoteRequest(httptest.NewRequest("GET", "/quote?bytes=1024", nil))
+	assert.Nil(t, err)
+	assert.Nil(t, req.resurrection)
+
+	for _, query := range []string{"", "bytes=0", "bytes=-1", "bytes=abc", "bytes=1&resurrection=abc", "bytes=1&resurrection=-5"} {
+		_, err = parseQuoteRequest(httptest.NewRequest("GET", "/quote?"+query, nil))
+		assert.NotNil(t, err, query)
+	}
+}
+
+func TestQuote(t *testing.T) {
+	arch := &Archaeologist{
+		FeePerByte:         utility.ToWei("0.001", 18),
+		MinBounty:          utility.ToWei("100", 18),
+		MinDiggingFee:      utility.ToWei("10", 18),
+		MaxResurectionTime: big.NewInt(3600),
+	}
+	now := time.Unix(1700000000, 0)
+	state := quoteState{
+		cost:           decimal.New(1, 12), // 1 AR
+		arweaveBalance: big.NewInt(2e12),
+		freeBond:       utility.ToWei("110", 18),
+		publicKey:      []byte{4, 1, 2},
+		now:            now,
+	}
+	req := quoteRequest{bytes: 1000, resurrection: big.NewInt(now.Unix() + 3600)}
+
+	q := arch.quote(req, state)
+	assert.True(t, q.Capacity)
+	assert.Empty(t, q.Reasons)
+	assert.Equal(t, utility.ToWei("1", 18).String(), q.StorageFee)
+	assert.Equal(t, utility.ToWei("100", 18).String(), q.MinBounty)
+	assert.Equal(t, utility.ToWei("10", 18).String(), q.MinDiggingFee)
+	assert.Equal(t, "3600", q.MaxResurrectionTime)
+	assert.Equal(t, "0x040102", q.CurrentPublicKey)
+
+	// no capacity
+	state.freeBond = utility.ToWei("109", 18)
+	state.arweaveBalance = big.NewInt(1e12)
+	req.resurrection = big.NewInt(now.Unix() + 3601)
+	q = arch.quote(req, state)
+	assert.False(t, q.Capacity)
+	assert.Len(t, q.Reasons, 3)
+
+	state.freeBond = nil
+	state.arweaveBalance = nil
+	req.resurrection = big.NewInt(now.Unix())
+	q = arch.quote(req, state)
+	assert.False(t, q.Capacity)
+	assert.Len(t, q.Reasons, 3)
+}
+
+func TestRequiredStorageFee(t *testing.T) {
+	arch := &Archaeologist{FeePerByte: utility.ToWei("0.001", 18)}
+	cost := decimal.New(1, 12) // 1 AR
+
+	// 1000 bytes at 0.001 SARCO per byte, without a price
+	assert.Equal(t, utility.ToWei("1", 18), arch.requiredStorageFee(1000, cost, nil))
+
+	// 1 AR costs 10 SARCO, more than the fee per byte
+	sarcoArPrice := decimal.RequireFromString("0.1")
+	storageFee := arch.requiredStorageFee(1000, cost, &sarcoArPrice)
+	assert.Equal(t, utility.ToWei("10", 18), storageFee)
+	assert.True(t, storageFeeMargin(storageFeeValue(storageFee, sarcoArPrice), cost).IsZero())
+
+	// a 10% loss is tolerated
+	arch.LossTolerance = decimal.NewFromInt(10)
+	assert.Equal(t, utility.ToWei("9", 18), arch.requiredStorageFee(1000, cost, &sarcoArPrice))
+
+	// the fee per byte covers the cost
+	sarcoArPrice = decimal.RequireFromString("10")
+	assert.Equal(t, utility.ToWei("1", 18), arch.requiredStorageFee(1000, cost, &sarcoArPrice))
+}
+
+func TestQuoteHandlerInvalidRequest(t *testing.T) {
+	arch := &Archaeologist{MaxFileSize: 1024}
+
+	status := func(method string, target string) int {
+		w := httptest.NewRecorder()
+		arch.quoteHandler(w, httptest.NewRequest(method, target, nil))
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusMethodNotAllowed, status("POST", "/quote?bytes=1"))
+	assert.Equal(t, http.StatusBadRequest, status("GET", "/quote"))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, status("GET", "/quote?bytes=1025"))
+}
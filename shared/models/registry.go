@@ -0,0 +1,338 @@
+// SarcoRegistry holds the sarcophagi the archaeologist is working on, the open file handlers
+// and the hd wallet account index.
+// It is shared by the event handlers, scheduled unwraps and the file upload server, which all run on
+// different goroutines, so all access goes through its methods. Each state transition is atomic.
+
+package models
+
+import (
+	"bytes"
+	"errors"
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/hdw"
+	hdwallet "github.com/miguelmota/go-ethereum-hdwallet"
+	"math/big"
+	"sync"
+)
+
+var (
+	ErrSarcoExists       = errors.New("sarcophagus already exists in state")
+	ErrPublicKeyMismatch = errors.New("public key does not match the current public key")
+)
+
+type SarcoRegistry struct {
+	mu               sync.RWMutex
+	saveMu           sync.Mutex
+	wallet           *hdwallet.Wallet
+	sarcophaguses    map[[32]byte]*Sarco
+	fileHandlers     map[[32]byte]*big.Int
+	accountIndex     int
+	currentPublicKey []byte
+}
+
+// NewSarcoRegistry returns an empty registry at account index 0
+func NewSarcoRegistry(wallet *hdwallet.Wallet) *SarcoRegistry {
+	r := &SarcoRegistry{wallet: wallet}
+	r.Replace(map[[32]byte]*Sarco{}, map[[32]byte]*big.Int{}, 0)
+	return r
+}
+
+// Replace swaps the whole state, used when the state is loaded or rebuilt from the contract
+func (r *SarcoRegistry) Replace(sarcophaguses map[[32]byte]*Sarco, fileHandlers map[[32]byte]*big.Int, accountIndex int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.sarcophaguses = map[[32]byte]*Sarco{}
+	for doubleHash, sarco := range sarcophaguses {
+		sarcoCopy := *sarco
+		r.sarcophaguses[doubleHash] = &sarcoCopy
+	}
+
+	r.fileHandlers = map[[32]byte]*big.Int{}
+	for doubleHash, storageFee := range fileHandlers {
+		r.fileHandlers[doubleHash] = storageFee
+	}
+
+	r.setAccountIndex(accountIndex)
+}
+
+// Export returns a copy of the whole state
+func (r *SarcoRegistry) Export() (map[[32]byte]*Sarco, map[[32]byte]*big.Int, int) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	sarcophaguses := map[[32]byte]*Sarco{}
+	for doubleHash, sarco := range r.sarcophaguses {
+		sarcoCopy := *sarco
+		sarcophaguses[doubleHash] = &sarcoCopy
+	}
+
+	fileHandlers := map[[32]byte]*big.Int{}
+	for doubleHash, storageFee := range r.fileHandlers {
+		fileHandlers[doubleHash] = storageFee
+	}
+
+	return sarcophaguses, fileHandlers, r.accountIndex
+}
+
+// Save passes a copy of the state to save
+// Saves are serialized so an older copy of the state never overwrites a newer one
+func (r *SarcoRegistry) Save(save func(sarcophaguses map[[32]byte]*Sarco, fileHandlers map[[32]byte]*big.Int, accountIndex int) error) error {
+	r.saveMu.Lock()
+	defer r.saveMu.Unlock()
+
+	return save(r.Export())
+}
+
+// Sarco returns a copy of the sarcophagus
+func (r *SarcoRegistry) Sarco(doubleHash [32]byte) (Sarco, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	sarco, ok := r.sarcophaguses[doubleHash]
+	if !ok {
+		return Sarco{}, false
+	}
+
+	return *sarco, true
+}
+
+// Exists returns true if the sarcophagus is in state
+func (r *SarcoRegistry) Exists(doubleHash [32]byte) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	_, ok := r.sarcophaguses[doubleHash]
+	return ok
+}
+
+// Len returns the number of sarcophagi in state
+func (r *SarcoRegistry) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return len(r.sarcophaguses)
+}
+
+// StorageFee returns the storage fee of the open file handler for the sarcophagus
+func (r *SarcoRegistry) StorageFee(doubleHash [32]byte) (*big.Int, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	storageFee, ok := r.fileHandlers[doubleHash]
+	return storageFee, ok
+}
+
+// FileHandlerCount returns the number of sarcophagi a file is expected for
+func (r *SarcoRegistry) FileHandlerCount() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return len(r.fileHandlers)
+}
+
+// AccountIndex returns the index of the hd wallet key pair given out for the next sarcophagus
+func (r *SarcoRegistry) AccountIndex() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.accountIndex
+}
+
+// CurrentPublicKeyBytes returns the public key at the current account index
+func (r *SarcoRegistry) CurrentPublicKeyBytes() []byte {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.currentPublicKey
+}
+
+// Create adds a sarcophagus created with the current public key, and opens a file handler for it
+func (r *SarcoRegistry) Create(doubleHash [32]byte, publicKey []byte, resurrectionTime *big.Int, resurrectionWindow *big.Int, storageFee *big.Int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !bytes.Equal(publicKey, r.currentPublicKey) {
+		return ErrPublicKeyMismatch
+	}
+
+	if _, ok := r.sarcophaguses[doubleHash]; ok {
+		return ErrSarcoExists
+	}
+
+	r.sarcophaguses[doubleHash] = &Sarco{
+		ResurrectionTime:   resurrectionTime,
+		ResurrectionWindow: resurrectionWindow,
+		AccountIndex:       r.accountIndex,
+		Updated:            false,
+		UnwrapAttempts:     0,
+	}
+	r.fileHandlers[doubleHash] = storageFee
+
+	return nil
+}
+
+// MarkUpdated marks the sarcophagus as updated and closes its file handler.
+// The update uses the key pair at the current account index, so the sarcophagus is moved to that index
+// and the next key index is allocated.
+// Returns false if the sarcophagus is not in state or was already updated.
+func (r *SarcoRegistry) MarkUpdated(doubleHash [32]byte) (Sarco, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.fileHandlers, doubleHash)
+
+	sarco, ok := r.sarcophaguses[doubleHash]
+	if !ok || sarco.Updated {
+		return Sarco{}, false
+	}
+
+	sarco.Updated = true
+	sarco.AccountIndex = r.allocateKeyIndex()
+
+	return *sarco, true
+}
+
+// Rewrap sets a new resurrection time and resurrection window on the sarcophagus
+// Returns false if the sarcophagus is not in state or already has this resurrection time.
+func (r *SarcoRegistry) Rewrap(doubleHash [32]byte, resurrectionTime *big.Int, resurrectionWindow *big.Int) (Sarco, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sarco, ok := r.sarcophaguses[doubleHash]
+	if !ok || sarco.ResurrectionTime.Cmp(resurrectionTime) == 0 {
+		return Sarco{}, false
+	}
+
+	sarco.ResurrectionTime = resurrectionTime
+	sarco.ResurrectionWindow = resurrectionWindow
+
+	return *sarco, true
+}
+
+// Remove deletes the sarcophagus and its file handler
+// Returns false if the sarcophagus was not in state
+func (r *SarcoRegistry) Remove(doubleHash [32]byte) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, ok := r.sarcophaguses[doubleHash]
+	delete(r.sarcophaguses, doubleHash)
+	delete(r.fileHandlers, doubleHash)
+
+	return ok
+}
+
+// AllocateKeyIndex marks the key pair at the current account index as used
+// Returns the index of the used key pair.
+func (r *SarcoRegistry) AllocateKeyIndex() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.allocateKeyIndex()
+}
+
+// ClearLastFileHandler closes the file handlers if no more than one is open
+// Returns true if any file handler was closed.
+func (r *SarcoRegistry) ClearLastFileHandler() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.fileHandlers) > 1 {
+		return false
+	}
+
+	cleared := len(r.fileHandlers) > 0
+	r.fileHandlers = map[[32]byte]*big.Int{}
+
+	return cleared
+}
+
+// Snapshot returns a copy of the sarcophagus (nil if it is not in state), the storage fee of its file handler
+// (nil if none is open) and the current account index, so they can be restored
+func (r *SarcoRegistry) Snapshot(doubleHash [32]byte) (*Sarco, *big.Int, int) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var sarcoCopy *Sarco
+	if sarco, ok := r.sarcophaguses[doubleHash]; ok {
+		copied := *sarco
+		sarcoCopy = &copied
+	}
+
+	return sarcoCopy, r.fileHandlers[doubleHash], r.accountIndex
+}
+
+// Restore puts back a sarcophagus and file handler returned by Snapshot
+func (r *SarcoRegistry) Restore(doubleHash [32]byte, sarco *Sarco, storageFee *big.Int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if sarco == nil {
+		delete(r.sarcophaguses, doubleHash)
+	} else {
+		restored := *sarco
+		r.sarcophaguses[doubleHash] = &restored
+	}
+
+	if storageFee == nil {
+		delete(r.fileHandlers, doubleHash)
+	} else {
+		r.fileHandlers[doubleHash] = storageFee
+	}
+}
+
+// FileHandlers returns a copy of the open file handlers
+func (r *SarcoRegistry) FileHandlers() map[[32]byte]*big.Int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	fileHandlers := make(map[[32]byte]*big.Int, len(r.fileHandlers))
+	for doubleHash, storageFee := range r.fileHandlers {
+		fileHandlers[doubleHash] = storageFee
+	}
+
+	return fileHandlers
+}
+
+// ReopenFileHandlers puts back file handlers that were closed, for the sarcophagi that can still be sent a file:
+// those in state that are not updated and were created with the public key at the current account index
+func (r *SarcoRegistry) ReopenFileHandlers(fileHandlers map[[32]byte]*big.Int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for doubleHash, storageFee := range fileHandlers {
+		sarco, ok := r.sarcophaguses[doubleHash]
+		if ok && !sarco.Updated && sarco.AccountIndex == r.accountIndex {
+			r.fileHandlers[doubleHash] = storageFee
+		}
+	}
+}
+
+// RollbackAccountIndex moves the account index back to accountIndex, if it is still at expected
+// Returns false if another key index has been allocated since.
+func (r *SarcoRegistry) RollbackAccountIndex(expected int, accountIndex int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.accountIndex != expected {
+		return false
+	}
+
+	r.setAccountIndex(accountIndex)
+	return true
+}
+
+// allocateKeyIndex must be called with the lock held
+func (r *SarcoRegistry) allocateKeyIndex() int {
+	used := r.accountIndex
+	r.setAccountIndex(used + 1)
+	return used
+}
+
+// setAccountIndex must be called with the lock held
+func (r *SarcoRegistry) setAccountIndex(accountIndex int) {
+	r.accountIndex = accountIndex
+	if r.wallet != nil {
+		r.currentPublicKey = hdw.PublicKeyBytesFromIndex(r.wallet, accountIndex)
+	}
+}
@@ -0,0 +1,89 @@
+package models
+
+import (
+	hdwallet "github.com/miguelmota/go-ethereum-hdwallet"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"sync"
+	"testing"
+)
+
+const testMnemonic = "index cupboard city neither axis spot thumb pet rabbit stuff culture project top fault wisdom"
+
+func TestRegistryCreateAndUpdate(t *testing.T) {
+	wallet, err := hdwallet.NewFromMnemonic(testMnemonic)
+	assert.Nil(t, err)
+
+	r := NewSarcoRegistry(wallet)
+	doubleHash := [32]byte{1}
+	publicKey := r.CurrentPublicKeyBytes()
+
+	assert.Nil(t, r.Create(doubleHash, publicKey, big.NewInt(100), big.NewInt(60), big.NewInt(10)))
+	assert.Equal(t, ErrSarcoExists, r.Create(doubleHash, publicKey, big.NewInt(100), big.NewInt(60), big.NewInt(10)))
+	assert.Equal(t, ErrPublicKeyMismatch, r.Create([32]byte{2}, []byte("other"), big.NewInt(100), big.NewInt(60), big.NewInt(10)))
+	assert.Equal(t, 1, r.FileHandlerCount())
+
+	sarco, ok := r.MarkUpdated(doubleHash)
+	assert.True(t, ok)
+	assert.True(t, sarco.Updated)
+	assert.Equal(t, 0, sarco.AccountIndex)
+	assert.Equal(t, 1, r.AccountIndex())
+	assert.Equal(t, 0, r.FileHandlerCount())
+	assert.NotEqual(t, publicKey, r.CurrentPublicKeyBytes())
+
+	// a replayed update does not use another key
+	_, ok = r.MarkUpdated(doubleHash)
+	assert.False(t, ok)
+	assert.Equal(t, 1, r.AccountIndex())
+
+	_, ok = r.Rewrap(doubleHash, big.NewInt(100), big.NewInt(60))
+	assert.False(t, ok)
+	sarco, ok = r.Rewrap(doubleHash, big.NewInt(200), big.NewInt(120))
+	assert.True(t, ok)
+	assert.Equal(t, big.NewInt(200), sarco.ResurrectionTime)
+	assert.Equal(t, big.NewInt(120), sarco.ResurrectionWindow)
+
+	assert.True(t, r.Remove(doubleHash))
+	assert.False(t, r.Remove(doubleHash))
+}
+
+// TestRegistryConcurrentAccess is meant to be run with the race detector (go test -race)
+func TestRegistryConcurrentAccess(t *testing.T) {
+	const workers = 16
+	const sarcosPerWorker = 50
+
+	r := NewSarcoRegistry(nil)
+	var wg sync.WaitGroup
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+
+			for i := 0; i < sarcosPerWorker; i++ {
+				doubleHash := [32]byte{byte(w), byte(i)}
+
+				assert.Nil(t, r.Create(doubleHash, nil, big.NewInt(int64(i)), big.NewInt(60), big.NewInt(1)))
+				r.StorageFee(doubleHash)
+				r.FileHandlerCount()
+				r.Rewrap(doubleHash, big.NewInt(int64(i+1)), big.NewInt(60))
+				_, updated := r.MarkUpdated(doubleHash)
+				assert.True(t, updated)
+				r.Sarco(doubleHash)
+				r.Export()
+				r.Save(func(map[[32]byte]*Sarco, map[[32]byte]*big.Int, int) error { return nil })
+
+				if i%2 == 0 {
+					r.Remove(doubleHash)
+				}
+			}
+		}(w)
+	}
+
+	wg.Wait()
+
+	// every update allocates exactly one key index
+	assert.Equal(t, workers*sarcosPerWorker, r.AccountIndex())
+	assert.Equal(t, workers*sarcosPerWorker/2, r.Len())
+	assert.Equal(t, 0, r.FileHandlerCount())
+}
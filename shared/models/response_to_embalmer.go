@@ -1,13 +1,50 @@
 // ResponseToEmbalmer model is used to store the data returned to the embalmer
 // after successful completion of the arweave file uploading process
+// Each response is saved to the store until its sarcophagus is removed from state,
+// so a repeated upload of the same file gets the same response instead of paying for another arweave tx
 
 package models
 
+import (
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"log"
+)
+
+const responsesBucket = "responses"
+
 type ResponseToEmbalmer struct {
-	NewPublicKey    []byte   `json:NewPublicKey`
+	NewPublicKey    []byte   `json:"NewPublicKey"`
 	AssetId         string   `json:"AssetId"`
 	AssetDoubleHash [32]byte `json:"AssetDoubleHash"`
 	V               uint8    `json:"V"`
 	R               [32]byte `json:"R"`
 	S               [32]byte `json:"S"`
 }
+
+// SaveResponse keeps the response sent to the embalmer for the sarcophagus
+func (arch *Archaeologist) SaveResponse(response ResponseToEmbalmer) error {
+	return arch.Store.Put(responsesBucket, hexutil.Encode(response.AssetDoubleHash[:]), response)
+}
+
+// SavedResponse returns the response already sent to the embalmer for the sarcophagus, if there is one
+func (arch *Archaeologist) SavedResponse(doubleHash [32]byte) (ResponseToEmbalmer, bool) {
+	var response ResponseToEmbalmer
+	ok, err := arch.Store.Get(responsesBucket, hexutil.Encode(doubleHash[:]), &response)
+	if err != nil {
+		log.Printf("Error reading the saved response for the double hash %v: %v", doubleHash, err)
+	}
+
+	return response, ok && err == nil
+}
+
+// HasSavedResponses returns true if a file has been uploaded for a sarcophagus that is still in state
+func (arch *Archaeologist) HasSavedResponses() bool {
+	return len(arch.Store.Keys(responsesBucket)) > 0
+}
+
+// RemoveResponse deletes the response saved for the sarcophagus, if there is one
+func (arch *Archaeologist) RemoveResponse(doubleHash [32]byte) {
+	if err := arch.Store.Delete(responsesBucket, hexutil.Encode(doubleHash[:])); err != nil {
+		log.Printf("Error removing the saved response for the double hash %v: %v", doubleHash, err)
+	}
+}
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/store"
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+)
+
+func TestSavedResponseSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	s, err := store.Open(path)
+	assert.Nil(t, err)
+	arch := &Archaeologist{Store: s}
+	doubleHash := [32]byte{1}
+
+	_, ok := arch.SavedResponse(doubleHash)
+	assert.False(t, ok)
+	assert.False(t, arch.HasSavedResponses())
+
+	response := ResponseToEmbalmer{NewPublicKey: []byte("public key"), AssetId: "arweave tx", AssetDoubleHash: doubleHash, V: 27, R: [32]byte{2}, S: [32]byte{3}}
+	assert.Nil(t, arch.SaveResponse(response))
+
+	s, err = store.Open(path)
+	assert.Nil(t, err)
+	arch = &Archaeologist{Store: s}
+	saved, ok := arch.SavedResponse(doubleHash)
+	assert.True(t, ok)
+	assert.Equal(t, response, saved)
+	assert.True(t, arch.HasSavedResponses())
+
+	arch.RemoveResponse(doubleHash)
+	_, ok = arch.SavedResponse(doubleHash)
+	assert.False(t, ok)
+}
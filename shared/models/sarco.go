@@ -5,8 +5,9 @@ package models
 import "math/big"
 
 type Sarco struct {
-	ResurrectionTime *big.Int
-	AccountIndex     int
-	Updated          bool
+	ResurrectionTime   *big.Int
+	ResurrectionWindow *big.Int // the sarcophagus can be unwrapped until resurrection time + resurrection window
+	AccountIndex       int
+	Updated            bool
 	UnwrapAttempts	 int
 }
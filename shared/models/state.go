@@ -0,0 +1,162 @@
+// State persistence for the archaeologist
+// Sarcophaguses, file handlers and the hd wallet account index are saved to the local store
+// on every state transition, and loaded back when the service restarts
+
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/store"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"log"
+	"math/big"
+)
+
+const (
+	sarcophagiBucket   = "sarcophagi"
+	fileHandlersBucket = "file_handlers"
+	metaBucket         = "meta"
+
+	accountIndexKey       = "account_index"
+	sarcophagusCountKey   = "sarcophagus_count"
+	lastProcessedBlockKey = "last_processed_block"
+)
+
+// LoadState loads sarcophaguses, file handlers and the account index from the store.
+// Returns false if no state has been saved yet.
+func (arch *Archaeologist) LoadState() (bool, error) {
+	sarcophaguses := map[[32]byte]*Sarco{}
+	fileHandlers := map[[32]byte]*big.Int{}
+	accountIndex := 0
+
+	ok, err := arch.Store.Get(metaBucket, accountIndexKey, &accountIndex)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	err = arch.Store.ForEach(sarcophagiBucket, func(key string, raw []byte) error {
+		doubleHash, err := DoubleHashFromKey(key)
+		if err != nil {
+			return err
+		}
+
+		sarco := new(Sarco)
+		if err := json.Unmarshal(raw, sarco); err != nil {
+			return fmt.Errorf("could not decode sarcophagus %v in state: %v", key, err)
+		}
+		sarcophaguses[doubleHash] = sarco
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	err = arch.Store.ForEach(fileHandlersBucket, func(key string, raw []byte) error {
+		doubleHash, err := DoubleHashFromKey(key)
+		if err != nil {
+			return err
+		}
+
+		storageFee := new(big.Int)
+		if err := json.Unmarshal(raw, storageFee); err != nil {
+			return fmt.Errorf("could not decode file handler %v in state: %v", key, err)
+		}
+		fileHandlers[doubleHash] = storageFee
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	arch.Registry.Replace(sarcophaguses, fileHandlers, accountIndex)
+	return true, nil
+}
+
+// SaveState writes the current sarcophaguses, file handlers and account index to the store in one atomic write
+func (arch *Archaeologist) SaveState() error {
+	return arch.Registry.Save(func(sarcophaguses map[[32]byte]*Sarco, fileHandlers map[[32]byte]*big.Int, accountIndex int) error {
+		return arch.Store.Update(func(b *store.Batch) error {
+			return writeState(b, sarcophaguses, fileHandlers, accountIndex)
+		})
+	})
+}
+
+// writeState replaces the saved sarcophaguses, file handlers and account index in the batch
+func writeState(b *store.Batch, sarcophaguses map[[32]byte]*Sarco, fileHandlers map[[32]byte]*big.Int, accountIndex int) error {
+	b.Clear(sarcophagiBucket)
+	for doubleHash, sarco := range sarcophaguses {
+		if err := b.Put(sarcophagiBucket, DoubleHashKey(doubleHash), sarco); err != nil {
+			return err
+		}
+	}
+
+	b.Clear(fileHandlersBucket)
+	for doubleHash, storageFee := range fileHandlers {
+		if err := b.Put(fileHandlersBucket, DoubleHashKey(doubleHash), storageFee); err != nil {
+			return err
+		}
+	}
+
+	return b.Put(metaBucket, accountIndexKey, accountIndex)
+}
+
+// PersistState saves the state and logs an error if the save fails
+// The in-memory state remains the source of truth while the service is running
+func (arch *Archaeologist) PersistState() {
+	if err := arch.SaveState(); err != nil {
+		log.Printf("Error saving state to %v: %v", arch.Store.Path(), err)
+	}
+}
+
+// SarcophagusCount returns how many of the archaeologist's sarcophagi on the contract have already been indexed into state
+func (arch *Archaeologist) SarcophagusCount() (*big.Int, error) {
+	var count string
+	ok, err := arch.Store.Get(metaBucket, sarcophagusCountKey, &count)
+	if err != nil || !ok {
+		return big.NewInt(0), err
+	}
+
+	countInt, ok := new(big.Int).SetString(count, 10)
+	if !ok {
+		return big.NewInt(0), fmt.Errorf("invalid sarcophagus count in state: %v", count)
+	}
+
+	return countInt, nil
+}
+
+// SetSarcophagusCount records how many of the archaeologist's sarcophagi on the contract have been indexed into state
+func (arch *Archaeologist) SetSarcophagusCount(count *big.Int) error {
+	return arch.Store.Put(metaBucket, sarcophagusCountKey, count.String())
+}
+
+// LastProcessedBlock returns the last block for which all contract events have been handled.
+// Returns false if no block has been saved yet.
+func (arch *Archaeologist) LastProcessedBlock() (uint64, bool, error) {
+	var block uint64
+	ok, err := arch.Store.Get(metaBucket, lastProcessedBlockKey, &block)
+	return block, ok, err
+}
+
+// SetLastProcessedBlock saves the last block for which all contract events have been handled
+func (arch *Archaeologist) SetLastProcessedBlock(block uint64) error {
+	return arch.Store.Put(metaBucket, lastProcessedBlockKey, block)
+}
+
+// DoubleHashKey converts a sarcophagus identifier to the key used in the store
+func DoubleHashKey(doubleHash [32]byte) string {
+	return hexutil.Encode(doubleHash[:])
+}
+
+// DoubleHashFromKey converts a store key back to a sarcophagus identifier
+func DoubleHashFromKey(key string) ([32]byte, error) {
+	var doubleHash [32]byte
+
+	decoded, err := hexutil.Decode(key)
+	if err != nil || len(decoded) != 32 {
+		return doubleHash, fmt.Errorf("invalid sarcophagus identifier in state: %v", key)
+	}
+
+	copy(doubleHash[:], decoded)
+	return doubleHash, nil
+}
@@ -0,0 +1,57 @@
+// SubscriptionStatus tracks whether the contract event subscriptions are live
+// Used by the rest of the service to know if contract events are currently being received
+
+package models
+
+import (
+	"sync"
+	"time"
+)
+
+type SubscriptionStatus struct {
+	mu        sync.RWMutex
+	alive     bool
+	since     time.Time
+	lastError error
+	outages   int
+}
+
+// SetAlive marks the subscriptions as live
+func (s *SubscriptionStatus) SetAlive() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.alive {
+		s.alive = true
+		s.since = time.Now()
+	}
+}
+
+// SetDown marks the subscriptions as down because of err
+func (s *SubscriptionStatus) SetDown(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.alive {
+		s.outages += 1
+	}
+	s.alive = false
+	s.since = time.Now()
+	s.lastError = err
+}
+
+// Alive returns true if contract events are currently being received
+func (s *SubscriptionStatus) Alive() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.alive
+}
+
+// Status returns whether the subscriptions are live, since when, the last subscription error and the number of outages
+func (s *SubscriptionStatus) Status() (bool, time.Time, error, int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.alive, s.since, s.lastError, s.outages
+}
@@ -0,0 +1,206 @@
+// Uploads are the double encrypted files sent by the embalmer to the file upload endpoint
+// The file can be sent as:
+// 1. JSON with the base64 encoded file bytes (SarcoFile)
+// 2. The raw file bytes, with the Content-Type application/octet-stream
+// 3. A multipart form with the file in the "file" field
+// Raw and multipart uploads are streamed to a temp file as they are received, and JSON uploads are written to one once
+// decoded. The file is validated by decrypting it from the temp file, and is only read into memory when it is uploaded
+// to arweave, which signs the whole file. The request body is capped before it is read, so a file larger than
+// MaxFileSize is rejected without being read in full.
+
+package models
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/utility"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+)
+
+const (
+	UPLOAD_FORM_FIELD = "file"  // multipart form field the file is sent in
+	UPLOAD_OVERHEAD   = 1 << 16 // allowance for the JSON or multipart encoding on top of the file size
+)
+
+var ErrFileTooLarge = errors.New("file is too large")
+
+// uploadedFile is a file received from the embalmer, kept in a temp file until it has been uploaded to arweave
+type uploadedFile struct {
+	path string // empty once the file has been handed over to an upload job
+	size int64
+}
+
+// uploadError is an upload that could not be read because the request was invalid
+type uploadError struct {
+	msg string
+}
+
+func (e *uploadError) Error() string {
+	return e.msg
+}
+
+// readUpload saves the file sent in the request to a temp file, which the caller must remove
+// Returns ErrFileTooLarge if the file (or the request body) is larger than allowed by MaxFileSize
+func (arch *Archaeologist) readUpload(w http.ResponseWriter, r *http.Request) (*uploadedFile, error) {
+	if r.Body == nil {
+		return nil, &uploadError{"Please send a request body"}
+	}
+
+	mediaType := ""
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		var err error
+		mediaType, _, err = mime.ParseMediaType(contentType)
+		if err != nil {
+			return nil, &uploadError{fmt.Sprintf("invalid Content-Type: %v", err)}
+		}
+	}
+
+	limit := arch.MaxFileSize + UPLOAD_OVERHEAD
+	if mediaType == "" || mediaType == "application/json" {
+		limit = int64(base64.StdEncoding.EncodedLen(int(arch.MaxFileSize))) + UPLOAD_OVERHEAD
+	}
+
+	if r.ContentLength > limit {
+		return nil, ErrFileTooLarge
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+
+	switch mediaType {
+	case "application/octet-stream":
+		return arch.receiveFile(r.Body)
+	case "multipart/form-data":
+		return arch.readMultipartUpload(r)
+	case "", "application/json":
+		return arch.readJSONUpload(r.Body)
+	}
+
+	return nil, &uploadError{fmt.Sprintf("unsupported Content-Type: %v", mediaType)}
+}
+
+// readJSONUpload decodes the base64 encoded file bytes of a SarcoFile
+func (arch *Archaeologist) readJSONUpload(body io.Reader) (*uploadedFile, error) {
+	var sarcoFile SarcoFile
+	if err := json.NewDecoder(body).Decode(&sarcoFile); err != nil {
+		return nil, bodyError(err)
+	}
+
+	fileBytes, err := base64.StdEncoding.DecodeString(sarcoFile.FileBytes)
+	if err != nil {
+		return nil, &uploadError{fmt.Sprintf("error decoding file: %v", err)}
+	}
+
+	if int64(len(fileBytes)) > arch.MaxFileSize {
+		return nil, ErrFileTooLarge
+	}
+
+	return arch.receiveFile(bytes.NewReader(fileBytes))
+}
+
+// readMultipartUpload streams the file in the UPLOAD_FORM_FIELD field of a multipart form
+func (arch *Archaeologist) readMultipartUpload(r *http.Request) (*uploadedFile, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, &uploadError{err.Error()}
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, &uploadError{fmt.Sprintf("the form does not have a %v field", UPLOAD_FORM_FIELD)}
+		}
+		if err != nil {
+			return nil, bodyError(err)
+		}
+
+		if part.FormName() == UPLOAD_FORM_FIELD {
+			defer part.Close()
+			return arch.receiveFile(part)
+		}
+		part.Close()
+	}
+}
+
+// receiveFile copies the file to a temp file, up to MaxFileSize
+func (arch *Archaeologist) receiveFile(src io.Reader) (*uploadedFile, error) {
+	tmp, err := ioutil.TempFile("", "sarcophagus-upload-")
+	if err != nil {
+		return nil, err
+	}
+	file := &uploadedFile{path: tmp.Name()}
+
+	file.size, err = io.Copy(tmp, io.LimitReader(src, arch.MaxFileSize+1))
+	if closeErr := tmp.Close(); err == nil && closeErr != nil {
+		file.remove()
+		return nil, closeErr
+	}
+	if err != nil {
+		file.remove()
+		return nil, bodyError(err)
+	}
+	if file.size > arch.MaxFileSize {
+		file.remove()
+		return nil, ErrFileTooLarge
+	}
+
+	return file, nil
+}
+
+// doubleHash decrypts the file with the private key as it is read from the temp file, and returns the sarcophagus
+// identifier of the decrypted file
+func (f *uploadedFile) doubleHash(privateKey *ecdsa.PrivateKey) ([32]byte, error) {
+	src, err := os.Open(f.path)
+	if err != nil {
+		return [32]byte{}, err
+	}
+	defer src.Close()
+
+	singleHash, err := utility.DecryptFileHash(src, f.size, privateKey)
+	if err != nil {
+		return [32]byte{}, err
+	}
+
+	return utility.SingleHashToDoubleHashBytes(singleHash), nil
+}
+
+// bytes reads the file into memory
+func (f *uploadedFile) bytes() ([]byte, error) {
+	return ioutil.ReadFile(f.path)
+}
+
+// detach returns the file for an upload job to remove once it is done, so it is not removed by f
+func (f *uploadedFile) detach() *uploadedFile {
+	detached := *f
+	f.path = ""
+	return &detached
+}
+
+// remove deletes the temp file, unless it has been detached
+func (f *uploadedFile) remove() {
+	if f.path == "" {
+		return
+	}
+
+	if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+		log.Printf("Error removing the uploaded file %v: %v", f.path, err)
+	}
+	f.path = ""
+}
+
+// bodyError returns ErrFileTooLarge if the request body was larger than its cap, otherwise the error is from an invalid request
+func bodyError(err error) error {
+	// http.MaxBytesReader does not export its error in this version of go
+	if err.Error() == "http: request body too large" {
+		return ErrFileTooLarge
+	}
+
+	return &uploadError{err.Error()}
+}
@@ -0,0 +1,224 @@
+// Upload jobs upload a validated file to arweave in the background
+// A file sent to /file?async=true is validated while the embalmer waits, then the upload to arweave is queued
+// and the embalmer is sent the job straight away. The job's status is returned by GET /file/{id}, and includes
+// the response to embalmer once the file has been uploaded. The job id is the sarcophagus double hash (hex encoded).
+// Jobs are kept in memory while they are running, the response of a finished job is read from the saved responses.
+
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	UPLOAD_QUEUED    = "queued"
+	UPLOAD_UPLOADING = "uploading"
+	UPLOAD_FAILED    = "failed"
+	UPLOAD_DONE      = "done"
+)
+
+// UploadJob is the status of an upload to arweave
+type UploadJob struct {
+	Id       string              `json:"id"`
+	Status   string              `json:"status"`
+	Error    string              `json:"error,omitempty"`
+	Response *ResponseToEmbalmer `json:"response,omitempty"`
+}
+
+// uploadJobs are the jobs that are running, or have failed
+type uploadJobs struct {
+	mu   sync.Mutex
+	jobs map[[32]byte]*UploadJob
+}
+
+// start adds a queued job for the sarcophagus, unless one is already running
+// Returns true if the job was added
+func (u *uploadJobs) start(doubleHash [32]byte) (UploadJob, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if job, ok := u.jobs[doubleHash]; ok && job.Status != UPLOAD_FAILED {
+		return *job, false
+	}
+
+	if u.jobs == nil {
+		u.jobs = map[[32]byte]*UploadJob{}
+	}
+	job := &UploadJob{Id: hexutil.Encode(doubleHash[:]), Status: UPLOAD_QUEUED}
+	u.jobs[doubleHash] = job
+	return *job, true
+}
+
+// get returns the job for the sarcophagus, if it is running or has failed
+func (u *uploadJobs) get(doubleHash [32]byte) (UploadJob, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	job, ok := u.jobs[doubleHash]
+	if !ok {
+		return UploadJob{}, false
+	}
+	return *job, true
+}
+
+// set updates the status of the job
+func (u *uploadJobs) set(doubleHash [32]byte, status string, err error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	job, ok := u.jobs[doubleHash]
+	if !ok {
+		return
+	}
+
+	job.Status = status
+	if err != nil {
+		job.Error = err.Error()
+	}
+}
+
+// remove forgets the job, once its response has been saved
+func (u *uploadJobs) remove(doubleHash [32]byte) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	delete(u.jobs, doubleHash)
+}
+
+// uploadLocks lock the upload of each sarcophagus, so a file is not uploaded twice while other files are uploaded at the same time
+type uploadLocks struct {
+	mu    sync.Mutex
+	locks map[[32]byte]*uploadLock
+}
+
+type uploadLock struct {
+	sync.Mutex
+	waiting int // uploads holding or waiting for the lock, guarded by uploadLocks.mu
+}
+
+// lock waits until no other upload of the sarcophagus is in progress
+// Returns the function that unlocks it.
+func (u *uploadLocks) lock(doubleHash [32]byte) func() {
+	u.mu.Lock()
+	if u.locks == nil {
+		u.locks = map[[32]byte]*uploadLock{}
+	}
+	l, ok := u.locks[doubleHash]
+	if !ok {
+		l = &uploadLock{}
+		u.locks[doubleHash] = l
+	}
+	l.waiting++
+	u.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+
+		u.mu.Lock()
+		defer u.mu.Unlock()
+		l.waiting--
+		if l.waiting == 0 {
+			delete(u.locks, doubleHash)
+		}
+	}
+}
+
+// isAsync returns true if the embalmer asked for the upload to be done in the background
+func isAsync(r *http.Request) bool {
+	async, _ := strconv.ParseBool(r.URL.Query().Get("async"))
+	return async
+}
+
+// startUploadJob queues the upload of a validated file to arweave
+// The job takes the file and the arweave balance reserved for it over, and removes and releases them once it is done.
+// Returns the job that is already running if the file is being uploaded, releasing the balance and leaving the file to the caller.
+func (arch *Archaeologist) startUploadJob(file *uploadedFile, release func(), doubleHash [32]byte, accountIndex int) UploadJob {
+	job, started := arch.uploadJobs.start(doubleHash)
+	if !started {
+		release()
+		return job
+	}
+
+	log.Printf("Queued the upload of the file for the double hash %v: %v", doubleHash, job.Id)
+	file = file.detach()
+	go func() {
+		defer file.remove()
+		defer release()
+
+		unlock := arch.uploadLocks.lock(doubleHash)
+		defer unlock()
+
+		if _, ok := arch.SavedResponse(doubleHash); ok {
+			arch.uploadJobs.remove(doubleHash)
+			return
+		}
+
+		arch.uploadJobs.set(doubleHash, UPLOAD_UPLOADING, nil)
+		if _, err := arch.uploadFile(file, doubleHash, accountIndex); err != nil {
+			log.Printf("Error uploading file: %v", err)
+			arch.uploadJobs.set(doubleHash, UPLOAD_FAILED, err)
+			arch.fileHandlerCheck()
+			return
+		}
+
+		arch.uploadJobs.remove(doubleHash)
+		arch.fileHandlerCheck()
+	}()
+
+	return job
+}
+
+// uploadStatus returns the status of the upload for the sarcophagus
+func (arch *Archaeologist) uploadStatus(doubleHash [32]byte) (UploadJob, bool) {
+	if job, ok := arch.uploadJobs.get(doubleHash); ok {
+		return job, true
+	}
+
+	if response, ok := arch.SavedResponse(doubleHash); ok {
+		return UploadJob{Id: hexutil.Encode(doubleHash[:]), Status: UPLOAD_DONE, Response: &response}, true
+	}
+
+	return UploadJob{}, false
+}
+
+// uploadStatusHandler responds with the status of the upload job in the path: /file/{id}
+func (arch *Archaeologist) uploadStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/file/")
+	identifier, err := hexutil.Decode(id)
+	if err != nil || len(identifier) != 32 {
+		http.Error(w, fmt.Sprintf("Invalid upload id: %v", id), http.StatusBadRequest)
+		return
+	}
+
+	var doubleHash [32]byte
+	copy(doubleHash[:], identifier)
+
+	job, ok := arch.uploadStatus(doubleHash)
+	if !ok {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return
+	}
+
+	writeUploadJob(w, job, http.StatusOK)
+}
+
+// writeUploadJob responds with the job, and where its status can be requested
+func writeUploadJob(w http.ResponseWriter, job UploadJob, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/file/"+job.Id)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(job)
+}
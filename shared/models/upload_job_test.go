@@ -0,0 +1,225 @@
+package models
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"github.com/Dev43/arweave-go/transactor"
+	"github.com/Dev43/arweave-go/wallet"
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/hdw"
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/store"
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/utility"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/crypto/ecies"
+	"github.com/mendsley/gojwk"
+	hdwallet "github.com/miguelmota/go-ethereum-hdwallet"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUploadJobs(t *testing.T) {
+	var jobs uploadJobs
+	doubleHash := [32]byte{1}
+
+	job, started := jobs.start(doubleHash)
+	assert.True(t, started)
+	assert.Equal(t, UPLOAD_QUEUED, job.Status)
+	assert.Equal(t, hexutil.Encode(doubleHash[:]), job.Id)
+
+	// a running job is not started again
+	jobs.set(doubleHash, UPLOAD_UPLOADING, nil)
+	job, started = jobs.start(doubleHash)
+	assert.False(t, started)
+	assert.Equal(t, UPLOAD_UPLOADING, job.Status)
+
+	// a failed job can be retried
+	jobs.set(doubleHash, UPLOAD_FAILED, errors.New("arweave gateway timed out"))
+	job, ok := jobs.get(doubleHash)
+	assert.True(t, ok)
+	assert.Equal(t, "arweave gateway timed out", job.Error)
+	job, started = jobs.start(doubleHash)
+	assert.True(t, started)
+	assert.Equal(t, UPLOAD_QUEUED, job.Status)
+	assert.Empty(t, job.Error)
+
+	jobs.remove(doubleHash)
+	_, ok = jobs.get(doubleHash)
+	assert.False(t, ok)
+}
+
+func TestUploadStatusHandler(t *testing.T) {
+	s, err := store.Open(filepath.Join(t.TempDir(), "state.json"))
+	assert.Nil(t, err)
+	arch := &Archaeologist{Store: s}
+	running, done := [32]byte{1}, [32]byte{2}
+
+	arch.uploadJobs.start(running)
+	response := ResponseToEmbalmer{AssetId: "arweave tx", AssetDoubleHash: done}
+	assert.Nil(t, arch.SaveResponse(response))
+
+	status := func(id string) (int, UploadJob) {
+		w := httptest.NewRecorder()
+		arch.uploadStatusHandler(w, httptest.NewRequest("GET", "/file/"+id, nil))
+
+		var job UploadJob
+		if w.Code == http.StatusOK {
+			assert.Nil(t, json.NewDecoder(w.Body).Decode(&job))
+		}
+		return w.Code, job
+	}
+
+	code, job := status(hexutil.Encode(running[:]))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, UPLOAD_QUEUED, job.Status)
+	assert.Nil(t, job.Response)
+
+	code, job = status(hexutil.Encode(done[:]))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, UPLOAD_DONE, job.Status)
+	assert.Equal(t, &response, job.Response)
+
+	code, _ = status(hexutil.Encode([]byte{3}))
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	unknown := [32]byte{3}
+	code, _ = status(hexutil.Encode(unknown[:]))
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+// testArweaveWallet returns an arweave wallet with a new key
+func testArweaveWallet(t *testing.T) *wallet.Wallet {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	assert.Nil(t, err)
+	jwk, err := gojwk.PrivateKey(key)
+	assert.Nil(t, err)
+	jwkBytes, err := gojwk.Marshal(jwk)
+	assert.Nil(t, err)
+
+	w := wallet.NewWallet()
+	assert.Nil(t, w.LoadKey(jwkBytes))
+	return w
+}
+
+func TestAsyncUploadsRunAtTheSameTime(t *testing.T) {
+	// the arweave node holds on to the transactions until the test lets them through
+	sent := make(chan struct{}, 2)
+	mined := make(chan struct{})
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/tx":
+			sent <- struct{}{}
+			<-mined
+			w.Write([]byte("OK"))
+		case strings.HasPrefix(r.URL.Path, "/price/"):
+			w.Write([]byte("1000"))
+		case strings.HasSuffix(r.URL.Path, "/balance"):
+			w.Write([]byte("1000000"))
+		case r.URL.Path == "/tx_anchor":
+			w.Write([]byte("anchor"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer node.Close()
+	var once sync.Once
+	letThrough := func() { once.Do(func() { close(mined) }) }
+	defer letThrough()
+
+	hdWallet, err := hdwallet.NewFromMnemonic(testMnemonic)
+	assert.Nil(t, err)
+	tr, err := transactor.NewTransactor(node.URL)
+	assert.Nil(t, err)
+	s, err := store.Open(filepath.Join(t.TempDir(), "state.json"))
+	assert.Nil(t, err)
+	privateKey, err := crypto.GenerateKey()
+	assert.Nil(t, err)
+
+	arch := &Archaeologist{
+		ArweaveNode:       node.URL,
+		ArweaveWallet:     testArweaveWallet(t),
+		ArweaveTransactor: tr,
+		ArweaveMultiplier: decimal.NewFromInt(1),
+		PrivateKey:        privateKey,
+		FeePerByte:        big.NewInt(1),
+		Wallet:            hdWallet,
+		Store:             s,
+		PayloadDir:        t.TempDir(),
+		Registry:          NewSarcoRegistry(hdWallet),
+		MaxFileSize:       MB,
+	}
+
+	// two sarcophagi waiting for their files
+	var files [][]byte
+	fileHandlers := map[[32]byte]*big.Int{}
+	publicKey := ecies.ImportECDSAPublic(&hdw.PrivateKeyFromIndex(hdWallet, 0).PublicKey)
+	for _, payload := range []string{"first payload", "second payload"} {
+		encrypted, err := ecies.Encrypt(rand.Reader, publicKey, []byte(payload), nil, nil)
+		assert.Nil(t, err)
+		files = append(files, encrypted)
+		fileHandlers[utility.FileBytesToDoubleHashBytes([]byte(payload))] = big.NewInt(1e18)
+	}
+	arch.Registry.Replace(nil, fileHandlers, 0)
+
+	upload := func(file []byte) int {
+		code := make(chan int, 1)
+		go func() {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "/file?async=true", bytes.NewReader(file))
+			r.Header.Set("Content-Type", "application/octet-stream")
+			arch.fileUploadHandler(w, r)
+			code <- w.Code
+		}()
+
+		select {
+		case c := <-code:
+			return c
+		case <-time.After(5 * time.Second):
+			t.Fatal("the upload was not accepted while another file was being uploaded")
+			return 0
+		}
+	}
+
+	waitSent := func() {
+		select {
+		case <-sent:
+		case <-time.After(5 * time.Second):
+			t.Fatal("the file was not sent to arweave")
+		}
+	}
+
+	// the first file is being sent to arweave when the second one is accepted
+	assert.Equal(t, http.StatusAccepted, upload(files[0]))
+	waitSent()
+	assert.Equal(t, http.StatusAccepted, upload(files[1]))
+	waitSent()
+
+	// both uploads have reserved their cost of the arweave balance
+	reserved := func() *big.Int {
+		arch.arweaveMu.Lock()
+		defer arch.arweaveMu.Unlock()
+		return new(big.Int).Set(&arch.arweaveReserved)
+	}
+	assert.Equal(t, big.NewInt(2200), reserved())
+
+	// the reservations are released once the files have been uploaded
+	letThrough()
+	for _, payload := range []string{"first payload", "second payload"} {
+		doubleHash := utility.FileBytesToDoubleHashBytes([]byte(payload))
+		assert.Eventually(t, func() bool {
+			job, _ := arch.uploadStatus(doubleHash)
+			return job.Status == UPLOAD_DONE
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+	assert.Equal(t, big.NewInt(0), reserved())
+}
@@ -0,0 +1,124 @@
+package models
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/utility"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/crypto/ecies"
+	"github.com/stretchr/testify/assert"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func uploadRequest(t *testing.T, contentType string, body []byte) (*httptest.ResponseRecorder, *http.Request) {
+	r := httptest.NewRequest("POST", "/file", bytes.NewReader(body))
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	return httptest.NewRecorder(), r
+}
+
+// readUploadBytes reads the upload from the temp file it was saved to, and removes the temp file
+func readUploadBytes(t *testing.T, arch *Archaeologist, w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	file, err := arch.readUpload(w, r)
+	if err != nil {
+		return nil, err
+	}
+
+	fileBytes, err := file.bytes()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(fileBytes)), file.size)
+
+	path := file.path
+	file.remove()
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+
+	return fileBytes, nil
+}
+
+func TestReadUpload(t *testing.T) {
+	arch := &Archaeologist{MaxFileSize: 16}
+	file := []byte("encrypted file")
+
+	jsonBody, err := json.Marshal(SarcoFile{FileBytes: base64.StdEncoding.EncodeToString(file)})
+	assert.Nil(t, err)
+	w, r := uploadRequest(t, "", jsonBody)
+	fileBytes, err := readUploadBytes(t, arch, w, r)
+	assert.Nil(t, err)
+	assert.Equal(t, file, fileBytes)
+
+	w, r = uploadRequest(t, "application/octet-stream", file)
+	fileBytes, err = readUploadBytes(t, arch, w, r)
+	assert.Nil(t, err)
+	assert.Equal(t, file, fileBytes)
+
+	form := new(bytes.Buffer)
+	writer := multipart.NewWriter(form)
+	assert.Nil(t, writer.WriteField("note", "ignored"))
+	part, err := writer.CreateFormFile(UPLOAD_FORM_FIELD, "payload")
+	assert.Nil(t, err)
+	part.Write(file)
+	assert.Nil(t, writer.Close())
+	w, r = uploadRequest(t, writer.FormDataContentType(), form.Bytes())
+	fileBytes, err = readUploadBytes(t, arch, w, r)
+	assert.Nil(t, err)
+	assert.Equal(t, file, fileBytes)
+
+	w, r = uploadRequest(t, "text/plain", file)
+	_, err = arch.readUpload(w, r)
+	assert.IsType(t, &uploadError{}, err)
+}
+
+func TestReadUploadTooLarge(t *testing.T) {
+	arch := &Archaeologist{MaxFileSize: 16}
+	file := bytes.Repeat([]byte{1}, 17)
+
+	jsonBody, err := json.Marshal(SarcoFile{FileBytes: base64.StdEncoding.EncodeToString(file)})
+	assert.Nil(t, err)
+	w, r := uploadRequest(t, "application/json", jsonBody)
+	_, err = arch.readUpload(w, r)
+	assert.Equal(t, ErrFileTooLarge, err)
+
+	w, r = uploadRequest(t, "application/octet-stream", file)
+	_, err = arch.readUpload(w, r)
+	assert.Equal(t, ErrFileTooLarge, err)
+
+	// the body is capped even when the Content-Length is not sent
+	w, r = uploadRequest(t, "application/octet-stream", bytes.Repeat([]byte{1}, 2*UPLOAD_OVERHEAD))
+	r.ContentLength = -1
+	_, err = arch.readUpload(w, r)
+	assert.Equal(t, ErrFileTooLarge, err)
+
+	w, r = uploadRequest(t, "application/octet-stream", bytes.Repeat([]byte{1}, 2*UPLOAD_OVERHEAD))
+	_, err = arch.readUpload(w, r)
+	assert.Equal(t, ErrFileTooLarge, err)
+}
+
+func TestUploadedFileDoubleHash(t *testing.T) {
+	arch := &Archaeologist{MaxFileSize: 1024}
+	privateKey, err := crypto.GenerateKey()
+	assert.Nil(t, err)
+	decrypted := []byte("the sarcophagus payload")
+	encrypted, err := ecies.Encrypt(rand.Reader, ecies.ImportECDSAPublic(&privateKey.PublicKey), decrypted, nil, nil)
+	assert.Nil(t, err)
+
+	file, err := arch.receiveFile(bytes.NewReader(encrypted))
+	assert.Nil(t, err)
+	defer file.remove()
+
+	doubleHash, err := file.doubleHash(privateKey)
+	assert.Nil(t, err)
+	assert.Equal(t, utility.FileBytesToDoubleHashBytes(decrypted), doubleHash)
+
+	otherKey, err := crypto.GenerateKey()
+	assert.Nil(t, err)
+	_, err = file.doubleHash(otherKey)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,121 @@
+// Price provides the exchange rate of SARCO to AR, used to check a storage fee covers the cost of uploading a file to arweave
+// The rate is either a static value from the config file, or read from a local price source:
+// a file, or an http(s) url (e.g. a price feed run next to the service), whose content is the price of 1 SARCO in AR.
+// Prices read from a local source are cached for CACHE_DURATION.
+
+package price
+
+import (
+	"fmt"
+	"github.com/shopspring/decimal"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	CACHE_DURATION = time.Minute
+	MAX_PRICE_SIZE = 1024 // bytes read from a local price source
+)
+
+// Source returns the price of 1 SARCO in AR
+type Source interface {
+	Price() (decimal.Decimal, error)
+}
+
+// Static is a price set in the config file
+type Static struct {
+	price decimal.Decimal
+}
+
+// NewStatic returns an error if the price is not positive
+func NewStatic(price string) (*Static, error) {
+	parsed, err := parsePrice(price)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Static{parsed}, nil
+}
+
+func (s *Static) Price() (decimal.Decimal, error) {
+	return s.price, nil
+}
+
+// Local is a price read from a file or url
+type Local struct {
+	location string
+	client   *http.Client
+	now      func() time.Time
+
+	mu       sync.Mutex
+	price    decimal.Decimal
+	readTime time.Time
+}
+
+// NewLocal returns a price source for the file or http(s) url at location
+func NewLocal(location string) *Local {
+	return &Local{
+		location: location,
+		client:   &http.Client{Timeout: 5 * time.Second},
+		now:      time.Now,
+	}
+}
+
+func (l *Local) Price() (decimal.Decimal, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if !l.readTime.IsZero() && now.Sub(l.readTime) < CACHE_DURATION {
+		return l.price, nil
+	}
+
+	raw, err := l.read()
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("could not read the price from %v: %v", l.location, err)
+	}
+
+	price, err := parsePrice(raw)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("invalid price from %v: %v", l.location, err)
+	}
+
+	l.price = price
+	l.readTime = now
+	return price, nil
+}
+
+// read returns the content of the price source
+func (l *Local) read() (string, error) {
+	if !strings.HasPrefix(l.location, "http://") && !strings.HasPrefix(l.location, "https://") {
+		raw, err := ioutil.ReadFile(l.location)
+		return string(raw), err
+	}
+
+	resp, err := l.client.Get(l.location)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("status %v", resp.Status)
+	}
+
+	raw, err := ioutil.ReadAll(io.LimitReader(resp.Body, MAX_PRICE_SIZE))
+	return string(raw), err
+}
+
+// parsePrice .
+func parsePrice(price string) (decimal.Decimal, error) {
+	parsed, err := decimal.NewFromString(strings.TrimSpace(price))
+	if err != nil || !parsed.IsPositive() {
+		return decimal.Zero, fmt.Errorf("price must be a positive number, got: %v", strings.TrimSpace(price))
+	}
+
+	return parsed, nil
+}
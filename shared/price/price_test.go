@@ -0,0 +1,61 @@
+package price
+
+import (
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStatic(t *testing.T) {
+	s, err := NewStatic("0.05")
+	assert.Nil(t, err)
+	p, err := s.Price()
+	assert.Nil(t, err)
+	assert.True(t, decimal.RequireFromString("0.05").Equal(p))
+
+	_, err = NewStatic("0")
+	assert.NotNil(t, err)
+}
+
+func TestLocalFileIsCached(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "price")
+	assert.Nil(t, ioutil.WriteFile(file, []byte("0.05\n"), 0600))
+
+	now := time.Now()
+	l := NewLocal(file)
+	l.now = func() time.Time { return now }
+
+	p, err := l.Price()
+	assert.Nil(t, err)
+	assert.True(t, decimal.RequireFromString("0.05").Equal(p))
+
+	assert.Nil(t, ioutil.WriteFile(file, []byte("0.06"), 0600))
+	p, _ = l.Price()
+	assert.True(t, decimal.RequireFromString("0.05").Equal(p))
+
+	now = now.Add(CACHE_DURATION)
+	p, err = l.Price()
+	assert.Nil(t, err)
+	assert.True(t, decimal.RequireFromString("0.06").Equal(p))
+
+	assert.Nil(t, ioutil.WriteFile(file, []byte("unknown"), 0600))
+	now = now.Add(CACHE_DURATION)
+	_, err = l.Price()
+	assert.NotNil(t, err)
+}
+
+func TestLocalURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("0.07"))
+	}))
+	defer server.Close()
+
+	p, err := NewLocal(server.URL).Price()
+	assert.Nil(t, err)
+	assert.True(t, decimal.RequireFromString("0.07").Equal(p))
+}
@@ -0,0 +1,326 @@
+// Scheduler runs unwrap jobs for updated sarcophagi once their resurrection time has passed.
+// Jobs are saved to the store, so scheduled and failed unwraps survive a restart and can be listed by the operator.
+// A single worker runs one job at a time, in order of their next attempt.
+// What an attempt does, and when a failed attempt is retried, is decided by the Runner.
+
+package scheduler
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/store"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"log"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+)
+
+const jobsBucket = "unwrap_jobs"
+
+// Job is an unwrap of a single sarcophagus
+type Job struct {
+	Identifier         [32]byte `json:"-"` // the job is saved under the identifier
+	AssetId            string
+	ResurrectionTime   *big.Int
+	ResurrectionWindow *big.Int
+	KeyIndex           int // account index of the hd wallet key pair used to unwrap
+	Attempts           int
+	NextAttempt        time.Time
+	LastError          string
+	Failed             bool // no more attempts will be made
+
+	expedited bool // made due while it was running, so a failed attempt is retried straight away
+}
+
+// Deadline returns the end of the resurrection window, after which the sarcophagus can no longer be unwrapped
+func (j Job) Deadline() time.Time {
+	deadline := new(big.Int).Set(j.ResurrectionTime)
+	if j.ResurrectionWindow != nil {
+		deadline.Add(deadline, j.ResurrectionWindow)
+	}
+
+	return time.Unix(deadline.Int64(), 0)
+}
+
+// Runner attempts jobs for the scheduler
+type Runner interface {
+	// Run attempts the job. An error means the attempt failed.
+	Run(job Job) error
+	// Retry returns when to attempt a failed job again, or false if the job should not be retried
+	Retry(job Job) (time.Time, bool)
+}
+
+type Scheduler struct {
+	mu    sync.Mutex
+	store *store.Store
+	jobs  map[[32]byte]*Job
+	wake  chan struct{}
+	quit  chan struct{}
+}
+
+// New loads the saved jobs from the store
+func New(s *store.Store) (*Scheduler, error) {
+	jobs, err := LoadJobs(s)
+	if err != nil {
+		return nil, err
+	}
+
+	scheduler := &Scheduler{
+		store: s,
+		jobs:  map[[32]byte]*Job{},
+		wake:  make(chan struct{}, 1),
+		quit:  make(chan struct{}),
+	}
+
+	for i := range jobs {
+		scheduler.jobs[jobs[i].Identifier] = &jobs[i]
+	}
+
+	return scheduler, nil
+}
+
+// LoadJobs returns the jobs saved in the store, in order of their next attempt
+func LoadJobs(s *store.Store) ([]Job, error) {
+	var jobs []Job
+
+	err := s.ForEach(jobsBucket, func(key string, raw []byte) error {
+		var job Job
+		if err := json.Unmarshal(raw, &job); err != nil {
+			return fmt.Errorf("could not decode unwrap job %v: %v", key, err)
+		}
+
+		identifier, err := hexutil.Decode(key)
+		if err != nil || len(identifier) != 32 {
+			return fmt.Errorf("invalid unwrap job identifier: %v", key)
+		}
+		copy(job.Identifier[:], identifier)
+
+		jobs = append(jobs, job)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sortJobs(jobs)
+	return jobs, nil
+}
+
+// Start runs the worker until the service exits
+func (s *Scheduler) Start(runner Runner) {
+	go s.work(runner)
+}
+
+// Stop stops the worker once the job it is running (if any) has finished
+func (s *Scheduler) Stop() {
+	close(s.quit)
+}
+
+// Schedule adds the job, replacing any job for the same sarcophagus.
+// A job that is already scheduled for the same resurrection time is kept, so its attempts are not reset
+// when the same unwrap is scheduled again (e.g. on restart). Only its resurrection window is updated.
+func (s *Scheduler) Schedule(job Job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if current, ok := s.jobs[job.Identifier]; ok && current.ResurrectionTime.Cmp(job.ResurrectionTime) == 0 {
+		if job.ResurrectionWindow != nil && (current.ResurrectionWindow == nil || current.ResurrectionWindow.Cmp(job.ResurrectionWindow) != 0) {
+			current.ResurrectionWindow = job.ResurrectionWindow
+			s.save(current)
+		}
+		return
+	}
+
+	s.jobs[job.Identifier] = &job
+	s.save(&job)
+	s.notify()
+
+	log.Printf("Unwrap scheduled in: %v", time.Until(job.NextAttempt).Round(time.Second))
+}
+
+// Cancel removes the job for the sarcophagus
+// Returns false if there was no job.
+func (s *Scheduler) Cancel(identifier [32]byte) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.jobs[identifier]; !ok {
+		return false
+	}
+
+	s.delete(identifier)
+	s.notify()
+	return true
+}
+
+// Expedite makes the job for the sarcophagus due now, unless it has failed
+// Used when something the next attempt is waiting for (e.g. a transaction receipt) is ready.
+func (s *Scheduler) Expedite(identifier [32]byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[identifier]
+	if !ok || job.Failed {
+		return
+	}
+
+	job.NextAttempt = time.Now()
+	job.expedited = true
+	s.save(job)
+	s.notify()
+}
+
+// Jobs returns a copy of every job, in order of their next attempt
+func (s *Scheduler) Jobs() []Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	jobs := make([]Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, *job)
+	}
+
+	sortJobs(jobs)
+	return jobs
+}
+
+// work runs due jobs one at a time, sleeping until the next job is due or a job is scheduled
+func (s *Scheduler) work(runner Runner) {
+	for {
+		select {
+		case <-s.quit:
+			return
+		default:
+		}
+
+		job, wait := s.next()
+		if job == nil {
+			s.sleep(wait)
+			continue
+		}
+
+		err := runner.Run(s.copy(job))
+		s.finish(job, err, runner)
+	}
+}
+
+// copy returns a copy of the job, as Schedule may update the job while it runs
+func (s *Scheduler) copy(job *Job) Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job.expedited = false
+	return *job
+}
+
+// next returns the job that is due, or how long until the next job is due.
+// A negative wait means no job is scheduled.
+func (s *Scheduler) next() (*Job, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var earliest *Job
+	for _, job := range s.jobs {
+		if job.Failed {
+			continue
+		}
+		if earliest == nil || job.NextAttempt.Before(earliest.NextAttempt) {
+			earliest = job
+		}
+	}
+
+	if earliest == nil {
+		return nil, -1
+	}
+
+	wait := time.Until(earliest.NextAttempt)
+	if wait > 0 {
+		return nil, wait
+	}
+
+	return earliest, 0
+}
+
+// sleep waits for wait, or until a job is scheduled or cancelled or the scheduler is stopped
+func (s *Scheduler) sleep(wait time.Duration) {
+	if wait < 0 {
+		select {
+		case <-s.wake:
+		case <-s.quit:
+		}
+		return
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-s.wake:
+	case <-s.quit:
+	}
+}
+
+// finish removes a successful job, or records the failure and when to retry.
+// If the job was replaced or cancelled while it ran, the result is ignored.
+func (s *Scheduler) finish(job *Job, err error, runner Runner) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.jobs[job.Identifier] != job {
+		return
+	}
+
+	if err == nil {
+		s.delete(job.Identifier)
+		return
+	}
+
+	job.Attempts += 1
+	job.LastError = err.Error()
+
+	if next, ok := runner.Retry(*job); ok {
+		if job.expedited {
+			next = time.Now()
+		}
+		job.NextAttempt = next
+		log.Printf("Unwrap attempt %v failed: %v. Retrying in %v", job.Attempts, err, time.Until(next).Round(time.Second))
+	} else {
+		job.Failed = true
+		log.Printf("Unwrap attempt %v failed: %v. No more attempts will be made", job.Attempts, err)
+	}
+
+	s.save(job)
+}
+
+// save writes the job to the store, must be called with the lock held
+func (s *Scheduler) save(job *Job) {
+	if err := s.store.Put(jobsBucket, hexutil.Encode(job.Identifier[:]), job); err != nil {
+		log.Printf("Error saving unwrap job to %v: %v", s.store.Path(), err)
+	}
+}
+
+// delete removes the job, must be called with the lock held
+func (s *Scheduler) delete(identifier [32]byte) {
+	delete(s.jobs, identifier)
+	if err := s.store.Delete(jobsBucket, hexutil.Encode(identifier[:])); err != nil {
+		log.Printf("Error removing unwrap job from %v: %v", s.store.Path(), err)
+	}
+}
+
+// notify wakes the worker so it picks up a change to the jobs
+func (s *Scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// sortJobs orders jobs by their next attempt
+func sortJobs(jobs []Job) {
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].NextAttempt.Before(jobs[j].NextAttempt)
+	})
+}
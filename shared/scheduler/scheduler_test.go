@@ -0,0 +1,171 @@
+package scheduler
+
+import (
+	"errors"
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/store"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testRunner struct {
+	err     error
+	retries int
+	runs    chan Job
+}
+
+func newTestRunner(err error, retries int) *testRunner {
+	return &testRunner{err: err, retries: retries, runs: make(chan Job, 10)}
+}
+
+func (r *testRunner) Run(job Job) error {
+	r.runs <- job
+	return r.err
+}
+
+func (r *testRunner) Retry(job Job) (time.Time, bool) {
+	if job.Attempts > r.retries {
+		return time.Time{}, false
+	}
+	return time.Now(), true
+}
+
+func testScheduler(t *testing.T) (*Scheduler, *store.Store) {
+	s, err := store.Open(filepath.Join(t.TempDir(), "state.json"))
+	assert.Nil(t, err)
+
+	scheduler, err := New(s)
+	assert.Nil(t, err)
+	t.Cleanup(scheduler.Stop)
+
+	return scheduler, s
+}
+
+func waitForRun(t *testing.T, runner *testRunner) Job {
+	select {
+	case job := <-runner.runs:
+		return job
+	case <-time.After(5 * time.Second):
+		t.Fatal("job was not run")
+	}
+	return Job{}
+}
+
+// waitFor polls until the condition holds, as the result of a run is recorded after Run returns
+func waitFor(t *testing.T, condition func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition was not met")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSuccessfulJobIsRemoved(t *testing.T) {
+	scheduler, s := testScheduler(t)
+	runner := newTestRunner(nil, 0)
+	scheduler.Start(runner)
+
+	scheduler.Schedule(Job{Identifier: [32]byte{1}, AssetId: "asset", ResurrectionTime: big.NewInt(1), KeyIndex: 2, NextAttempt: time.Now()})
+
+	job := waitForRun(t, runner)
+	assert.Equal(t, "asset", job.AssetId)
+	assert.Equal(t, 2, job.KeyIndex)
+
+	waitFor(t, func() bool { return len(scheduler.Jobs()) == 0 })
+	jobs, err := LoadJobs(s)
+	assert.Nil(t, err)
+	assert.Len(t, jobs, 0)
+}
+
+func TestFailedJobIsRetriedThenMarkedFailed(t *testing.T) {
+	scheduler, s := testScheduler(t)
+	runner := newTestRunner(errors.New("unwrap reverted"), 1)
+	scheduler.Start(runner)
+
+	scheduler.Schedule(Job{Identifier: [32]byte{1}, ResurrectionTime: big.NewInt(1), NextAttempt: time.Now()})
+
+	waitForRun(t, runner)
+	waitForRun(t, runner)
+	waitFor(t, func() bool {
+		jobs := scheduler.Jobs()
+		return len(jobs) == 1 && jobs[0].Failed
+	})
+
+	// the failure survives a restart
+	jobs, err := LoadJobs(s)
+	assert.Nil(t, err)
+	assert.Len(t, jobs, 1)
+	assert.Equal(t, [32]byte{1}, jobs[0].Identifier)
+	assert.Equal(t, 2, jobs[0].Attempts)
+	assert.Equal(t, "unwrap reverted", jobs[0].LastError)
+	assert.True(t, jobs[0].Failed)
+
+	// failed jobs are not attempted again
+	select {
+	case <-runner.runs:
+		t.Fatal("failed job was run")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestScheduleAndCancel(t *testing.T) {
+	scheduler, s := testScheduler(t)
+	runner := newTestRunner(nil, 0)
+	scheduler.Start(runner)
+
+	later := time.Now().Add(time.Hour)
+	scheduler.Schedule(Job{Identifier: [32]byte{1}, ResurrectionTime: big.NewInt(1), NextAttempt: later})
+
+	// scheduling the same resurrection time again keeps the existing job
+	scheduler.Schedule(Job{Identifier: [32]byte{1}, ResurrectionTime: big.NewInt(1), NextAttempt: time.Now()})
+	assert.True(t, scheduler.Jobs()[0].NextAttempt.Equal(later))
+
+	// a rewrap replaces it
+	scheduler.Schedule(Job{Identifier: [32]byte{1}, ResurrectionTime: big.NewInt(2), NextAttempt: later.Add(time.Hour)})
+	assert.Equal(t, big.NewInt(2), scheduler.Jobs()[0].ResurrectionTime)
+
+	// a restart loads the saved job
+	restarted, err := New(s)
+	assert.Nil(t, err)
+	assert.Len(t, restarted.Jobs(), 1)
+
+	assert.True(t, scheduler.Cancel([32]byte{1}))
+	assert.False(t, scheduler.Cancel([32]byte{1}))
+	assert.Len(t, scheduler.Jobs(), 0)
+
+	jobs, err := LoadJobs(s)
+	assert.Nil(t, err)
+	assert.Len(t, jobs, 0)
+}
+
+func TestExpedite(t *testing.T) {
+	scheduler, _ := testScheduler(t)
+	identifier := [32]byte{1}
+
+	scheduler.Schedule(Job{Identifier: identifier, ResurrectionTime: big.NewInt(1), NextAttempt: time.Now().Add(time.Hour)})
+	scheduler.Expedite(identifier)
+
+	jobs := scheduler.Jobs()
+	assert.Len(t, jobs, 1)
+	assert.False(t, jobs[0].NextAttempt.After(time.Now()))
+
+	// a job expedited while it runs is retried straight away if the attempt fails
+	job, _ := scheduler.next()
+	scheduler.copy(job)
+	scheduler.Expedite(identifier)
+	scheduler.finish(job, errors.New("transaction pending"), &slowRetryRunner{newTestRunner(nil, 0)})
+	assert.False(t, scheduler.Jobs()[0].NextAttempt.After(time.Now()))
+}
+
+// slowRetryRunner retries failed jobs after an hour
+type slowRetryRunner struct {
+	*testRunner
+}
+
+func (r *slowRetryRunner) Retry(job Job) (time.Time, bool) {
+	return time.Now().Add(time.Hour), true
+}
@@ -0,0 +1,230 @@
+// Store is a small embedded key/value store used to persist archaeologist state between restarts.
+// Values are grouped into buckets and the whole store is kept in a single JSON file.
+// Every write is flushed to disk by writing a temporary file and renaming it over the old one,
+// so the file on disk always holds either the previous or the new state, never a partial one.
+
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+type Store struct {
+	path    string
+	mu      sync.RWMutex
+	buckets map[string]map[string]json.RawMessage
+}
+
+// Batch collects writes that are applied to the store together in Update
+type Batch struct {
+	buckets map[string]map[string]json.RawMessage
+}
+
+// Open loads the store from the file at path.
+// If the file does not exist, an empty store is returned and the file is created on the first write.
+func Open(path string) (*Store, error) {
+	s := &Store{
+		path:    path,
+		buckets: map[string]map[string]json.RawMessage{},
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, fmt.Errorf("could not read state file %v: %v", path, err)
+	}
+
+	if len(data) == 0 {
+		return s, nil
+	}
+
+	if err := json.Unmarshal(data, &s.buckets); err != nil {
+		return nil, fmt.Errorf("could not parse state file %v: %v", path, err)
+	}
+
+	return s, nil
+}
+
+// Path returns the location of the store file
+func (s *Store) Path() string {
+	return s.path
+}
+
+// IsEmpty returns true if nothing has been written to the store yet
+func (s *Store) IsEmpty() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.buckets) == 0
+}
+
+// Get decodes the value stored under key into value.
+// Returns false if the key does not exist in the bucket.
+func (s *Store) Get(bucket string, key string, value interface{}) (bool, error) {
+	s.mu.RLock()
+	raw, ok := s.buckets[bucket][key]
+	s.mu.RUnlock()
+
+	if !ok {
+		return false, nil
+	}
+
+	if err := json.Unmarshal(raw, value); err != nil {
+		return true, fmt.Errorf("could not decode %v/%v: %v", bucket, key, err)
+	}
+
+	return true, nil
+}
+
+// Keys returns the sorted keys of a bucket
+func (s *Store) Keys(bucket string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]string, 0, len(s.buckets[bucket]))
+	for key := range s.buckets[bucket] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// ForEach calls fn for every key in the bucket in key order.
+// Iteration stops at the first error returned by fn.
+func (s *Store) ForEach(bucket string, fn func(key string, raw []byte) error) error {
+	s.mu.RLock()
+	values := make(map[string]json.RawMessage, len(s.buckets[bucket]))
+	for key, raw := range s.buckets[bucket] {
+		values[key] = raw
+	}
+	s.mu.RUnlock()
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if err := fn(key, values[key]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Put stores value under key and flushes the store to disk
+func (s *Store) Put(bucket string, key string, value interface{}) error {
+	return s.Update(func(b *Batch) error {
+		return b.Put(bucket, key, value)
+	})
+}
+
+// Delete removes key from the bucket and flushes the store to disk
+func (s *Store) Delete(bucket string, key string) error {
+	return s.Update(func(b *Batch) error {
+		b.Delete(bucket, key)
+		return nil
+	})
+}
+
+// Update applies all writes made by fn in a single flush.
+// If fn returns an error, none of its writes are applied.
+func (s *Store) Update(fn func(b *Batch) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := &Batch{buckets: copyBuckets(s.buckets)}
+	if err := fn(b); err != nil {
+		return err
+	}
+
+	if err := s.flush(b.buckets); err != nil {
+		return err
+	}
+
+	s.buckets = b.buckets
+	return nil
+}
+
+// Put stores value under key in the batch
+func (b *Batch) Put(bucket string, key string, value interface{}) error {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("could not encode %v/%v: %v", bucket, key, err)
+	}
+
+	if _, ok := b.buckets[bucket]; !ok {
+		b.buckets[bucket] = map[string]json.RawMessage{}
+	}
+	b.buckets[bucket][key] = raw
+
+	return nil
+}
+
+// Delete removes key from the bucket in the batch
+func (b *Batch) Delete(bucket string, key string) {
+	delete(b.buckets[bucket], key)
+}
+
+// Clear removes every key in the bucket in the batch
+func (b *Batch) Clear(bucket string) {
+	b.buckets[bucket] = map[string]json.RawMessage{}
+}
+
+// flush writes the buckets to a temporary file and renames it over the store file
+func (s *Store) flush(buckets map[string]map[string]json.RawMessage) error {
+	data, err := json.MarshalIndent(buckets, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode state: %v", err)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("could not create temporary state file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not write state file: %v", err)
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not sync state file: %v", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("could not close state file: %v", err)
+	}
+
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("could not replace state file: %v", err)
+	}
+
+	return nil
+}
+
+// copyBuckets makes a copy of the bucket maps so a batch can be discarded without touching the store
+func copyBuckets(buckets map[string]map[string]json.RawMessage) map[string]map[string]json.RawMessage {
+	copied := make(map[string]map[string]json.RawMessage, len(buckets))
+	for bucket, values := range buckets {
+		copied[bucket] = make(map[string]json.RawMessage, len(values))
+		for key, raw := range values {
+			copied[bucket][key] = raw
+		}
+	}
+
+	return copied
+}
@@ -0,0 +1,81 @@
+package store
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func tempStorePath(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "arch-store")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	return filepath.Join(dir, "state.json")
+}
+
+func TestStorePersistsAcrossOpen(t *testing.T) {
+	path := tempStorePath(t)
+
+	s, err := Open(path)
+	assert.Nil(t, err)
+	assert.True(t, s.IsEmpty())
+
+	assert.Nil(t, s.Put("meta", "account_index", 3))
+	assert.Nil(t, s.Put("sarcophagi", "0x01", map[string]int{"AccountIndex": 2}))
+
+	reopened, err := Open(path)
+	assert.Nil(t, err)
+	assert.False(t, reopened.IsEmpty())
+
+	var index int
+	ok, err := reopened.Get("meta", "account_index", &index)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 3, index)
+
+	assert.Equal(t, []string{"0x01"}, reopened.Keys("sarcophagi"))
+}
+
+func TestStoreUpdateIsAtomic(t *testing.T) {
+	path := tempStorePath(t)
+
+	s, err := Open(path)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Put("meta", "account_index", 1))
+
+	err = s.Update(func(b *Batch) error {
+		b.Put("meta", "account_index", 2)
+		b.Clear("meta")
+		return errors.New("failed")
+	})
+	assert.NotNil(t, err)
+
+	var index int
+	ok, _ := s.Get("meta", "account_index", &index)
+	assert.True(t, ok)
+	assert.Equal(t, 1, index)
+
+	reopened, _ := Open(path)
+	ok, _ = reopened.Get("meta", "account_index", &index)
+	assert.True(t, ok)
+	assert.Equal(t, 1, index)
+}
+
+func TestStoreDelete(t *testing.T) {
+	s, err := Open(tempStorePath(t))
+	assert.Nil(t, err)
+
+	assert.Nil(t, s.Put("file_handlers", "0x01", "100"))
+	assert.Nil(t, s.Delete("file_handlers", "0x01"))
+
+	var fee string
+	ok, err := s.Get("file_handlers", "0x01", &fee)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+}
@@ -0,0 +1,593 @@
+// Manager sends every transaction the archaeologist makes from its eth account.
+// The gas price of each transaction is set by the gas strategy.
+// Nonces are assigned locally and submissions are serialized, so transactions sent at the same time
+// (e.g. several unwraps) do not collide on the nonce of the pending state.
+// Submitted transactions are saved to the store until they are mined, so they are still tracked after a restart.
+// A monitor checks each pending transaction for a receipt, and resubmits transactions the node has dropped.
+// Transactions stuck in the mempool are replaced with a transaction of the same nonce and bumped fees.
+// Transactions with a deadline (e.g. unwraps, which must be mined before the resurrection window closes)
+// are bumped more often and by more as the deadline approaches.
+// Callers wait for their transaction to be mined and confirmed with Wait, which returns the typed errors of the ethereum package.
+
+package txmanager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	eth "github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/ethereum"
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/gas"
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/store"
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/utility"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	"log"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	transactionsBucket     = "transactions"
+	MONITOR_INTERVAL       = 3 * time.Second // how often pending transactions are checked for a receipt
+	BUMP_INTERVAL          = 3 * time.Minute // how long a transaction is pending before it is replaced with bumped fees
+	BUMP_PERCENT           = 15              // fee increase of a replacement
+	URGENT_BUMP_INTERVAL   = 1 * time.Minute // used when less than half of the time until the deadline is left
+	URGENT_BUMP_PERCENT    = 25
+	CRITICAL_BUMP_INTERVAL = 15 * time.Second // used when less than a quarter of the time until the deadline is left
+	CRITICAL_BUMP_PERCENT  = 50
+)
+
+// Backend is the part of the eth client used by the manager
+type Backend interface {
+	eth.ReceiptBackend
+	gas.Backend
+	PendingNonceAt(ctx context.Context, account common.Address) (uint64, error)
+	NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error)
+	SendTransaction(ctx context.Context, tx *types.Transaction) error
+}
+
+// Transaction is a submitted transaction that has not been mined yet
+type Transaction struct {
+	Hash       common.Hash `json:"-"` // the transaction is saved under the hash it was first submitted with
+	Label      string
+	Nonce      uint64
+	Raw        hexutil.Bytes // latest signed transaction, used to resubmit and replace it
+	Hashes     []common.Hash // hashes of the transaction and each of its replacements, any of which may be mined
+	Submitted  time.Time
+	Deadline   time.Time // when the transaction must be mined by, zero if there is no deadline
+	LastSent   time.Time // when the latest replacement was sent
+	AtMaxPrice bool      // the fees cannot be bumped any further
+}
+
+type result struct {
+	receipt *types.Receipt
+	err     error
+}
+
+type Manager struct {
+	backend       Backend
+	store         *store.Store
+	opts          bind.TransactOpts
+	gas           *gas.Strategy
+	confirmations uint64
+	timeout       time.Duration
+
+	submitMu sync.Mutex // held while a transaction is built, signed and sent
+	nonce    uint64
+
+	mu      sync.Mutex
+	pending map[common.Hash]*Transaction
+	waiters map[common.Hash][]chan result
+	mined   map[common.Hash]common.Hash // hash a resolved transaction was mined with, by the hash it was submitted with
+
+	quit chan struct{}
+}
+
+// New loads the pending transactions saved in the store
+// opts are used for every transaction, with the nonce set by the manager and the fees set by the gas strategy.
+// Wait waits for the number of confirmations, and gives up after timeout (0 waits until the transaction is mined).
+func New(backend Backend, s *store.Store, opts bind.TransactOpts, strategy *gas.Strategy, confirmations uint64, timeout time.Duration) (*Manager, error) {
+	m := &Manager{
+		backend:       backend,
+		store:         s,
+		opts:          opts,
+		gas:           strategy,
+		confirmations: confirmations,
+		timeout:       timeout,
+		pending:       map[common.Hash]*Transaction{},
+		waiters:       map[common.Hash][]chan result{},
+		mined:         map[common.Hash]common.Hash{},
+		quit:          make(chan struct{}),
+	}
+
+	err := s.ForEach(transactionsBucket, func(key string, raw []byte) error {
+		var transaction Transaction
+		if err := json.Unmarshal(raw, &transaction); err != nil {
+			return fmt.Errorf("could not decode transaction %v: %v", key, err)
+		}
+		transaction.Hash = common.HexToHash(key)
+		if len(transaction.Hashes) == 0 {
+			transaction.Hashes = []common.Hash{transaction.Hash}
+		}
+		m.pending[transaction.Hash] = &transaction
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.syncNonce(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// client .
+func (m *Manager) client() Backend {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.backend
+}
+
+// From returns the address transactions are sent from
+func (m *Manager) From() common.Address {
+	return m.opts.From
+}
+
+// Fees returns the fees the gas strategy would use for a transaction sent now
+func (m *Manager) Fees(ctx context.Context) (gas.Fees, error) {
+	return m.gas.Fees(ctx, m.client())
+}
+
+// Start runs the monitor until the service exits
+func (m *Manager) Start() {
+	go m.monitor()
+}
+
+// Stop stops the monitor
+func (m *Manager) Stop() {
+	close(m.quit)
+}
+
+// Submit sends the transaction built by send, using the next nonce
+// send must pass the opts it is given to the contract binding (e.g. sarcoContract.UnwrapSarcophagus(opts, ...))
+// If the nonce has been used by a transaction sent outside of the manager, the nonce is synced and the transaction sent again.
+func (m *Manager) Submit(label string, send func(opts *bind.TransactOpts) (*types.Transaction, error)) (*types.Transaction, error) {
+	return m.SubmitBefore(label, time.Time{}, send)
+}
+
+// SubmitBefore sends the transaction like Submit, bumping its fees more aggressively as the deadline approaches
+func (m *Manager) SubmitBefore(label string, deadline time.Time, send func(opts *bind.TransactOpts) (*types.Transaction, error)) (*types.Transaction, error) {
+	m.submitMu.Lock()
+	defer m.submitMu.Unlock()
+
+	fees, err := m.gas.Fees(context.Background(), m.client())
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 0; attempt < 2; attempt++ {
+		var signed *types.Transaction
+
+		opts := m.opts
+		opts.Nonce = new(big.Int).SetUint64(m.nonce)
+		fees.Apply(&opts)
+		opts.Signer = func(address common.Address, tx *types.Transaction) (*types.Transaction, error) {
+			signedTx, err := m.opts.Signer(address, tx)
+			if err != nil {
+				return nil, err
+			}
+
+			// The transaction is saved before it is sent, so it is tracked even if the service stops right after
+			signed = signedTx
+			return signedTx, m.track(label, deadline, signedTx)
+		}
+
+		var tx *types.Transaction
+		tx, err = send(&opts)
+		if err == nil {
+			m.nonce += 1
+			log.Printf("%v transaction %v sent with nonce %v, %v", label, tx.Hash().Hex(), tx.Nonce(), fees)
+			return tx, nil
+		}
+
+		if signed != nil {
+			m.untrack(signed.Hash())
+		}
+
+		if !isNonceError(err) {
+			return nil, err
+		}
+
+		log.Printf("Nonce %v has already been used, syncing nonce with the eth node", m.nonce)
+		if syncErr := m.syncNonce(); syncErr != nil {
+			return nil, syncErr
+		}
+	}
+
+	return nil, err
+}
+
+// Wait waits until the transaction, or one of its replacements, is mined and confirmed
+// Returns eth.ErrReverted if the transaction failed, eth.ErrDropped if it was replaced by another transaction
+// with the same nonce, or eth.ErrTimedOut if it is not confirmed within the timeout.
+func (m *Manager) Wait(ctx context.Context, hash common.Hash) (*types.Receipt, error) {
+	if m.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.timeout)
+		defer cancel()
+	}
+
+	label := hash.Hex()
+	var done chan result
+
+	m.mu.Lock()
+	if transaction, ok := m.pending[hash]; ok {
+		label = transaction.Label
+		done = make(chan result, 1)
+		m.waiters[hash] = append(m.waiters[hash], done)
+	} else if minedHash, ok := m.mined[hash]; ok {
+		hash = minedHash
+	}
+	m.mu.Unlock()
+
+	// Wait for the monitor while the transaction may still need to be resubmitted
+	if done != nil {
+		select {
+		case res := <-done:
+			if res.err != nil {
+				return res.receipt, res.err
+			}
+			hash = res.receipt.TxHash
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				return nil, eth.ErrTimedOut
+			}
+			return nil, ctx.Err()
+		}
+	}
+
+	return eth.WaitMined(ctx, m.client(), hash, label, m.confirmations)
+}
+
+// Pending returns the transactions that have not been mined yet, in nonce order
+func (m *Manager) Pending() []Transaction {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	transactions := make([]Transaction, 0, len(m.pending))
+	for _, transaction := range m.pending {
+		copied := *transaction
+		copied.Hashes = append([]common.Hash(nil), transaction.Hashes...)
+		transactions = append(transactions, copied)
+	}
+
+	sort.Slice(transactions, func(i, j int) bool {
+		return transactions[i].Nonce < transactions[j].Nonce
+	})
+
+	return transactions
+}
+
+// syncNonce sets the next nonce from the eth node's pending state, unless a saved pending transaction uses a later nonce
+func (m *Manager) syncNonce() error {
+	nonce, err := m.client().PendingNonceAt(context.Background(), m.opts.From)
+	if err != nil {
+		return fmt.Errorf("could not get the account nonce from the eth node: %v", err)
+	}
+
+	m.mu.Lock()
+	for _, transaction := range m.pending {
+		if transaction.Nonce >= nonce {
+			nonce = transaction.Nonce + 1
+		}
+	}
+	m.mu.Unlock()
+
+	m.nonce = nonce
+	return nil
+}
+
+// monitor checks the pending transactions until the manager is stopped
+func (m *Manager) monitor() {
+	ticker := time.NewTicker(MONITOR_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.quit:
+			return
+		case <-ticker.C:
+			m.checkPending()
+		}
+	}
+}
+
+// checkPending resolves mined transactions, resubmits transactions that are no longer known to the eth node,
+// and replaces transactions that have been pending for too long
+func (m *Manager) checkPending() {
+	for _, transaction := range m.Pending() {
+		receipt, err := m.receipt(transaction)
+		if err != nil {
+			log.Printf("Error getting the receipt of the %v transaction %v: %v", transaction.Label, transaction.Hash.Hex(), err)
+			continue
+		}
+		if receipt != nil {
+			m.resolve(transaction.Hash, receipt, receiptError(receipt))
+			continue
+		}
+
+		latest := transaction.Hashes[len(transaction.Hashes)-1]
+		if _, _, err := m.client().TransactionByHash(context.Background(), latest); err != ethereum.NotFound {
+			if err == nil {
+				m.bump(transaction)
+			}
+			// otherwise the node could not be reached
+			continue
+		}
+
+		// The transaction is not known to the node, either it was dropped from the pool or its nonce has been used
+		nonce, err := m.client().NonceAt(context.Background(), m.opts.From, nil)
+		if err != nil {
+			log.Printf("Error getting the account nonce from the eth node: %v", err)
+			continue
+		}
+
+		if nonce > transaction.Nonce {
+			// one of the replacements may have been mined since the receipts were checked
+			if receipt, err := m.receipt(transaction); err == nil && receipt != nil {
+				m.resolve(transaction.Hash, receipt, receiptError(receipt))
+				continue
+			}
+			m.resolve(transaction.Hash, nil, eth.ErrDropped)
+			continue
+		}
+
+		tx := new(types.Transaction)
+		if err := rlp.DecodeBytes(transaction.Raw, tx); err != nil {
+			log.Printf("Could not decode the %v transaction %v: %v", transaction.Label, latest.Hex(), err)
+			continue
+		}
+
+		log.Printf("%v transaction %v was dropped by the eth node, resubmitting", transaction.Label, latest.Hex())
+		if err := m.client().SendTransaction(context.Background(), tx); err != nil {
+			log.Printf("Error resubmitting the %v transaction %v: %v", transaction.Label, latest.Hex(), err)
+		}
+	}
+}
+
+// receipt returns the receipt of the transaction or whichever of its replacements was mined, nil if none was
+func (m *Manager) receipt(transaction Transaction) (*types.Receipt, error) {
+	for _, hash := range transaction.Hashes {
+		receipt, err := m.client().TransactionReceipt(context.Background(), hash)
+		if err == nil {
+			return receipt, nil
+		}
+		if err != ethereum.NotFound {
+			return nil, err
+		}
+	}
+
+	return nil, nil
+}
+
+// bump replaces the transaction with one of the same nonce and higher fees, if it has been pending long enough
+func (m *Manager) bump(transaction Transaction) {
+	now := time.Now()
+	if !transaction.Deadline.IsZero() && now.After(transaction.Deadline) {
+		// the transaction can no longer succeed, there is no point paying more for it
+		return
+	}
+
+	interval, percent := bumpSchedule(transaction, now)
+	if now.Sub(transaction.LastSent) < interval {
+		return
+	}
+
+	tx := new(types.Transaction)
+	if err := rlp.DecodeBytes(transaction.Raw, tx); err != nil {
+		log.Printf("Could not decode the %v transaction %v: %v", transaction.Label, transaction.Hash.Hex(), err)
+		return
+	}
+
+	fees, ok, err := m.gas.Bump(context.Background(), m.client(), gas.TxFees(tx), percent)
+	if err != nil {
+		log.Printf("Could not bump the fees of the %v transaction %v: %v", transaction.Label, transaction.Hash.Hex(), err)
+		return
+	}
+	if !ok {
+		if !transaction.AtMaxPrice {
+			m.update(transaction.Hash, func(pending *Transaction) {
+				pending.AtMaxPrice = true
+			})
+			if transaction.Deadline.IsZero() {
+				log.Printf("%v transaction %v is stuck at the max gas price (%v), its fees will not be bumped", transaction.Label, transaction.Hash.Hex(), gas.TxFees(tx))
+			} else {
+				utility.Alert("%v transaction %v is stuck at the max gas price (%v) and must be mined by %v. Increase MAX_GAS_PRICE to let the service bump its fees", transaction.Label, transaction.Hash.Hex(), gas.TxFees(tx), transaction.Deadline.Format(time.RFC1123))
+			}
+		}
+		return
+	}
+
+	signed, err := m.opts.Signer(m.opts.From, replacement(tx, fees))
+	if err != nil {
+		log.Printf("Could not sign the replacement of the %v transaction %v: %v", transaction.Label, transaction.Hash.Hex(), err)
+		return
+	}
+	raw, err := rlp.EncodeToBytes(signed)
+	if err != nil {
+		log.Printf("Could not encode the replacement of the %v transaction %v: %v", transaction.Label, transaction.Hash.Hex(), err)
+		return
+	}
+
+	// The replacement is saved before it is sent, so it is checked for a receipt even if the service stops right after
+	m.update(transaction.Hash, func(pending *Transaction) {
+		pending.Raw = raw
+		pending.Hashes = append(pending.Hashes, signed.Hash())
+		pending.LastSent = now
+	})
+
+	if err := m.client().SendTransaction(context.Background(), signed); err != nil && !strings.Contains(err.Error(), "already known") {
+		// e.g. the node requires a higher price bump, or the transaction was mined in the meantime
+		log.Printf("Error sending the replacement of the %v transaction %v: %v", transaction.Label, transaction.Hash.Hex(), err)
+		m.update(transaction.Hash, func(pending *Transaction) {
+			pending.Raw = transaction.Raw
+			pending.Hashes = transaction.Hashes
+		})
+		return
+	}
+
+	log.Printf("%v transaction %v has been pending since %v, replaced by %v with %v", transaction.Label, transaction.Hash.Hex(), transaction.Submitted.Format(time.RFC1123), signed.Hash().Hex(), fees)
+}
+
+// bumpSchedule returns how long to wait between replacements and by how much to bump the fees.
+// Transactions with a deadline are bumped more aggressively as it approaches.
+func bumpSchedule(transaction Transaction, now time.Time) (time.Duration, int64) {
+	if transaction.Deadline.IsZero() {
+		return BUMP_INTERVAL, BUMP_PERCENT
+	}
+
+	total := transaction.Deadline.Sub(transaction.Submitted)
+	remaining := transaction.Deadline.Sub(now)
+
+	switch {
+	case remaining < total/4:
+		return CRITICAL_BUMP_INTERVAL, CRITICAL_BUMP_PERCENT
+	case remaining < total/2:
+		return URGENT_BUMP_INTERVAL, URGENT_BUMP_PERCENT
+	default:
+		return BUMP_INTERVAL, BUMP_PERCENT
+	}
+}
+
+// replacement returns an unsigned copy of the transaction with new fees
+func replacement(tx *types.Transaction, fees gas.Fees) *types.Transaction {
+	if fees.GasPrice != nil {
+		return types.NewTx(&types.LegacyTx{
+			Nonce:    tx.Nonce(),
+			GasPrice: fees.GasPrice,
+			Gas:      tx.Gas(),
+			To:       tx.To(),
+			Value:    tx.Value(),
+			Data:     tx.Data(),
+		})
+	}
+
+	return types.NewTx(&types.DynamicFeeTx{
+		ChainID:    tx.ChainId(),
+		Nonce:      tx.Nonce(),
+		GasTipCap:  fees.GasTipCap,
+		GasFeeCap:  fees.GasFeeCap,
+		Gas:        tx.Gas(),
+		To:         tx.To(),
+		Value:      tx.Value(),
+		Data:       tx.Data(),
+		AccessList: tx.AccessList(),
+	})
+}
+
+// resolve removes the transaction and passes the result to anyone waiting for it
+func (m *Manager) resolve(hash common.Hash, receipt *types.Receipt, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	transaction, ok := m.pending[hash]
+	if !ok {
+		return
+	}
+
+	if err != nil {
+		log.Printf("%v transaction %v failed: %v", transaction.Label, hash.Hex(), err)
+	}
+
+	delete(m.pending, hash)
+	if storeErr := m.store.Delete(transactionsBucket, hash.Hex()); storeErr != nil {
+		log.Printf("Error removing transaction from %v: %v", m.store.Path(), storeErr)
+	}
+
+	if receipt != nil && receipt.TxHash != hash {
+		m.mined[hash] = receipt.TxHash
+	}
+
+	for _, done := range m.waiters[hash] {
+		done <- result{receipt, err}
+	}
+	delete(m.waiters, hash)
+}
+
+// track saves a signed transaction as pending
+func (m *Manager) track(label string, deadline time.Time, tx *types.Transaction) error {
+	raw, err := rlp.EncodeToBytes(tx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	transaction := &Transaction{
+		Hash:      tx.Hash(),
+		Label:     label,
+		Nonce:     tx.Nonce(),
+		Raw:       raw,
+		Hashes:    []common.Hash{tx.Hash()},
+		Submitted: now,
+		Deadline:  deadline,
+		LastSent:  now,
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.pending[transaction.Hash] = transaction
+	return m.store.Put(transactionsBucket, transaction.Hash.Hex(), transaction)
+}
+
+// update changes a pending transaction and saves it
+func (m *Manager) update(hash common.Hash, change func(transaction *Transaction)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	transaction, ok := m.pending[hash]
+	if !ok {
+		return
+	}
+
+	change(transaction)
+	if err := m.store.Put(transactionsBucket, hash.Hex(), transaction); err != nil {
+		log.Printf("Error saving transaction to %v: %v", m.store.Path(), err)
+	}
+}
+
+// untrack removes a transaction that could not be sent
+func (m *Manager) untrack(hash common.Hash) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.pending, hash)
+	if err := m.store.Delete(transactionsBucket, hash.Hex()); err != nil {
+		log.Printf("Error removing transaction from %v: %v", m.store.Path(), err)
+	}
+}
+
+// receiptError returns eth.ErrReverted if the transaction failed
+func receiptError(receipt *types.Receipt) error {
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		return eth.ErrReverted
+	}
+
+	return nil
+}
+
+// isNonceError returns true if the eth node rejected the transaction because its nonce has been used
+func isNonceError(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "nonce too low") || strings.Contains(msg, "replacement transaction underpriced")
+}
@@ -0,0 +1,340 @@
+package txmanager
+
+import (
+	"context"
+	"errors"
+	eth "github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/ethereum"
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/gas"
+	"github.com/decent-labs/airfoil-sarcophagus-archaeologist-service/shared/store"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeBackend is an eth node with a transaction pool that only mines when told to
+type fakeBackend struct {
+	mu       sync.Mutex
+	nonce    uint64 // nonce of the latest block
+	pool     map[common.Hash]*types.Transaction
+	receipts map[common.Hash]*types.Receipt
+	sent     []*types.Transaction
+	sendErr  error
+}
+
+func newFakeBackend() *fakeBackend {
+	return &fakeBackend{
+		pool:     map[common.Hash]*types.Transaction{},
+		receipts: map[common.Hash]*types.Receipt{},
+	}
+}
+
+func (b *fakeBackend) PendingNonceAt(ctx context.Context, account common.Address) (uint64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.nonce + uint64(len(b.pool)), nil
+}
+
+func (b *fakeBackend) BlockNumber(ctx context.Context) (uint64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.nonce, nil
+}
+
+func (b *fakeBackend) NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.nonce, nil
+}
+
+func (b *fakeBackend) TransactionByHash(ctx context.Context, hash common.Hash) (*types.Transaction, bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if tx, ok := b.pool[hash]; ok {
+		return tx, true, nil
+	}
+	return nil, false, ethereum.NotFound
+}
+
+func (b *fakeBackend) TransactionReceipt(ctx context.Context, hash common.Hash) (*types.Receipt, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if receipt, ok := b.receipts[hash]; ok {
+		return receipt, nil
+	}
+	return nil, ethereum.NotFound
+}
+
+func (b *fakeBackend) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	return &types.Header{BaseFee: big.NewInt(1)}, nil
+}
+
+func (b *fakeBackend) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
+	return big.NewInt(2), nil
+}
+
+func (b *fakeBackend) SuggestGasTipCap(ctx context.Context) (*big.Int, error) {
+	return big.NewInt(1), nil
+}
+
+func (b *fakeBackend) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.sendErr != nil {
+		err := b.sendErr
+		b.sendErr = nil
+		return err
+	}
+
+	b.pool[tx.Hash()] = tx
+	b.sent = append(b.sent, tx)
+	return nil
+}
+
+// mine moves the transaction from the pool into a block
+func (b *fakeBackend) mine(hash common.Hash, status uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.pool, hash)
+	b.nonce += 1
+	b.receipts[hash] = &types.Receipt{Status: status, TxHash: hash, BlockNumber: new(big.Int).SetUint64(b.nonce)}
+}
+
+// drop removes the transaction from the pool without mining it
+func (b *fakeBackend) drop(hash common.Hash) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.pool, hash)
+}
+
+func testManager(t *testing.T, backend *fakeBackend) (*Manager, *store.Store) {
+	s, err := store.Open(filepath.Join(t.TempDir(), "state.json"))
+	assert.Nil(t, err)
+
+	key, err := crypto.GenerateKey()
+	assert.Nil(t, err)
+
+	m, err := New(backend, s, *bind.NewKeyedTransactor(key), &gas.Strategy{}, 1, time.Minute)
+	assert.Nil(t, err)
+
+	return m, s
+}
+
+// send builds, signs and sends a transaction the way a contract binding does
+func send(backend *fakeBackend) func(opts *bind.TransactOpts) (*types.Transaction, error) {
+	return func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		tx := types.NewTransaction(opts.Nonce.Uint64(), common.Address{}, big.NewInt(0), 21000, big.NewInt(100), nil)
+		signed, err := opts.Signer(opts.From, tx)
+		if err != nil {
+			return nil, err
+		}
+		return signed, backend.SendTransaction(context.Background(), signed)
+	}
+}
+
+func TestConcurrentSubmitsUseSequentialNonces(t *testing.T) {
+	backend := newFakeBackend()
+	m, s := testManager(t, backend)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := m.Submit("Unwrap Sarcophagus", send(backend))
+			assert.Nil(t, err)
+		}()
+	}
+	wg.Wait()
+
+	pending := m.Pending()
+	assert.Len(t, pending, 10)
+	for i, transaction := range pending {
+		assert.Equal(t, uint64(i), transaction.Nonce)
+	}
+
+	// pending transactions are tracked after a restart
+	restarted, err := New(backend, s, m.opts, m.gas, 1, time.Minute)
+	assert.Nil(t, err)
+	assert.Len(t, restarted.Pending(), 10)
+	assert.Equal(t, uint64(10), restarted.nonce)
+}
+
+func TestWaitReturnsReceipt(t *testing.T) {
+	backend := newFakeBackend()
+	m, s := testManager(t, backend)
+
+	mined, err := m.Submit("Register Archaeologist", send(backend))
+	assert.Nil(t, err)
+	reverted, err := m.Submit("Update Archaeologist", send(backend))
+	assert.Nil(t, err)
+
+	backend.mine(mined.Hash(), types.ReceiptStatusSuccessful)
+	backend.mine(reverted.Hash(), types.ReceiptStatusFailed)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		m.checkPending()
+	}()
+
+	receipt, err := m.Wait(context.Background(), mined.Hash())
+	assert.Nil(t, err)
+	assert.Equal(t, mined.Hash(), receipt.TxHash)
+
+	_, err = m.Wait(context.Background(), reverted.Hash())
+	assert.Equal(t, eth.ErrReverted, err)
+
+	assert.Len(t, m.Pending(), 0)
+	assert.Len(t, s.Keys(transactionsBucket), 0)
+}
+
+func TestDroppedTransactionIsResubmitted(t *testing.T) {
+	backend := newFakeBackend()
+	m, _ := testManager(t, backend)
+
+	tx, err := m.Submit("Unwrap Sarcophagus", send(backend))
+	assert.Nil(t, err)
+
+	backend.drop(tx.Hash())
+	m.checkPending()
+
+	assert.Len(t, backend.sent, 2)
+	assert.Equal(t, tx.Hash(), backend.sent[1].Hash())
+	assert.Len(t, m.Pending(), 1)
+}
+
+func TestTransactionReplacedByAnotherNonceUser(t *testing.T) {
+	backend := newFakeBackend()
+	m, _ := testManager(t, backend)
+
+	tx, err := m.Submit("Unwrap Sarcophagus", send(backend))
+	assert.Nil(t, err)
+
+	// another transaction with the same nonce was mined
+	backend.drop(tx.Hash())
+	backend.mine(common.Hash{1}, types.ReceiptStatusSuccessful)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		m.checkPending()
+	}()
+
+	_, err = m.Wait(context.Background(), tx.Hash())
+	assert.Equal(t, eth.ErrDropped, err)
+}
+
+func TestNonceIsSyncedWhenUsedElsewhere(t *testing.T) {
+	backend := newFakeBackend()
+	m, _ := testManager(t, backend)
+
+	// a transaction was sent from the same account outside of the manager
+	backend.nonce = 3
+	backend.sendErr = errors.New("nonce too low")
+
+	tx, err := m.Submit("Withdraw Bond", send(backend))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(3), tx.Nonce())
+	assert.Len(t, m.Pending(), 1)
+
+	// other errors are returned without using the nonce
+	backend.sendErr = errors.New("execution reverted")
+	_, err = m.Submit("Withdraw Bond", send(backend))
+	assert.NotNil(t, err)
+	assert.Equal(t, uint64(4), m.nonce)
+	assert.Len(t, m.Pending(), 1)
+}
+
+func TestStuckTransactionIsReplaced(t *testing.T) {
+	backend := newFakeBackend()
+	m, s := testManager(t, backend)
+
+	tx, err := m.Submit("Register Archaeologist", send(backend))
+	assert.Nil(t, err)
+
+	// not pending for long enough to be replaced
+	m.checkPending()
+	assert.Len(t, backend.sent, 1)
+
+	m.update(tx.Hash(), func(transaction *Transaction) {
+		transaction.LastSent = time.Now().Add(-BUMP_INTERVAL)
+	})
+	m.checkPending()
+
+	assert.Len(t, backend.sent, 2)
+	replacement := backend.sent[1]
+	assert.Equal(t, tx.Nonce(), replacement.Nonce())
+	assert.Equal(t, big.NewInt(115), replacement.GasPrice())
+
+	// the replacement is saved, and is tracked under the hash the transaction was submitted with
+	restarted, err := New(backend, s, m.opts, m.gas, 1, time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, []common.Hash{tx.Hash(), replacement.Hash()}, restarted.Pending()[0].Hashes)
+
+	backend.mine(replacement.Hash(), types.ReceiptStatusSuccessful)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		m.checkPending()
+	}()
+
+	receipt, err := m.Wait(context.Background(), tx.Hash())
+	assert.Nil(t, err)
+	assert.Equal(t, replacement.Hash(), receipt.TxHash)
+
+	// waiting again after the transaction has been resolved
+	receipt, err = m.Wait(context.Background(), tx.Hash())
+	assert.Nil(t, err)
+	assert.Equal(t, replacement.Hash(), receipt.TxHash)
+}
+
+func TestBumpsEscalateTowardsDeadline(t *testing.T) {
+	backend := newFakeBackend()
+	m, _ := testManager(t, backend)
+	m.gas.MaxPrice = big.NewInt(200)
+
+	tx, err := m.SubmitBefore("Unwrap Sarcophagus", time.Now().Add(time.Hour), send(backend))
+	assert.Nil(t, err)
+
+	// less than a quarter of the time until the deadline is left
+	m.update(tx.Hash(), func(transaction *Transaction) {
+		transaction.Submitted = time.Now().Add(-4 * time.Hour)
+		transaction.LastSent = time.Now().Add(-CRITICAL_BUMP_INTERVAL)
+	})
+	m.checkPending()
+
+	assert.Len(t, backend.sent, 2)
+	assert.Equal(t, big.NewInt(150), backend.sent[1].GasPrice())
+
+	// the next bump is limited by the max price
+	m.update(tx.Hash(), func(transaction *Transaction) {
+		transaction.LastSent = time.Now().Add(-CRITICAL_BUMP_INTERVAL)
+	})
+	m.checkPending()
+
+	assert.Len(t, backend.sent, 3)
+	assert.Equal(t, big.NewInt(200), backend.sent[2].GasPrice())
+
+	// no more room to bump
+	m.update(tx.Hash(), func(transaction *Transaction) {
+		transaction.LastSent = time.Now().Add(-CRITICAL_BUMP_INTERVAL)
+	})
+	m.checkPending()
+
+	assert.Len(t, backend.sent, 3)
+	assert.True(t, m.Pending()[0].AtMaxPrice)
+}
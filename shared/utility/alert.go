@@ -0,0 +1,53 @@
+// Alerts are used for problems the operator needs to act on (e.g. the service can no longer see contract events)
+// Alerts are always logged, and are also posted to ALERT_WEBHOOK if one is set in the config file
+
+package utility
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	alertWebhook   string
+	alertWebhookMu sync.RWMutex
+	alertClient    = &http.Client{Timeout: 10 * time.Second}
+)
+
+// SetAlertWebhook sets the url alerts are posted to. An empty url disables posting.
+func SetAlertWebhook(url string) {
+	alertWebhookMu.Lock()
+	defer alertWebhookMu.Unlock()
+
+	alertWebhook = url
+}
+
+// Alert logs the message and posts it to the alert webhook if one is set
+// The webhook receives a json body of {"text": message}, which is accepted by Slack and Discord compatible webhooks
+func Alert(format string, v ...interface{}) {
+	msg := fmt.Sprintf(format, v...)
+	log.Printf("**ALERT** %s", msg)
+
+	alertWebhookMu.RLock()
+	url := alertWebhook
+	alertWebhookMu.RUnlock()
+
+	if url == "" {
+		return
+	}
+
+	go func() {
+		body, _ := json.Marshal(map[string]string{"text": "Archaeologist: " + msg})
+		resp, err := alertClient.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Printf("Error posting alert to webhook: %v", err)
+			return
+		}
+		resp.Body.Close()
+	}()
+}
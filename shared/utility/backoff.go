@@ -0,0 +1,44 @@
+// Exponential backoff used when retrying calls to the eth node
+
+package utility
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Backoff doubles the wait between attempts, from Min up to Max
+// A random jitter of up to 20% is added so retries from different goroutines do not line up
+type Backoff struct {
+	Min     time.Duration
+	Max     time.Duration
+	attempt int
+}
+
+// NewBackoff .
+func NewBackoff(min time.Duration, max time.Duration) *Backoff {
+	return &Backoff{Min: min, Max: max}
+}
+
+// Next returns how long to wait before the next attempt
+func (b *Backoff) Next() time.Duration {
+	wait := b.Min << uint(b.attempt)
+	if wait > b.Max || wait <= 0 {
+		wait = b.Max
+	} else {
+		b.attempt += 1
+	}
+
+	jitter := time.Duration(rand.Int63n(int64(wait)/5 + 1))
+	return wait + jitter
+}
+
+// Attempts returns how many times Next has increased the wait since the last reset
+func (b *Backoff) Attempts() int {
+	return b.attempt
+}
+
+// Reset starts the backoff over from Min
+func (b *Backoff) Reset() {
+	b.attempt = 0
+}
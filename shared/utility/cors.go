@@ -0,0 +1,81 @@
+// CORS lets the Sarcophagus web app call the file server from a browser
+// Requests from an allowed origin are sent the CORS headers, and preflight requests are answered
+// without being passed on to the route they are for.
+package utility
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	CORS_ALLOWED_METHODS = "GET, POST, OPTIONS"
+	CORS_ALLOWED_HEADERS = "Content-Type"
+	CORS_EXPOSED_HEADERS = "Location"
+	CORS_MAX_AGE         = "600" // seconds a preflight response can be cached by the browser
+)
+
+// CORSMiddleware allows requests from allowedOrigins, which can include "*" to allow any origin
+func CORSMiddleware(allowedOrigins []string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		allowed := origin != "" && isAllowedOrigin(origin, allowedOrigins)
+		preflight := r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != ""
+
+		w.Header().Add("Vary", "Origin")
+		if allowed {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Expose-Headers", CORS_EXPOSED_HEADERS)
+		}
+
+		if !preflight {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !allowed {
+			http.Error(w, "Origin not allowed", http.StatusForbidden)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Methods", CORS_ALLOWED_METHODS)
+		w.Header().Set("Access-Control-Allow-Headers", CORS_ALLOWED_HEADERS)
+		w.Header().Set("Access-Control-Max-Age", CORS_MAX_AGE)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// isAllowedOrigin .
+func isAllowedOrigin(origin string, allowedOrigins []string) bool {
+	for _, allowed := range allowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ParseAllowedOrigins parses a comma separated list of origins (e.g. https://app.sarcophagus.io), or "*" for any origin
+func ParseAllowedOrigins(origins string) ([]string, error) {
+	var allowed []string
+	for _, origin := range strings.Split(origins, ",") {
+		origin = strings.TrimSpace(origin)
+		if origin == "" {
+			continue
+		}
+
+		if origin != "*" {
+			parsed, err := url.Parse(origin)
+			if err != nil || parsed.Scheme == "" || parsed.Host == "" || (parsed.Path != "" && parsed.Path != "/") {
+				return nil, fmt.Errorf("invalid origin: %v", origin)
+			}
+			origin = parsed.Scheme + "://" + parsed.Host
+		}
+		allowed = append(allowed, origin)
+	}
+
+	return allowed, nil
+}
@@ -0,0 +1,61 @@
+package utility
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORSMiddleware(t *testing.T) {
+	allowed, err := ParseAllowedOrigins("https://app.sarcophagus.io, http://localhost:3000/")
+	assert.Nil(t, err)
+
+	reached := 0
+	handler := CORSMiddleware(allowed, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached += 1
+	}))
+
+	serve := func(method string, origin string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/file", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		if method == "OPTIONS" {
+			r.Header.Set("Access-Control-Request-Method", "POST")
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	// preflights are answered without reaching the route
+	w := serve("OPTIONS", "http://localhost:3000")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "http://localhost:3000", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, CORS_ALLOWED_METHODS, w.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, 0, reached)
+
+	w = serve("OPTIONS", "https://evil.example.com")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+
+	w = serve("POST", "https://app.sarcophagus.io")
+	assert.Equal(t, "https://app.sarcophagus.io", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, 1, reached)
+
+	// requests from other origins are served, but the browser will not let the page read the response
+	w = serve("POST", "https://evil.example.com")
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, 2, reached)
+
+	wildcard, err := ParseAllowedOrigins("*")
+	assert.Nil(t, err)
+	handler = CORSMiddleware(wildcard, http.NotFoundHandler())
+	w = serve("OPTIONS", "https://evil.example.com")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "https://evil.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+
+	_, err = ParseAllowedOrigins("app.sarcophagus.io")
+	assert.NotNil(t, err)
+}
@@ -1,12 +1,21 @@
 package utility
 
 import (
+	"crypto/cipher"
 	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"encoding/binary"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/crypto/ecies"
+	"hash"
+	"io"
 	"log"
 )
 
+// DECRYPT_BUFFER_SIZE is how much of the file is decrypted at a time by DecryptFileHash
+const DECRYPT_BUFFER_SIZE = 32 * 1024
+
 // DecryptFile uses ecies library to decrypt file bytes
 // Used to validate correct public key was used on the sarcophagus payload when handling file upload to arweave
 func DecryptFile(fileBytes []byte, privateKey *ecdsa.PrivateKey) ([]byte, error) {
@@ -17,10 +26,104 @@ func DecryptFile(fileBytes []byte, privateKey *ecdsa.PrivateKey) ([]byte, error)
 	return eciesPrivateKey.Decrypt(fileBytes, nil, nil)
 }
 
+// DecryptFileHash decrypts the size bytes read from r like DecryptFile, and returns the keccak256 hash of the decrypted bytes
+// The file is decrypted as it is read, so it is never held in memory. The message is made of the ephemeral public key,
+// the AES-CTR iv and ciphertext, and an HMAC of the iv and ciphertext, which is checked once the whole file has been read.
+func DecryptFileHash(r io.Reader, size int64, privateKey *ecdsa.PrivateKey) ([]byte, error) {
+	prv := ecies.ImportECDSA(privateKey)
+	params := prv.Params
+	if params == nil {
+		return nil, ecies.ErrUnsupportedECIESParameters
+	}
+
+	rLen := int64(prv.PublicKey.Curve.Params().BitSize+7) / 4
+	hLen := int64(params.Hash().Size())
+	ctLen := size - rLen - int64(params.BlockSize) - hLen
+	if ctLen < 0 {
+		return nil, ecies.ErrInvalidMessage
+	}
+
+	header := make([]byte, rLen+int64(params.BlockSize))
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	ephemeral := new(ecies.PublicKey)
+	ephemeral.Curve = prv.PublicKey.Curve
+	ephemeral.X, ephemeral.Y = elliptic.Unmarshal(ephemeral.Curve, header[:rLen])
+	if ephemeral.X == nil {
+		return nil, ecies.ErrInvalidPublicKey
+	}
+
+	shared, err := prv.GenerateShared(ephemeral, params.KeyLen, params.KeyLen)
+	if err != nil {
+		return nil, err
+	}
+	encryptionKey, macKey := eciesKeys(params.Hash(), shared, params.KeyLen)
+
+	block, err := params.Cipher(encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	iv := header[rLen:]
+	stream := cipher.NewCTR(block, iv)
+	mac := hmac.New(params.Hash, macKey)
+	mac.Write(iv)
+	plainHash := crypto.NewKeccakState()
+
+	buf := make([]byte, DECRYPT_BUFFER_SIZE)
+	for remaining := ctLen; remaining > 0; {
+		chunk := buf
+		if remaining < int64(len(chunk)) {
+			chunk = chunk[:remaining]
+		}
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			return nil, err
+		}
+
+		mac.Write(chunk)
+		stream.XORKeyStream(chunk, chunk)
+		plainHash.Write(chunk)
+		remaining -= int64(len(chunk))
+	}
+
+	tag := make([]byte, hLen)
+	if _, err := io.ReadFull(r, tag); err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(tag, mac.Sum(nil)) {
+		return nil, ecies.ErrInvalidMessage
+	}
+
+	return plainHash.Sum(nil), nil
+}
+
+// eciesKeys derives the encryption and mac keys from the shared secret like the ecies package:
+// the NIST SP 800-56 concatenation KDF without shared info, with the mac key hashed
+func eciesKeys(h hash.Hash, shared []byte, keyLen int) ([]byte, []byte) {
+	counter := make([]byte, 4)
+	var k []byte
+	for i := uint32(1); len(k) < 2*keyLen; i++ {
+		binary.BigEndian.PutUint32(counter, i)
+		h.Reset()
+		h.Write(counter)
+		h.Write(shared)
+		k = h.Sum(k)
+	}
+
+	h.Reset()
+	h.Write(k[keyLen : 2*keyLen])
+	return k[:keyLen], h.Sum(nil)
+}
+
 // FileBytesToDoubleHashBytes is used to generate a sarcophagus identifier
 // File bytes passed as an argument here are expected to be encrypted by the recipient's public key
 func FileBytesToDoubleHashBytes(fileBytes []byte) [32]byte {
-	assetSingleHash := crypto.Keccak256(fileBytes)
+	return SingleHashToDoubleHashBytes(crypto.Keccak256(fileBytes))
+}
+
+// SingleHashToDoubleHashBytes generates a sarcophagus identifier from the hash of the file bytes
+func SingleHashToDoubleHashBytes(assetSingleHash []byte) [32]byte {
 	assetDoubleHash := crypto.Keccak256(assetSingleHash)
 
 	/* Convert Double Hash to 32 byte slice */
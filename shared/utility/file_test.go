@@ -0,0 +1,61 @@
+package utility
+
+import (
+	"bytes"
+	"crypto/rand"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/crypto/ecies"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDecryptFileHash(t *testing.T) {
+	privateKey, err := crypto.GenerateKey()
+	assert.Nil(t, err)
+	publicKey := ecies.ImportECDSAPublic(&privateKey.PublicKey)
+
+	// sizes around the buffer size, so the last chunk is full or partial
+	for _, size := range []int{1, DECRYPT_BUFFER_SIZE - 1, DECRYPT_BUFFER_SIZE, 3*DECRYPT_BUFFER_SIZE + 7} {
+		file := make([]byte, size)
+		rand.Read(file)
+		encrypted, err := ecies.Encrypt(rand.Reader, publicKey, file, nil, nil)
+		assert.Nil(t, err)
+
+		hash, err := DecryptFileHash(bytes.NewReader(encrypted), int64(len(encrypted)), privateKey)
+		assert.Nil(t, err, size)
+		assert.Equal(t, crypto.Keccak256(file), hash, size)
+	}
+}
+
+func TestDecryptFileHashInvalid(t *testing.T) {
+	privateKey, err := crypto.GenerateKey()
+	assert.Nil(t, err)
+	otherKey, err := crypto.GenerateKey()
+	assert.Nil(t, err)
+
+	encrypted, err := ecies.Encrypt(rand.Reader, ecies.ImportECDSAPublic(&privateKey.PublicKey), []byte("encrypted file"), nil, nil)
+	assert.Nil(t, err)
+
+	decryptHash := func(encrypted []byte, key []byte) error {
+		prv, err := crypto.ToECDSA(key)
+		assert.Nil(t, err)
+		_, err = DecryptFileHash(bytes.NewReader(encrypted), int64(len(encrypted)), prv)
+		return err
+	}
+
+	// encrypted for another key
+	assert.NotNil(t, decryptHash(encrypted, crypto.FromECDSA(otherKey)))
+
+	// tampered with
+	tampered := append([]byte{}, encrypted...)
+	tampered[len(tampered)-40] ^= 1
+	assert.Equal(t, ecies.ErrInvalidMessage, decryptHash(tampered, crypto.FromECDSA(privateKey)))
+
+	// too short, or not an ecies message
+	assert.NotNil(t, decryptHash(encrypted[:50], crypto.FromECDSA(privateKey)))
+	assert.NotNil(t, decryptHash(bytes.Repeat([]byte{1}, 200), crypto.FromECDSA(privateKey)))
+
+	// shorter than the size it was said to be
+	_, err = DecryptFileHash(bytes.NewReader(encrypted), int64(len(encrypted)+1), privateKey)
+	assert.NotNil(t, err)
+}
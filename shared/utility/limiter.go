@@ -1,20 +1,30 @@
 // Limits rate of connections to server
 // Mitigates DDOS attacks
+// Requests are limited per client IP. Behind a reverse proxy, the client IP is read from the
+// X-Forwarded-For or Forwarded header, but only when the request comes from a trusted proxy.
+// Limiters of clients that have not sent a request for LIMITER_IDLE_TIMEOUT are evicted.
 package utility
 
 import (
+	"fmt"
+	"net"
 	"net/http"
+	"strings"
 	"sync"
+	"time"
 
 	"golang.org/x/time/rate"
 )
 
-var limiter = NewIPRateLimiter(1, 5)
+const (
+	LIMITER_IDLE_TIMEOUT   = 10 * time.Minute
+	LIMITER_EVICT_INTERVAL = time.Minute
+)
 
-func LimitMiddleware(next http.Handler) http.Handler {
+// LimitMiddleware rejects requests from clients that are over their rate limit
+func LimitMiddleware(limiter *IPRateLimiter, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		limiter := limiter.GetLimiter(r.RemoteAddr)
-		if !limiter.Allow() {
+		if !limiter.Allow(r) {
 			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
 			return
 		}
@@ -23,51 +33,194 @@ func LimitMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// visitor is the rate limiter of a client IP
+type visitor struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
 // IPRateLimiter .
 type IPRateLimiter struct {
-	ips map[string]*rate.Limiter
-	mu  *sync.RWMutex
-	r   rate.Limit
-	b   int
+	ips       map[string]*visitor
+	mu        *sync.Mutex
+	r         rate.Limit
+	b         int
+	trusted   []*net.IPNet
+	lastEvict time.Time
+	now       func() time.Time
 }
 
-// NewIPRateLimiter .
-func NewIPRateLimiter(r rate.Limit, b int) *IPRateLimiter {
+// NewIPRateLimiter allows r requests per second with bursts of b requests from each client IP
+// trustedProxies are the IPs or CIDRs of the reverse proxies in front of the server
+func NewIPRateLimiter(r rate.Limit, b int, trustedProxies []*net.IPNet) *IPRateLimiter {
 	i := &IPRateLimiter{
-		ips: make(map[string]*rate.Limiter),
-		mu:  &sync.RWMutex{},
-		r:   r,
-		b:   b,
+		ips:     make(map[string]*visitor),
+		mu:      &sync.Mutex{},
+		r:       r,
+		b:       b,
+		trusted: trustedProxies,
+		now:     time.Now,
 	}
 
 	return i
 }
 
-// AddIP creates a new rate limiter and adds it to the ips map,
-// using the IP address as the key
-func (i *IPRateLimiter) AddIP(ip string) *rate.Limiter {
+// Allow returns true if the client that sent the request is within its rate limit
+func (i *IPRateLimiter) Allow(r *http.Request) bool {
+	return i.GetLimiter(ClientIP(r, i.trusted)).Allow()
+}
+
+// GetLimiter returns the rate limiter for the provided IP address, adding one if it does not exist
+// Idle limiters are evicted at most every LIMITER_EVICT_INTERVAL
+func (i *IPRateLimiter) GetLimiter(ip string) *rate.Limiter {
 	i.mu.Lock()
 	defer i.mu.Unlock()
 
-	limiter := rate.NewLimiter(i.r, i.b)
+	now := i.now()
+	if now.Sub(i.lastEvict) >= LIMITER_EVICT_INTERVAL {
+		i.evict(now)
+	}
 
-	i.ips[ip] = limiter
+	v, exists := i.ips[ip]
+	if !exists {
+		v = &visitor{limiter: rate.NewLimiter(i.r, i.b)}
+		i.ips[ip] = v
+	}
+	v.lastSeen = now
 
-	return limiter
+	return v.limiter
 }
 
-// GetLimiter returns the rate limiter for the provided IP address if it exists.
-// Otherwise calls AddIP to add IP address to the map
-func (i *IPRateLimiter) GetLimiter(ip string) *rate.Limiter {
+// Len returns the number of client IPs with a rate limiter
+func (i *IPRateLimiter) Len() int {
 	i.mu.Lock()
-	limiter, exists := i.ips[ip]
+	defer i.mu.Unlock()
 
-	if !exists {
-		i.mu.Unlock()
-		return i.AddIP(ip)
+	return len(i.ips)
+}
+
+// evict removes the limiters of clients that have been idle for LIMITER_IDLE_TIMEOUT
+func (i *IPRateLimiter) evict(now time.Time) {
+	for ip, v := range i.ips {
+		if now.Sub(v.lastSeen) >= LIMITER_IDLE_TIMEOUT {
+			delete(i.ips, ip)
+		}
+	}
+	i.lastEvict = now
+}
+
+// ClientIP returns the IP of the client that sent the request
+// If the request was sent by a trusted proxy, the forwarding headers are read from the nearest hop back,
+// and the first IP that is not a trusted proxy is returned.
+func ClientIP(r *http.Request, trustedProxies []*net.IPNet) string {
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remote = r.RemoteAddr
+	}
+
+	if !isTrusted(remote, trustedProxies) {
+		return remote
+	}
+
+	hops := forwardedFor(r.Header.Values("Forwarded"))
+	if len(hops) == 0 {
+		hops = xForwardedFor(r.Header.Values("X-Forwarded-For"))
+	}
+
+	client := remote
+	for h := len(hops) - 1; h >= 0; h-- {
+		if net.ParseIP(hops[h]) == nil {
+			// obfuscated or invalid identifier, nothing before it can be trusted
+			break
+		}
+		client = hops[h]
+		if !isTrusted(client, trustedProxies) {
+			break
+		}
 	}
 
-	i.mu.Unlock()
+	return client
+}
+
+// xForwardedFor returns the IPs in the X-Forwarded-For headers, the client first
+func xForwardedFor(headers []string) []string {
+	var hops []string
+	for _, header := range headers {
+		for _, hop := range strings.Split(header, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+
+	return hops
+}
+
+// forwardedFor returns the for= IPs in the Forwarded headers (RFC 7239), the client first
+func forwardedFor(headers []string) []string {
+	var hops []string
+	for _, header := range headers {
+		for _, element := range strings.Split(header, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				pair = strings.TrimSpace(pair)
+				if len(pair) < 4 || !strings.EqualFold(pair[:4], "for=") {
+					continue
+				}
+
+				// e.g. for=192.0.2.60, for="[2001:db8::1]:4711"
+				node := strings.Trim(pair[4:], "\"")
+				if host, _, err := net.SplitHostPort(node); err == nil {
+					node = host
+				}
+				hops = append(hops, strings.Trim(node, "[]"))
+			}
+		}
+	}
+
+	return hops
+}
+
+// isTrusted returns true if the ip is one of the trusted proxies
+func isTrusted(ip string, trustedProxies []*net.IPNet) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
 
-	return limiter
-}
\ No newline at end of file
+	for _, network := range trustedProxies {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ParseTrustedProxies parses a comma separated list of IPs and CIDRs
+func ParseTrustedProxies(proxies string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, proxy := range strings.Split(proxies, ",") {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy: %v", proxy)
+			}
+			bits := 32
+			if ip.To4() == nil {
+				bits = 128
+			}
+			proxy = fmt.Sprintf("%v/%v", proxy, bits)
+		}
+
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy: %v", proxy)
+		}
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
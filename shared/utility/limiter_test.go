@@ -0,0 +1,54 @@
+package utility
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted, err := ParseTrustedProxies("127.0.0.1, 10.0.0.0/8")
+	assert.Nil(t, err)
+
+	r := httptest.NewRequest("GET", "/ping", nil)
+	r.RemoteAddr = "203.0.113.7:51000"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+	// the headers of a client that is not a trusted proxy are ignored
+	assert.Equal(t, "203.0.113.7", ClientIP(r, trusted))
+
+	r.RemoteAddr = "127.0.0.1:51000"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1, 203.0.113.7, 10.0.0.2")
+	// the first untrusted hop back from the proxy is the client, anything before it may be spoofed
+	assert.Equal(t, "203.0.113.7", ClientIP(r, trusted))
+
+	r.Header.Del("X-Forwarded-For")
+	r.Header.Set("Forwarded", `for=198.51.100.1;proto=https, for="[2001:db8::1]:4711"`)
+	assert.Equal(t, "2001:db8::1", ClientIP(r, trusted))
+
+	// a request sent by the proxy itself
+	r.Header.Del("Forwarded")
+	assert.Equal(t, "127.0.0.1", ClientIP(r, trusted))
+
+	_, err = ParseTrustedProxies("localhost")
+	assert.NotNil(t, err)
+}
+
+func TestIPRateLimiterEvictsIdleClients(t *testing.T) {
+	now := time.Now()
+	limiter := NewIPRateLimiter(1, 2, nil)
+	limiter.now = func() time.Time { return now }
+
+	assert.True(t, limiter.GetLimiter("203.0.113.7").Allow())
+	assert.True(t, limiter.GetLimiter("203.0.113.7").Allow())
+	assert.False(t, limiter.GetLimiter("203.0.113.7").Allow())
+	limiter.GetLimiter("203.0.113.8")
+	assert.Equal(t, 2, limiter.Len())
+
+	now = now.Add(LIMITER_IDLE_TIMEOUT / 2)
+	limiter.GetLimiter("203.0.113.8")
+
+	now = now.Add(LIMITER_IDLE_TIMEOUT / 2)
+	limiter.GetLimiter("203.0.113.8")
+	assert.Equal(t, 1, limiter.Len())
+}
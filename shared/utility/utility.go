@@ -6,10 +6,10 @@ import (
 	"context"
 	"crypto/ecdsa"
 	"fmt"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/crypto"
-	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/shopspring/decimal"
 	"log"
 	"math/big"
@@ -31,7 +31,7 @@ func IsValidAddress(ethAddress string) bool {
 }
 
 // IsContract validates that provided address is a contract
-func IsContract(address common.Address, client *ethclient.Client) bool {
+func IsContract(address common.Address, client bind.ContractCaller) bool {
 	bytecode, err := client.CodeAt(context.Background(), address, nil)
 	if err != nil {
 		log.Fatalf("Could not get bytecode from contract address: %v", err)
@@ -161,11 +161,17 @@ func (s *ArchTestSuite) InitEmbalmer() {
 // simulateServiceRestart reinitializes the archaeologist to simulate a restart of the service
 func (s *ArchTestSuite) simulateServiceRestart() {
 	s.T().Log("Simulating Service Restart...")
-	s.arch.FileHandlers = map[[32]byte]*big.Int{}
-	s.arch.Sarcophaguses = map[[32]byte]*models.Sarco{}
+	s.arch.Registry = nil
 	_ = archaeologist.InitializeArchaeologist(s.arch, s.config)
 }
 
+// stateSarco returns the sarcophagus from the archaeologist state
+func (s *ArchTestSuite) stateSarco(doubleHash [32]byte) models.Sarco {
+	sarco, ok := s.arch.Registry.Sarco(doubleHash)
+	s.True(ok)
+	return sarco
+}
+
 // TransferSarcoToEmbalmer - transfers sarco tokens from the archaeologist to the embalmer
 // So that the embalmer has funds for its actions
 func (s *ArchTestSuite) TransferSarcoToEmbalmer(amount *big.Int) {
@@ -278,9 +284,9 @@ func (s *ArchTestSuite) TestArchaeologistHappyPathWorkflow() {
 	sarco, err := s.arch.SarcoSession.Sarcophagus(assetDoubleHashBytes)
 	s.Nil(err)
 	s.Equal("Test Sarco", sarco.Name)
-	s.Equal(1, len(s.arch.FileHandlers))
-	s.Equal(1, len(s.arch.Sarcophaguses))
-	s.Equal(sarco.ResurrectionTime, s.arch.Sarcophaguses[assetDoubleHashBytes].ResurrectionTime)
+	s.Equal(1, s.arch.Registry.FileHandlerCount())
+	s.Equal(1, s.arch.Registry.Len())
+	s.Equal(sarco.ResurrectionTime, s.stateSarco(assetDoubleHashBytes).ResurrectionTime)
 
 	/* Embalmer Creates Second Sarco */
 	log.Print("Creating Sarco 2")
@@ -290,16 +296,16 @@ func (s *ArchTestSuite) TestArchaeologistHappyPathWorkflow() {
 	sarcoTwo, err := s.arch.SarcoSession.Sarcophagus(assetDoubleHashBytesTwo)
 	s.Nil(err)
 	s.Equal("Test Sarco Two", sarcoTwo.Name)
-	s.Equal(2, len(s.arch.FileHandlers))
-	s.Equal(2, len(s.arch.Sarcophaguses))
-	s.Equal(sarcoTwo.ResurrectionTime, s.arch.Sarcophaguses[assetDoubleHashBytesTwo].ResurrectionTime)
+	s.Equal(2, s.arch.Registry.FileHandlerCount())
+	s.Equal(2, s.arch.Registry.Len())
+	s.Equal(sarcoTwo.ResurrectionTime, s.stateSarco(assetDoubleHashBytesTwo).ResurrectionTime)
 
 	/* Embalmer Updates First Sarco */
 	s.embalmer.UpdateSarcophagus(assetDoubleHashBytes, fileBytes)
 	time.Sleep(4000 * time.Millisecond)
-	s.Equal(2, len(s.arch.Sarcophaguses))
-	s.Equal(1, len(s.arch.FileHandlers))
-	s.Equal(1, s.arch.AccountIndex)
+	s.Equal(2, s.arch.Registry.Len())
+	s.Equal(1, s.arch.Registry.FileHandlerCount())
+	s.Equal(1, s.arch.Registry.AccountIndex())
 
 	/* Wait for unwrap and test unwrap result */
 	/* State = 2 means sarco is 'done' */
@@ -309,12 +315,12 @@ func (s *ArchTestSuite) TestArchaeologistHappyPathWorkflow() {
 	sarcoUnwrapped, err := s.arch.SarcoSession.Sarcophagus(assetDoubleHashBytes)
 	s.Nil(err)
 	s.Equal(uint8(2), sarcoUnwrapped.State)
-	s.Equal(1, len(s.arch.Sarcophaguses))
+	s.Equal(1, s.arch.Registry.Len())
 
 	/* Check state is correct on service restart */
 	s.simulateServiceRestart()
-	s.Equal(0, len(s.arch.Sarcophaguses))
-	s.Equal(0, len(s.arch.FileHandlers))
+	s.Equal(0, s.arch.Registry.Len())
+	s.Equal(0, s.arch.Registry.FileHandlerCount())
 
 	/* Embalmer Creates Third Sarco */
 	log.Print("Creating Sarco 3")
@@ -331,8 +337,8 @@ func (s *ArchTestSuite) TestArchaeologistHappyPathWorkflow() {
 
 	/* Check state is correct on service restart */
 	s.simulateServiceRestart()
-	s.Equal(2, len(s.arch.Sarcophaguses))
-	s.Equal(2, len(s.arch.FileHandlers))
+	s.Equal(2, s.arch.Registry.Len())
+	s.Equal(2, s.arch.Registry.FileHandlerCount())
 
 	/* Embalmer Updates Fourth Sarco */
 	s.embalmer.UpdateSarcophagus(assetDoubleHashBytesFour, fileBytesFour)
@@ -340,9 +346,9 @@ func (s *ArchTestSuite) TestArchaeologistHappyPathWorkflow() {
 
 	/* Check state is correct on service restart */
 	s.simulateServiceRestart()
-	s.Equal(1, len(s.arch.Sarcophaguses))
-	s.Equal(s.embalmer.ResurrectionTime, s.arch.Sarcophaguses[assetDoubleHashBytesFour].ResurrectionTime)
-	s.Equal(0, len(s.arch.FileHandlers))
+	s.Equal(1, s.arch.Registry.Len())
+	s.Equal(s.embalmer.ResurrectionTime, s.stateSarco(assetDoubleHashBytesFour).ResurrectionTime)
+	s.Equal(0, s.arch.Registry.FileHandlerCount())
 
 	/*
 		Wait for unwrap and test unwrap result
@@ -361,7 +367,7 @@ func (s *ArchTestSuite) TestArchaeologistHappyPathWorkflow() {
 	sarcoUnwrapped, err = s.arch.SarcoSession.Sarcophagus(assetDoubleHashBytesFour)
 	s.Nil(err)
 	s.Equal(uint8(2), sarcoUnwrapped.State)
-	s.Equal(0, len(s.arch.Sarcophaguses))
+	s.Equal(0, s.arch.Registry.Len())
 	time.Sleep(5000 * time.Millisecond)
 
 	/* Embalmer Creates Fifth Sarco */
@@ -397,5 +403,5 @@ func (s *ArchTestSuite) TestArchaeologistHappyPathWorkflow() {
 	time.Sleep(2000 * time.Millisecond)
 	s.Nil(err)
 	s.Equal(uint8(2), sarcoUnwrapped.State)
-	s.Equal(0, len(s.arch.Sarcophaguses))
+	s.Equal(0, s.arch.Registry.Len())
 }